@@ -0,0 +1,176 @@
+// Package apierror writes errors in the envelope shared by every endpoint:
+//
+//	{"error": {"code": "session_full", "message": "Session is full.", "details": ...}}
+//
+// Clients branch on the code; the message is for humans and may change.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// Error codes.
+const (
+	CodeInvalidRequest     = "invalid_request"   // body or query could not be parsed
+	CodeValidationFailed   = "validation_failed" // details lists the offending fields
+	CodeUnauthorized       = "unauthorized"      // no credentials
+	CodePermissionDenied   = "permission_denied" // the role in the room doesn't allow it
+	CodeInvalidToken       = "invalid_token"     // bad or expired access, refresh or mailed token
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeIncorrectPassword  = "incorrect_password" // re-authentication of a signed in user failed
+	CodeAccountDeleted     = "account_deleted"
+	CodeNotFound           = "not_found"
+	CodeRouteNotFound      = "route_not_found"
+	CodeEmailTaken         = "email_taken"
+	CodeUsernameTaken      = "username_taken"
+	CodeAlreadyExists      = "already_exists"
+	CodeEmailVerified      = "email_already_verified"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeRoomCodeConflict   = "room_code_conflict"
+	CodeNotScheduled       = "session_not_scheduled"
+	CodeSessionNotStarted  = "session_not_started"
+	CodeSessionEnded       = "session_ended"
+	CodeSessionLocked      = "session_locked"
+	CodeSessionFull        = "session_full"
+	CodeMailFailed         = "mail_delivery_failed"
+	CodeTooManyAttempts    = "too_many_attempts"    // details.retry_after is in seconds, or details.remaining counts emails
+	CodeSSOFailed          = "sso_failed"           // sign-in with the provider could not be completed
+	CodeSSODenied          = "sso_denied"           // the provider or the user refused the sign-in
+	CodeSSONoEmail         = "sso_email_missing"    // the provider did not share an email address
+	CodeSSOEmailUnverified = "sso_email_unverified" // the provider did not verify the email address
+	CodeInternal           = "internal_error"
+)
+
+// Error - The body of the envelope.
+type Error struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// FieldError - A detail entry of a validation_failed error.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type envelope struct {
+	Error Error `json:"error"`
+}
+
+// Write - Responds with an error.
+func Write(c *gin.Context, status int, code, message string) {
+	c.JSON(status, envelope{Error{Code: code, Message: message}})
+}
+
+// WriteDetails - Responds with an error carrying extra details.
+func WriteDetails(c *gin.Context, status int, code, message string, details interface{}) {
+	c.JSON(status, envelope{Error{Code: code, Message: message, Details: details}})
+}
+
+// Abort - Responds with an error and stops the handler chain.
+func Abort(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, envelope{Error{Code: code, Message: message}})
+}
+
+// Internal - Responds with a 500 without revealing the cause, which the
+// caller is expected to have logged.
+func Internal(c *gin.Context) {
+	Write(c, http.StatusInternalServerError, CodeInternal, "Internal server error.")
+}
+
+// Bind - Responds to a failed ShouldBind call: validation failures list
+// each field, anything else is reported as an unparsable request.
+func Bind(c *gin.Context, err error) {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		Write(c, http.StatusBadRequest, CodeInvalidRequest, "Request could not be parsed.")
+		return
+	}
+
+	details := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		details = append(details, FieldError{
+			Field:   fieldPath(fe),
+			Rule:    fe.Tag(),
+			Message: fieldMessage(fe),
+		})
+	}
+	Invalid(c, details...)
+}
+
+// Invalid - Responds with a validation failure for the given fields.
+func Invalid(c *gin.Context, fields ...FieldError) {
+	WriteDetails(c, http.StatusBadRequest, CodeValidationFailed, "Some fields are invalid.", fields)
+}
+
+// WriteHTTP - Writes an error from a plain net/http handler.
+func WriteHTTP(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(envelope{Error{Code: code, Message: message}})
+}
+
+// NotFound - Handler for unknown routes.
+func NotFound(c *gin.Context) {
+	Write(c, http.StatusNotFound, CodeRouteNotFound, "No such endpoint.")
+}
+
+// Recovery - Handler for recovered panics.
+func Recovery(c *gin.Context, _ interface{}) {
+	c.Abort()
+	Internal(c)
+}
+
+// fieldPath - The dotted path of the field below the bound struct, e.g.
+// "settings.max_participants".
+func fieldPath(fe validator.FieldError) string {
+	if _, path, ok := strings.Cut(fe.Namespace(), "."); ok {
+		return path
+	}
+	return fe.Field()
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	field := fieldPath(fe)
+	switch fe.Tag() {
+	case "required":
+		return field + " is required."
+	case "email":
+		return field + " must be a valid email address."
+	case "password":
+		return field + " must be 8 to 72 characters and contain a letter and a digit."
+	case "notblank":
+		return field + " must not be blank."
+	case "min":
+		if fe.Kind() == reflect.String {
+			return field + " must be at least " + fe.Param() + " characters."
+		}
+		if fe.Kind() == reflect.Slice {
+			return field + " must have at least " + fe.Param() + " items."
+		}
+		return field + " must be at least " + fe.Param() + "."
+	case "max":
+		if fe.Kind() == reflect.String {
+			return field + " must be at most " + fe.Param() + " characters."
+		}
+		if fe.Kind() == reflect.Slice {
+			return field + " must have at most " + fe.Param() + " items."
+		}
+		return field + " must be at most " + fe.Param() + "."
+	case "oneof":
+		return field + " must be one of: " + fe.Param() + "."
+	case "unique":
+		return field + " must not contain duplicates."
+	default:
+		return field + " is invalid."
+	}
+}
@@ -0,0 +1,51 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// decodeEnvelope - Decodes an error response, failing unless it has the
+// wanted status.
+func decodeEnvelope(t *testing.T, w *httptest.ResponseRecorder, status int) Error {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("content type = %q", ct)
+	}
+	var body envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", w.Body, err)
+	}
+	return body.Error
+}
+
+func TestRecoveryHidesPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.CustomRecovery(Recovery))
+	router.GET("/panic", func(*gin.Context) { panic("secret detail") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	got := decodeEnvelope(t, w, http.StatusInternalServerError)
+	if got.Code != CodeInternal || got.Message != "Internal server error." {
+		t.Errorf("error = %+v", got)
+	}
+}
+
+func TestWriteHTTP(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteHTTP(w, http.StatusUnauthorized, CodeInvalidToken, "Join token is invalid.")
+	got := decodeEnvelope(t, w, http.StatusUnauthorized)
+	if got != (Error{Code: CodeInvalidToken, Message: "Join token is invalid."}) {
+		t.Errorf("error = %+v", got)
+	}
+}
@@ -0,0 +1,71 @@
+// Package audio decodes, mixes and re-encodes audio for server-side mixing.
+// Mixing works on 48 kHz mono 16-bit PCM, which codecs convert to and from.
+// Codecs are pluggable: G.711 μ-law is built in and others, such as an
+// Opus binding, register themselves with Register.
+package audio
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SampleRate - Samples per second of the PCM that is mixed.
+	SampleRate = 48000
+	// FrameDuration - How much audio is mixed and sent at a time.
+	FrameDuration = 20 * time.Millisecond
+	// FrameSamples - Samples in one frame.
+	FrameSamples = SampleRate / int(time.Second/FrameDuration)
+)
+
+// Codec - An RTP audio codec the mixer can decode and encode.
+type Codec interface {
+	MimeType() string  // as negotiated, e.g. audio/PCMU
+	ClockRate() uint32 // RTP clock rate
+	Channels() uint16
+	NewDecoder() (Decoder, error)
+	NewEncoder() (Encoder, error)
+}
+
+// Decoder - Decodes the payloads of one incoming stream. Decoders keep
+// state between packets, so each stream needs its own.
+type Decoder interface {
+	// Decode - Decodes one RTP payload into mono PCM at SampleRate.
+	Decode(payload []byte) ([]int16, error)
+}
+
+// Encoder - Encodes one outgoing stream.
+type Encoder interface {
+	// Encode - Encodes FrameSamples of mono PCM at SampleRate into one RTP
+	// payload.
+	Encode(frame []int16) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{} // by lower case MIME type
+)
+
+// Register - Makes a codec available for mixing. It panics if a codec with
+// the same MIME type is already registered.
+func Register(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	mimeType := strings.ToLower(codec.MimeType())
+	if _, ok := codecs[mimeType]; ok {
+		panic(fmt.Sprintf("audio: codec %s registered twice", codec.MimeType()))
+	}
+	codecs[mimeType] = codec
+}
+
+// Lookup - Finds the registered codec for a MIME type, ignoring case.
+func Lookup(mimeType string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[strings.ToLower(mimeType)]
+	return codec, ok
+}
@@ -0,0 +1,85 @@
+package audio
+
+// G.711 μ-law runs at 8 kHz, a sixth of the mixing rate.
+const pcmuRatio = SampleRate / 8000
+
+const (
+	ulawBias = 0x84
+	ulawClip = 32635
+)
+
+func init() {
+	Register(pcmu{})
+}
+
+// pcmu - G.711 μ-law, which every WebRTC endpoint and phone gateway
+// supports.
+type pcmu struct{}
+
+func (pcmu) MimeType() string  { return "audio/PCMU" }
+func (pcmu) ClockRate() uint32 { return 8000 }
+func (pcmu) Channels() uint16  { return 1 }
+
+func (pcmu) NewDecoder() (Decoder, error) { return &pcmuDecoder{}, nil }
+func (pcmu) NewEncoder() (Encoder, error) { return pcmuEncoder{}, nil }
+
+// pcmuDecoder - Upsamples by interpolating from the previous sample.
+type pcmuDecoder struct {
+	last int16
+}
+
+func (d *pcmuDecoder) Decode(payload []byte) ([]int16, error) {
+	pcm := make([]int16, 0, len(payload)*pcmuRatio)
+	for _, b := range payload {
+		sample := ulawDecode(b)
+		step := (int32(sample) - int32(d.last)) / pcmuRatio
+		for i := int32(1); i <= pcmuRatio; i++ {
+			pcm = append(pcm, int16(int32(d.last)+step*i))
+		}
+		d.last = sample
+	}
+	return pcm, nil
+}
+
+// pcmuEncoder - Downsamples by averaging.
+type pcmuEncoder struct{}
+
+func (pcmuEncoder) Encode(frame []int16) ([]byte, error) {
+	payload := make([]byte, len(frame)/pcmuRatio)
+	for i := range payload {
+		sum := int32(0)
+		for _, sample := range frame[i*pcmuRatio : (i+1)*pcmuRatio] {
+			sum += int32(sample)
+		}
+		payload[i] = ulawEncode(int16(sum / pcmuRatio))
+	}
+	return payload, nil
+}
+
+func ulawEncode(sample int16) byte {
+	s := int32(sample)
+	sign := byte(0)
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	s = min(s, ulawClip) + ulawBias
+
+	exponent := byte(7)
+	for mask := int32(0x4000); s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := byte(s>>(exponent+3)) & 0x0F
+	return ^(sign | exponent<<4 | mantissa)
+}
+
+func ulawDecode(b byte) int16 {
+	b = ^b
+	exponent := (b >> 4) & 0x07
+	s := ((int32(b&0x0F) << 3) + ulawBias) << exponent
+	s -= ulawBias
+	if b&0x80 != 0 {
+		s = -s
+	}
+	return int16(s)
+}
@@ -0,0 +1,72 @@
+package audio
+
+import "testing"
+
+func TestULawRoundTrip(t *testing.T) {
+	for _, sample := range []int16{0, 1, -1, 100, -100, 1000, -1000, 8000, -8000, 32000, -32000} {
+		got := ulawDecode(ulawEncode(sample))
+		// μ-law keeps about four bits of mantissa, so the error grows
+		// with the magnitude
+		tolerance := max(int32(sample), -int32(sample))/16 + 8
+		if diff := int32(got) - int32(sample); diff > tolerance || diff < -tolerance {
+			t.Errorf("round trip of %d gave %d, want within %d", sample, got, tolerance)
+		}
+	}
+}
+
+func TestULawKnownValues(t *testing.T) {
+	tests := []struct {
+		sample int16
+		code   byte
+	}{
+		{0, 0xFF},
+		{-1, 0x7F},
+		{32767, 0x80},
+		{-32768, 0x00},
+	}
+	for _, test := range tests {
+		if got := ulawEncode(test.sample); got != test.code {
+			t.Errorf("ulawEncode(%d) = %#x, want %#x", test.sample, got, test.code)
+		}
+	}
+	if got := ulawDecode(0xFF); got != 0 {
+		t.Errorf("ulawDecode(0xff) = %d, want 0", got)
+	}
+}
+
+func TestPCMUFrameSizes(t *testing.T) {
+	codec, ok := Lookup("audio/pcmu")
+	if !ok {
+		t.Fatal("PCMU is not registered")
+	}
+	decoder, _ := codec.NewDecoder()
+	encoder, _ := codec.NewEncoder()
+
+	// 20 ms at 8 kHz
+	payload := make([]byte, 160)
+	for i := range payload {
+		payload[i] = ulawEncode(4000)
+	}
+	pcm, err := decoder.Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcm) != FrameSamples {
+		t.Fatalf("decoded %d samples, want %d", len(pcm), FrameSamples)
+	}
+	// The first sample ramps up from silence, the rest hold the level
+	if last := pcm[len(pcm)-1]; last < 3800 || last > 4200 {
+		t.Errorf("decoded level %d, want about 4000", last)
+	}
+
+	encoded, err := encoder.Encode(pcm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) != len(payload) {
+		t.Fatalf("encoded %d bytes, want %d", len(encoded), len(payload))
+	}
+	if got := ulawDecode(encoded[len(encoded)-1]); got < 3800 || got > 4200 {
+		t.Errorf("re-encoded level %d, want about 4000", got)
+	}
+}
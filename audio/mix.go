@@ -0,0 +1,24 @@
+package audio
+
+import "math"
+
+// Accumulate - Adds a frame to a running sum. The sum is kept wider than a
+// sample so that loud mixes only clip once, when rendered.
+func Accumulate(sum []int32, frame []int16) {
+	for i := 0; i < min(len(sum), len(frame)); i++ {
+		sum[i] += int32(frame[i])
+	}
+}
+
+// Render - Writes the sum minus the listener's own part of it, which may be
+// nil, into dst, clipping to the sample range. Leaving out the listener's
+// own voice avoids echoing it back.
+func Render(dst []int16, sum, own []int32) {
+	for i := 0; i < min(len(dst), len(sum)); i++ {
+		sample := sum[i]
+		if i < len(own) {
+			sample -= own[i]
+		}
+		dst[i] = int16(max(math.MinInt16, min(math.MaxInt16, sample)))
+	}
+}
@@ -0,0 +1,46 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestRenderLeavesOutOwnVoice(t *testing.T) {
+	sum := make([]int32, 3)
+	own := make([]int32, 3)
+	Accumulate(sum, []int16{100, 200, 300})
+	Accumulate(sum, []int16{10, 20, 30})
+	Accumulate(own, []int16{10, 20, 30})
+
+	dst := make([]int16, 3)
+	Render(dst, sum, own)
+	if want := []int16{100, 200, 300}; !slices.Equal(dst, want) {
+		t.Errorf("with own voice removed got %v, want %v", dst, want)
+	}
+
+	Render(dst, sum, nil)
+	if want := []int16{110, 220, 330}; !slices.Equal(dst, want) {
+		t.Errorf("without own voice got %v, want %v", dst, want)
+	}
+}
+
+func TestRenderClips(t *testing.T) {
+	sum := make([]int32, 2)
+	Accumulate(sum, []int16{30000, -30000})
+	Accumulate(sum, []int16{30000, -30000})
+
+	dst := make([]int16, 2)
+	Render(dst, sum, nil)
+	if want := []int16{math.MaxInt16, math.MinInt16}; !slices.Equal(dst, want) {
+		t.Errorf("got %v, want %v", dst, want)
+	}
+}
+
+func TestAccumulateShortFrame(t *testing.T) {
+	sum := make([]int32, 4)
+	Accumulate(sum, []int16{1, 2})
+	if sum[0] != 1 || sum[1] != 2 || sum[2] != 0 || sum[3] != 0 {
+		t.Errorf("got %v, want [1 2 0 0]", sum)
+	}
+}
@@ -0,0 +1,693 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config - Application configuration.
+type Config struct {
+	Store     string    `yaml:"store" toml:"store"` // mongo or memory
+	Server    Server    `yaml:"server" toml:"server"`
+	Mongo     Mongo     `yaml:"mongo" toml:"mongo"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	Log       Log       `yaml:"log" toml:"log"`
+	WebRTC    WebRTC    `yaml:"webrtc" toml:"webrtc"`
+	RoomCode  RoomCode  `yaml:"room_code" toml:"room_code"`
+	Schedule  Schedule  `yaml:"schedule" toml:"schedule"`
+	Mail      Mail      `yaml:"mail" toml:"mail"`
+	Password  Password  `yaml:"password" toml:"password"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	OIDC      OIDC      `yaml:"oidc" toml:"oidc"`
+}
+
+// Server - HTTP server settings.
+type Server struct {
+	Port    string `yaml:"port" toml:"port"`
+	HostURL string `yaml:"host_url" toml:"host_url"`
+	// TrustedProxies may set X-Forwarded-For; client IPs from anyone else's
+	// headers are ignored. Empty trusts no proxy.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// JoinURL is the link participants follow to join a room; "{code}" is
+	// replaced by the room code. Defaults to HostURL + "/join/{code}".
+	JoinURL string `yaml:"join_url" toml:"join_url"`
+}
+
+// Mongo - Database settings.
+type Mongo struct {
+	URI      string `yaml:"uri" toml:"uri"`
+	Database string `yaml:"database" toml:"database"`
+}
+
+// Auth - Token signing settings.
+type Auth struct {
+	JWTSecret string `yaml:"jwt_secret" toml:"jwt_secret"`
+	// AccessTokenTTL bounds how long a stolen access token works. Signing
+	// out and the like revoke access tokens by ID before then.
+	AccessTokenTTL  Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	RefreshTokenTTL Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+	// JoinTokenTTL is how long a room join token can be redeemed for.
+	JoinTokenTTL Duration `yaml:"join_token_ttl" toml:"join_token_ttl"`
+}
+
+// Log - Logger settings.
+type Log struct {
+	Level      string `yaml:"level" toml:"level"`
+	Format     string `yaml:"format" toml:"format"`
+	File       string `yaml:"file" toml:"file"`
+	MaxSizeMB  int    `yaml:"max_size_mb" toml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups" toml:"max_backups"`
+	MaxAgeDays int    `yaml:"max_age_days" toml:"max_age_days"`
+	Compress   bool   `yaml:"compress" toml:"compress"`
+}
+
+// WebRTC - SFU settings.
+type WebRTC struct {
+	ICEServers       []string `yaml:"ice_servers" toml:"ice_servers"`
+	KeyframeInterval Duration `yaml:"keyframe_interval" toml:"keyframe_interval"`
+	SpeakerInterval  Duration `yaml:"speaker_interval" toml:"speaker_interval"` // how often the active speaker is re-evaluated
+	MixCodec         string   `yaml:"mix_codec" toml:"mix_codec"`               // codec of mixed audio tracks, e.g. audio/PCMU
+	Codecs           Codecs   `yaml:"codecs" toml:"codecs"`
+}
+
+// Codecs - The codecs peers may negotiate, most preferred first. Sessions
+// can narrow them further.
+type Codecs struct {
+	Audio []string `yaml:"audio" toml:"audio"` // opus, pcmu, pcma or g722
+	Video []string `yaml:"video" toml:"video"` // vp8, vp9, h264 or av1
+	// H264Profiles are the profile-level-id values offered for H.264, e.g.
+	// 42e01f for constrained baseline, which every browser decodes.
+	H264Profiles []string `yaml:"h264_profiles" toml:"h264_profiles"`
+	OpusFEC      bool     `yaml:"opus_fec" toml:"opus_fec"` // in-band forward error correction
+	OpusDTX      bool     `yaml:"opus_dtx" toml:"opus_dtx"` // discontinuous transmission, saves bandwidth in silence
+}
+
+// RoomCode - Room code generation settings.
+type RoomCode struct {
+	Alphabet    string `yaml:"alphabet" toml:"alphabet"`
+	Pattern     string `yaml:"pattern" toml:"pattern"` // group lengths, e.g. "3-4-3"
+	MaxAttempts int    `yaml:"max_attempts" toml:"max_attempts"`
+}
+
+// Schedule - Join window around scheduled sessions.
+type Schedule struct {
+	EarlyJoin   Duration `yaml:"early_join" toml:"early_join"`     // how long before the start joins open
+	GracePeriod Duration `yaml:"grace_period" toml:"grace_period"` // how long after the end rooms stay open
+}
+
+// Mail - Outgoing email settings.
+type Mail struct {
+	Driver string `yaml:"driver" toml:"driver"` // smtp, file or memory
+	From   string `yaml:"from" toml:"from"`
+	Dir    string `yaml:"dir" toml:"dir"` // output directory of the file driver
+	SMTP   SMTP   `yaml:"smtp" toml:"smtp"`
+	// VerifyURL and ResetURL are the links mailed to users; "{token}" is
+	// replaced by the one-time token. Default to HostURL + "/verify-email"
+	// and HostURL + "/reset-password".
+	VerifyURL       string   `yaml:"verify_url" toml:"verify_url"`
+	ResetURL        string   `yaml:"reset_url" toml:"reset_url"`
+	VerificationTTL Duration `yaml:"verification_ttl" toml:"verification_ttl"`
+	ResetTTL        Duration `yaml:"reset_ttl" toml:"reset_ttl"`
+}
+
+// SMTP - Mail relay settings.
+type SMTP struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+// Password - Password hashing policy. Hashes made under an older policy are
+// upgraded when their owner next signs in.
+type Password struct {
+	Algorithm  string `yaml:"algorithm" toml:"algorithm"` // bcrypt or argon2id
+	BcryptCost int    `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	Argon2     Argon2 `yaml:"argon2" toml:"argon2"`
+}
+
+// Argon2 - argon2id parameters.
+type Argon2 struct {
+	Time     int `yaml:"time" toml:"time"` // iterations
+	MemoryKB int `yaml:"memory_kb" toml:"memory_kb"`
+	Threads  int `yaml:"threads" toml:"threads"`
+}
+
+// RateLimit - Backoff for password checks on /login and /connect, and for
+// the emails anyone can make the server send.
+type RateLimit struct {
+	// Store is memory, or mongo to share counters between instances.
+	Store   string      `yaml:"store" toml:"store"`
+	IP      LimitPolicy `yaml:"ip" toml:"ip"`           // per client IP
+	Account LimitPolicy `yaml:"account" toml:"account"` // per account or room
+	// The email policies count messages sent rather than failures.
+	PasswordReset LimitPolicy `yaml:"password_reset" toml:"password_reset"` // reset and verification emails per address
+	Invitations   LimitPolicy `yaml:"invitations" toml:"invitations"`       // invitation recipients per sending account
+}
+
+// LimitPolicy - After FreeAttempts failures within Window, each further
+// failure locks the key for BaseDelay, doubling up to MaxDelay.
+type LimitPolicy struct {
+	FreeAttempts int      `yaml:"free_attempts" toml:"free_attempts"`
+	BaseDelay    Duration `yaml:"base_delay" toml:"base_delay"`
+	MaxDelay     Duration `yaml:"max_delay" toml:"max_delay"`
+	Window       Duration `yaml:"window" toml:"window"`
+}
+
+// OIDC - Single sign-on through OpenID Connect providers.
+type OIDC struct {
+	// RedirectURL is the callback registered with the providers; "{provider}"
+	// is replaced by the provider name. Defaults to HostURL +
+	// "/auth/oidc/{provider}/callback".
+	RedirectURL string `yaml:"redirect_url" toml:"redirect_url"`
+	// CompleteURL is the frontend page a finished sign-in lands on, with a
+	// one-time "code" to exchange for tokens or an "error". Defaults to
+	// HostURL + "/auth/complete".
+	CompleteURL string         `yaml:"complete_url" toml:"complete_url"`
+	Providers   []OIDCProvider `yaml:"providers" toml:"providers"`
+}
+
+// OIDCProvider - One identity provider. Name appears in URLs and is stored
+// with linked identities, so it must not change once users have signed in.
+type OIDCProvider struct {
+	Name         string   `yaml:"name" toml:"name"`
+	DisplayName  string   `yaml:"display_name" toml:"display_name"`
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"` // "openid" is always requested
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"`
+	// TrustEmail treats every email the provider asserts as verified, for
+	// providers that omit the email_verified claim. Verified emails sign in
+	// to the existing account with that address if the account verified it
+	// too; a new identity with an unverified email is refused.
+	TrustEmail bool `yaml:"trust_email" toml:"trust_email"`
+}
+
+// Duration - time.Duration that decodes from strings such as "3s".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText - Parses a duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText - Formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Default - Returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Store: "mongo",
+		Server: Server{
+			Port:    "9000",
+			HostURL: "http://localhost",
+		},
+		Mongo: Mongo{
+			Database: "MeetKobi",
+		},
+		Auth: Auth{
+			AccessTokenTTL:  Duration{15 * time.Minute},
+			RefreshTokenTTL: Duration{30 * 24 * time.Hour},
+			JoinTokenTTL:    Duration{5 * time.Minute},
+		},
+		Log: Log{
+			Level:      "info",
+			Format:     "text",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+			MaxAgeDays: 28,
+		},
+		WebRTC: WebRTC{
+			KeyframeInterval: Duration{3 * time.Second},
+			SpeakerInterval:  Duration{300 * time.Millisecond},
+			MixCodec:         "audio/PCMU",
+			Codecs: Codecs{
+				Audio:        []string{"opus", "pcmu", "pcma", "g722"},
+				Video:        []string{"vp8", "h264", "vp9", "av1"},
+				H264Profiles: []string{"42e01f", "42001f", "4d001f"},
+				OpusFEC:      true,
+			},
+		},
+		RoomCode: RoomCode{
+			Alphabet:    "abcdefghijklmnopqrstuvwxyz",
+			Pattern:     "3-4-3",
+			MaxAttempts: 5,
+		},
+		Schedule: Schedule{
+			EarlyJoin:   Duration{10 * time.Minute},
+			GracePeriod: Duration{15 * time.Minute},
+		},
+		Mail: Mail{
+			Driver:          "file",
+			From:            "MeetKobi <no-reply@localhost>",
+			Dir:             "mail",
+			SMTP:            SMTP{Port: 587},
+			VerificationTTL: Duration{48 * time.Hour},
+			ResetTTL:        Duration{time.Hour},
+		},
+		RateLimit: RateLimit{
+			Store: "memory",
+			IP: LimitPolicy{
+				FreeAttempts: 20,
+				BaseDelay:    Duration{time.Second},
+				MaxDelay:     Duration{15 * time.Minute},
+				Window:       Duration{15 * time.Minute},
+			},
+			Account: LimitPolicy{
+				FreeAttempts: 5,
+				BaseDelay:    Duration{time.Second},
+				MaxDelay:     Duration{15 * time.Minute},
+				Window:       Duration{15 * time.Minute},
+			},
+			PasswordReset: LimitPolicy{
+				FreeAttempts: 3,
+				BaseDelay:    Duration{time.Minute},
+				MaxDelay:     Duration{time.Hour},
+				Window:       Duration{time.Hour},
+			},
+			Invitations: LimitPolicy{
+				FreeAttempts: 100,
+				BaseDelay:    Duration{time.Minute},
+				MaxDelay:     Duration{24 * time.Hour},
+				Window:       Duration{24 * time.Hour},
+			},
+		},
+		Password: Password{
+			Algorithm:  "bcrypt",
+			BcryptCost: 12,
+			Argon2: Argon2{
+				Time:     3,
+				MemoryKB: 64 * 1024,
+				Threads:  2,
+			},
+		},
+	}
+}
+
+// Load - Builds the configuration from defaults, an optional YAML or TOML
+// file, the .env file and the environment, in increasing order of
+// precedence, and validates the result. When file is empty the CONFIG_FILE
+// variable is consulted.
+func Load(file string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading .env: %w", err)
+	}
+
+	cfg := Default()
+
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	host := strings.TrimRight(cfg.Server.HostURL, "/")
+	if cfg.Server.JoinURL == "" {
+		cfg.Server.JoinURL = host + "/join/{code}"
+	}
+	if cfg.Mail.VerifyURL == "" {
+		cfg.Mail.VerifyURL = host + "/verify-email?token={token}"
+	}
+	if cfg.Mail.ResetURL == "" {
+		cfg.Mail.ResetURL = host + "/reset-password?token={token}"
+	}
+	if cfg.OIDC.RedirectURL == "" {
+		cfg.OIDC.RedirectURL = host + "/auth/oidc/{provider}/callback"
+	}
+	if cfg.OIDC.CompleteURL == "" {
+		cfg.OIDC.CompleteURL = host + "/auth/complete"
+	}
+	for i, p := range cfg.OIDC.Providers {
+		if p.RedirectURL == "" {
+			cfg.OIDC.Providers[i].RedirectURL = strings.ReplaceAll(cfg.OIDC.RedirectURL, "{provider}", p.Name)
+		}
+		if p.DisplayName == "" {
+			cfg.OIDC.Providers[i].DisplayName = p.Name
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file type %q", filepath.Ext(file))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", file, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	var errs []error
+
+	setString(&cfg.Store, "STORE")
+	setString(&cfg.Server.Port, "PORT")
+	setString(&cfg.Server.HostURL, "HOST_URL")
+	setString(&cfg.Server.JoinURL, "JOIN_URL")
+	setList(&cfg.Server.TrustedProxies, "TRUSTED_PROXIES")
+	setString(&cfg.Mongo.URI, "MONGODB_URI")
+	setString(&cfg.Mongo.Database, "MONGODB_DATABASE")
+	setString(&cfg.Auth.JWTSecret, "SECRET_KEY")
+	errs = append(errs,
+		setDuration(&cfg.Auth.AccessTokenTTL, "ACCESS_TOKEN_TTL"),
+		setDuration(&cfg.Auth.RefreshTokenTTL, "REFRESH_TOKEN_TTL"),
+		setDuration(&cfg.Auth.JoinTokenTTL, "JOIN_TOKEN_TTL"),
+	)
+
+	setString(&cfg.Log.Level, "LOG_LEVEL")
+	setString(&cfg.Log.Format, "LOG_FORMAT")
+	setString(&cfg.Log.File, "LOG_FILE")
+	errs = append(errs,
+		setInt(&cfg.Log.MaxSizeMB, "LOG_MAX_SIZE_MB"),
+		setInt(&cfg.Log.MaxBackups, "LOG_MAX_BACKUPS"),
+		setInt(&cfg.Log.MaxAgeDays, "LOG_MAX_AGE_DAYS"),
+		setBool(&cfg.Log.Compress, "LOG_COMPRESS"),
+	)
+
+	setList(&cfg.WebRTC.ICEServers, "ICE_SERVERS")
+	setString(&cfg.WebRTC.MixCodec, "MIX_CODEC")
+	setList(&cfg.WebRTC.Codecs.Audio, "CODECS_AUDIO")
+	setList(&cfg.WebRTC.Codecs.Video, "CODECS_VIDEO")
+	setList(&cfg.WebRTC.Codecs.H264Profiles, "CODECS_H264_PROFILES")
+	errs = append(errs,
+		setDuration(&cfg.WebRTC.KeyframeInterval, "KEYFRAME_INTERVAL"),
+		setDuration(&cfg.WebRTC.SpeakerInterval, "SPEAKER_INTERVAL"),
+		setBool(&cfg.WebRTC.Codecs.OpusFEC, "OPUS_FEC"),
+		setBool(&cfg.WebRTC.Codecs.OpusDTX, "OPUS_DTX"),
+	)
+
+	setString(&cfg.RoomCode.Alphabet, "ROOM_CODE_ALPHABET")
+	setString(&cfg.RoomCode.Pattern, "ROOM_CODE_PATTERN")
+	errs = append(errs, setInt(&cfg.RoomCode.MaxAttempts, "ROOM_CODE_ATTEMPTS"))
+
+	errs = append(errs,
+		setDuration(&cfg.Schedule.EarlyJoin, "SESSION_EARLY_JOIN"),
+		setDuration(&cfg.Schedule.GracePeriod, "SESSION_GRACE_PERIOD"),
+	)
+
+	setString(&cfg.Mail.Driver, "MAIL_DRIVER")
+	setString(&cfg.Mail.From, "MAIL_FROM")
+	setString(&cfg.Mail.Dir, "MAIL_DIR")
+	setString(&cfg.Mail.SMTP.Host, "SMTP_HOST")
+	setString(&cfg.Mail.SMTP.Username, "SMTP_USERNAME")
+	setString(&cfg.Mail.SMTP.Password, "SMTP_PASSWORD")
+	setString(&cfg.Mail.VerifyURL, "MAIL_VERIFY_URL")
+	setString(&cfg.Mail.ResetURL, "MAIL_RESET_URL")
+	errs = append(errs,
+		setInt(&cfg.Mail.SMTP.Port, "SMTP_PORT"),
+		setDuration(&cfg.Mail.VerificationTTL, "EMAIL_VERIFICATION_TTL"),
+		setDuration(&cfg.Mail.ResetTTL, "PASSWORD_RESET_TTL"),
+	)
+
+	setString(&cfg.RateLimit.Store, "RATE_LIMIT_STORE")
+	errs = append(errs,
+		setInt(&cfg.RateLimit.IP.FreeAttempts, "RATE_LIMIT_IP_ATTEMPTS"),
+		setInt(&cfg.RateLimit.Account.FreeAttempts, "RATE_LIMIT_ACCOUNT_ATTEMPTS"),
+		setDuration(&cfg.RateLimit.IP.MaxDelay, "RATE_LIMIT_MAX_LOCKOUT"),
+		setDuration(&cfg.RateLimit.Account.MaxDelay, "RATE_LIMIT_MAX_LOCKOUT"),
+		setInt(&cfg.RateLimit.PasswordReset.FreeAttempts, "RATE_LIMIT_PASSWORD_RESET_EMAILS"),
+		setInt(&cfg.RateLimit.Invitations.FreeAttempts, "RATE_LIMIT_INVITATIONS"),
+	)
+
+	setString(&cfg.Password.Algorithm, "PASSWORD_HASH")
+	errs = append(errs,
+		setInt(&cfg.Password.BcryptCost, "BCRYPT_COST"),
+		setInt(&cfg.Password.Argon2.Time, "ARGON2_TIME"),
+		setInt(&cfg.Password.Argon2.MemoryKB, "ARGON2_MEMORY_KB"),
+		setInt(&cfg.Password.Argon2.Threads, "ARGON2_THREADS"),
+	)
+
+	return errors.Join(errs...)
+}
+
+// Validate - Checks that required values are present and sane.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	switch cfg.Store {
+	case "mongo":
+		if cfg.Mongo.URI == "" {
+			errs = append(errs, errors.New("MONGODB_URI is required, see www.mongodb.com/docs/drivers/go/current/usage-examples/#environment-variable"))
+		}
+		if cfg.Mongo.Database == "" {
+			errs = append(errs, errors.New("MONGODB_DATABASE must not be empty"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("unknown store %q, expected mongo or memory", cfg.Store))
+	}
+	if cfg.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("SECRET_KEY is required to sign tokens"))
+	}
+	if cfg.Auth.AccessTokenTTL.Duration <= 0 || cfg.Auth.RefreshTokenTTL.Duration <= 0 || cfg.Auth.JoinTokenTTL.Duration <= 0 {
+		errs = append(errs, errors.New("token lifetimes must be positive"))
+	}
+	if port, err := strconv.Atoi(cfg.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %q", cfg.Server.Port))
+	}
+	if cfg.WebRTC.KeyframeInterval.Duration <= 0 {
+		errs = append(errs, errors.New("keyframe interval must be positive"))
+	}
+	if cfg.WebRTC.SpeakerInterval.Duration <= 0 {
+		errs = append(errs, errors.New("speaker interval must be positive"))
+	}
+	errs = append(errs, cfg.WebRTC.Codecs.validate(cfg.WebRTC.MixCodec)...)
+	if !strings.Contains(cfg.Server.JoinURL, "{code}") {
+		errs = append(errs, errors.New("join URL must contain {code}"))
+	}
+	if cfg.RoomCode.MaxAttempts < 1 {
+		errs = append(errs, errors.New("room code attempts must be at least 1"))
+	}
+	if cfg.Schedule.EarlyJoin.Duration < 0 || cfg.Schedule.GracePeriod.Duration < 0 {
+		errs = append(errs, errors.New("session join windows must not be negative"))
+	}
+	switch cfg.Mail.Driver {
+	case "smtp":
+		if cfg.Mail.SMTP.Host == "" {
+			errs = append(errs, errors.New("SMTP_HOST is required by the smtp mail driver"))
+		}
+	case "file":
+		if cfg.Mail.Dir == "" {
+			errs = append(errs, errors.New("MAIL_DIR is required by the file mail driver"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("unknown mail driver %q, expected smtp, file or memory", cfg.Mail.Driver))
+	}
+	if _, err := mail.ParseAddress(cfg.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("invalid MAIL_FROM: %w", err))
+	}
+	if !strings.Contains(cfg.Mail.VerifyURL, "{token}") || !strings.Contains(cfg.Mail.ResetURL, "{token}") {
+		errs = append(errs, errors.New("verification and reset URLs must contain {token}"))
+	}
+	if cfg.Mail.VerificationTTL.Duration <= 0 || cfg.Mail.ResetTTL.Duration <= 0 {
+		errs = append(errs, errors.New("email token lifetimes must be positive"))
+	}
+	switch cfg.RateLimit.Store {
+	case "memory":
+	case "mongo":
+		if cfg.Store != "mongo" {
+			errs = append(errs, errors.New("the mongo rate limit store needs the mongo store"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown rate limit store %q, expected memory or mongo", cfg.RateLimit.Store))
+	}
+	for _, p := range []LimitPolicy{cfg.RateLimit.IP, cfg.RateLimit.Account, cfg.RateLimit.PasswordReset, cfg.RateLimit.Invitations} {
+		if p.FreeAttempts < 1 || p.BaseDelay.Duration <= 0 || p.MaxDelay.Duration < p.BaseDelay.Duration || p.Window.Duration <= 0 {
+			errs = append(errs, errors.New("rate limits need at least 1 free attempt, positive delays with max >= base, and a positive window"))
+			break
+		}
+	}
+	switch cfg.Password.Algorithm {
+	case "bcrypt":
+		if cfg.Password.BcryptCost < 10 || cfg.Password.BcryptCost > 31 {
+			errs = append(errs, fmt.Errorf("bcrypt cost %d out of range 10-31", cfg.Password.BcryptCost))
+		}
+	case "argon2id":
+		a := cfg.Password.Argon2
+		if a.Time < 1 || a.Threads < 1 || a.Threads > 255 || a.MemoryKB < 8*a.Threads {
+			errs = append(errs, errors.New("argon2 needs time >= 1, 1-255 threads and at least 8 KiB of memory per thread"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown password hash %q, expected bcrypt or argon2id", cfg.Password.Algorithm))
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range cfg.OIDC.Providers {
+		switch {
+		case !providerName.MatchString(p.Name):
+			errs = append(errs, fmt.Errorf("OIDC provider name %q must be lowercase letters, digits, - or _", p.Name))
+		case seen[p.Name]:
+			errs = append(errs, fmt.Errorf("OIDC provider %q is configured twice", p.Name))
+		case p.Issuer == "" || p.ClientID == "":
+			errs = append(errs, fmt.Errorf("OIDC provider %q needs an issuer and a client ID", p.Name))
+		}
+		seen[p.Name] = true
+	}
+	if _, err := url.Parse(cfg.OIDC.CompleteURL); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OIDC complete URL: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Codec names known to the SFU.
+var (
+	audioCodecs = []string{"opus", "pcmu", "pcma", "g722"}
+	videoCodecs = []string{"vp8", "vp9", "h264", "av1"}
+)
+
+// h264Profile - A profile-level-id: profile_idc, constraint flags and
+// level_idc in hex.
+var h264Profile = regexp.MustCompile(`^[0-9a-f]{6}$`)
+
+func (c Codecs) validate(mixCodec string) []error {
+	var errs []error
+
+	if len(c.Audio) == 0 || len(c.Video) == 0 {
+		errs = append(errs, errors.New("at least one audio and one video codec must be enabled"))
+	}
+	for _, list := range []struct {
+		names, known []string
+	}{{c.Audio, audioCodecs}, {c.Video, videoCodecs}} {
+		for i, name := range list.names {
+			switch {
+			case !slices.Contains(list.known, name):
+				errs = append(errs, fmt.Errorf("unknown codec %q, expected one of %s", name, strings.Join(list.known, ", ")))
+			case slices.Contains(list.names[:i], name):
+				errs = append(errs, fmt.Errorf("codec %q is listed twice", name))
+			}
+		}
+	}
+
+	if slices.Contains(c.Video, "h264") {
+		if len(c.H264Profiles) == 0 || len(c.H264Profiles) > 8 {
+			errs = append(errs, errors.New("h264 needs 1 to 8 profiles"))
+		}
+		for _, profile := range c.H264Profiles {
+			if !h264Profile.MatchString(profile) {
+				errs = append(errs, fmt.Errorf("invalid H.264 profile-level-id %q, expected 6 lowercase hex digits", profile))
+			}
+		}
+	}
+
+	mixEnabled := false
+	for _, name := range c.Audio {
+		mixEnabled = mixEnabled || strings.EqualFold("audio/"+name, mixCodec)
+	}
+	if !mixEnabled {
+		errs = append(errs, fmt.Errorf("mix codec %q must be one of the enabled audio codecs", mixCodec))
+	}
+
+	return errs
+}
+
+// providerName - OIDC provider names are used in paths and cookie names.
+var providerName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func setString(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+func setList(dst *[]string, key string) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+
+	*dst = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*dst = append(*dst, item)
+		}
+	}
+}
+
+func setInt(dst *int, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *Duration, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	if err := dst.UnmarshalText([]byte(v)); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"webrtc/apierror"
+	"webrtc/handlers"
+	"webrtc/interfaces"
+	"webrtc/logging"
+	"webrtc/mailer"
+	"webrtc/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mailTimeout - How long a single delivery may take.
+const mailTimeout = 30 * time.Second
+
+type tokenInput struct {
+	Token string `json:"token" binding:"required,max=128"`
+}
+
+type forgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordInput struct {
+	Token    string `json:"token" binding:"required,max=128"`
+	Password string `json:"password" binding:"required,password"`
+}
+
+// VerifyEmail - Marks the user's email verified using the mailed token.
+func (ctl *Controller) VerifyEmail(ctx *gin.Context) {
+	var input tokenInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	user, ok := ctl.consumeActionToken(ctx, input.Token, interfaces.PurposeVerifyEmail)
+	if !ok {
+		return
+	}
+
+	user.EmailVerified = true
+	if err := ctl.users.Update(ctx, user); err != nil {
+		logging.FromContext(ctx).Error("update user", "user_id", user.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ResendVerification - Mails the authenticated user a new verification link.
+func (ctl *Controller) ResendVerification(ctx *gin.Context) {
+	user, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	if user.EmailVerified {
+		apierror.Write(ctx, http.StatusConflict, apierror.CodeEmailVerified, "Email is already verified.")
+		return
+	}
+
+	keys := ctl.mailKeys(ctx, user.Email)
+	if ctl.mailThrottled(ctx, keys, 1) {
+		return
+	}
+	ctl.mailSent(ctx, keys, 1)
+
+	ctl.mailInBackground(ctx, func(bg context.Context) error {
+		return ctl.sendVerification(bg, user)
+	})
+	ctx.JSON(http.StatusAccepted, gin.H{"status": "sent"})
+}
+
+// ForgotPassword - Mails a password reset link if the email belongs to an
+// account. The response is the same either way so it can't be used to
+// probe for registered addresses; requests for unknown addresses count
+// against the rate limit too.
+func (ctl *Controller) ForgotPassword(ctx *gin.Context) {
+	var input forgotPasswordInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	keys := ctl.mailKeys(ctx, input.Email)
+	if ctl.mailThrottled(ctx, keys, 1) {
+		return
+	}
+	ctl.mailSent(ctx, keys, 1)
+
+	// The lookup happens in the background too, so known and unknown
+	// addresses take the same time to answer.
+	ctl.mailInBackground(ctx, func(bg context.Context) error {
+		user, err := ctl.users.FindByEmail(bg, input.Email)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return ctl.sendPasswordReset(bg, user)
+	})
+	ctx.JSON(http.StatusAccepted, gin.H{"status": "sent"})
+}
+
+// ResetPassword - Sets a new password using the mailed token. Every
+// refresh token of the user is revoked.
+func (ctl *Controller) ResetPassword(ctx *gin.Context) {
+	var input resetPasswordInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	user, ok := ctl.consumeActionToken(ctx, input.Token, interfaces.PurposeResetPassword)
+	if !ok {
+		return
+	}
+
+	hash, ok := ctl.hashPassword(ctx, input.Password)
+	if !ok {
+		return
+	}
+
+	log := logging.FromContext(ctx)
+	user.Password = hash
+	// Receiving the reset link proves control of the address.
+	user.EmailVerified = true
+	if err := ctl.users.Update(ctx, user); err != nil {
+		log.Error("update user", "user_id", user.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	if err := ctl.actionTokens.DeleteUser(ctx, user.ID, interfaces.PurposeResetPassword); err != nil {
+		log.Error("delete reset tokens", "user_id", user.ID, "err", err)
+	}
+	if err := ctl.revokeUser(ctx, user.ID); err != nil {
+		log.Error("revoke tokens", "user_id", user.ID, "err", err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// consumeActionToken - Redeems a mailed token and returns its user. The
+// token only counts if the user's email is still the address it was sent
+// to. Writes the error response and returns false on failure.
+func (ctl *Controller) consumeActionToken(ctx *gin.Context, token, purpose string) (interfaces.User, bool) {
+	log := logging.FromContext(ctx)
+
+	stored, err := ctl.actionTokens.Consume(ctx, handlers.HashToken(token), purpose)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Error("consume token", "purpose", purpose, "err", err)
+		}
+		apierror.Write(ctx, http.StatusBadRequest, apierror.CodeInvalidToken, "Invalid or expired token.")
+		return interfaces.User{}, false
+	}
+
+	user, err := ctl.users.FindByID(ctx, stored.UserID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Error("find user", "user_id", stored.UserID, "err", err)
+		}
+		apierror.Write(ctx, http.StatusBadRequest, apierror.CodeInvalidToken, "Invalid or expired token.")
+		return interfaces.User{}, false
+	}
+	if !strings.EqualFold(user.Email, stored.Email) {
+		apierror.Write(ctx, http.StatusBadRequest, apierror.CodeInvalidToken, "Invalid or expired token.")
+		return interfaces.User{}, false
+	}
+
+	return user, true
+}
+
+// sendVerification - Mails user a link confirming their current email.
+func (ctl *Controller) sendVerification(ctx context.Context, user interfaces.User) error {
+	ttl := ctl.cfg.Mail.VerificationTTL.Duration
+	token, err := ctl.createActionToken(ctx, user, interfaces.PurposeVerifyEmail, ttl)
+	if err != nil {
+		return err
+	}
+	link := actionURL(ctl.cfg.Mail.VerifyURL, token)
+	return ctl.mailer.Send(ctx, mailer.VerificationMessage(user.Email, user.UserName, link, ttl))
+}
+
+// sendPasswordReset - Mails user a password reset link.
+func (ctl *Controller) sendPasswordReset(ctx context.Context, user interfaces.User) error {
+	ttl := ctl.cfg.Mail.ResetTTL.Duration
+	token, err := ctl.createActionToken(ctx, user, interfaces.PurposeResetPassword, ttl)
+	if err != nil {
+		return err
+	}
+	link := actionURL(ctl.cfg.Mail.ResetURL, token)
+	return ctl.mailer.Send(ctx, mailer.PasswordResetMessage(user.Email, user.UserName, link, ttl))
+}
+
+// createActionToken - Stores a new single-use token for the user's current
+// email and returns it.
+func (ctl *Controller) createActionToken(ctx context.Context, user interfaces.User, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := handlers.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = ctl.actionTokens.Create(ctx, &interfaces.ActionToken{
+		TokenHash: hash,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	return token, err
+}
+
+// mailInBackground - Runs send after the response is written, logging any
+// failure. The request context is detached so the send isn't cancelled
+// when the request finishes.
+func (ctl *Controller) mailInBackground(ctx *gin.Context, send func(context.Context) error) {
+	log := logging.FromContext(ctx)
+	base := context.WithoutCancel(ctx.Request.Context())
+
+	go func() {
+		bg, cancel := context.WithTimeout(base, mailTimeout)
+		defer cancel()
+		if err := send(bg); err != nil {
+			log.Error("send mail", "err", err)
+		}
+	}()
+}
+
+// actionURL - Substitutes the token into a configured link.
+func actionURL(template, token string) string {
+	return strings.ReplaceAll(template, "{token}", url.QueryEscape(token))
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"webrtc/apierror"
+	"webrtc/handlers"
+	"webrtc/interfaces"
+	"webrtc/logging"
+	"webrtc/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type refreshInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required,max=128"`
+}
+
+// Refresh - Exchanges a refresh token for a new access and refresh token.
+// The presented refresh token is revoked; presenting it again revokes every
+// token issued from the same login.
+func (ctl *Controller) Refresh(ctx *gin.Context) {
+	var input refreshInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	log := logging.FromContext(ctx)
+	hash := handlers.HashToken(input.RefreshToken)
+
+	stored, err := ctl.refreshTokens.FindByHash(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Error("find refresh token", "err", err)
+		}
+		apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid refresh token.")
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		ctl.revokeReusedFamily(ctx, stored)
+		apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid refresh token.")
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidToken, "Refresh token expired.")
+		return
+	}
+
+	if err := ctl.refreshTokens.Revoke(ctx, hash); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			// Lost a race with another rotation of the same token.
+			ctl.revokeReusedFamily(ctx, stored)
+			apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid refresh token.")
+			return
+		}
+		log.Error("revoke refresh token", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	tokens, err := ctl.issueTokens(ctx, stored.UserID, stored.Family)
+	if err != nil {
+		log.Error("issue tokens", "user_id", stored.UserID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// Logout - Revokes the given refresh token, every token rotated from it and
+// the access tokens issued with them.
+func (ctl *Controller) Logout(ctx *gin.Context) {
+	var input refreshInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	stored, err := ctl.refreshTokens.FindByHash(ctx, handlers.HashToken(input.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			// Nothing to revoke; logging out twice is not an error.
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+		logging.FromContext(ctx).Error("find refresh token", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	if err := ctl.revokeFamily(ctx, stored.Family); err != nil {
+		logging.FromContext(ctx).Error("revoke tokens", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// issueTokens - Creates an access token and a stored refresh token. An
+// empty family starts a new login.
+func (ctl *Controller) issueTokens(ctx *gin.Context, userID, family string) (gin.H, error) {
+	accessToken, accessID, err := ctl.auth.GenerateToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := handlers.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if family == "" {
+		family = uuid.NewString()
+	}
+
+	now := time.Now()
+	if err := ctl.refreshTokens.Create(ctx, &interfaces.RefreshToken{
+		TokenHash: hash,
+		UserID:    userID,
+		Family:    family,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ctl.auth.RefreshTTL()),
+		AccessID:  accessID,
+	}); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(ctl.auth.AccessTTL().Seconds()),
+	}, nil
+}
+
+func (ctl *Controller) revokeReusedFamily(ctx *gin.Context, stored interfaces.RefreshToken) {
+	log := logging.FromContext(ctx)
+	log.Warn("refresh token reuse detected, revoking family", "user_id", stored.UserID)
+	if err := ctl.revokeFamily(ctx, stored.Family); err != nil {
+		log.Error("revoke token family", "err", err)
+	}
+}
+
+// revokeFamily - Revokes the refresh tokens of one login and the access
+// tokens issued with them.
+func (ctl *Controller) revokeFamily(ctx *gin.Context, family string) error {
+	tokens, err := ctl.refreshTokens.FindByFamily(ctx, family)
+	if err != nil {
+		return err
+	}
+	if err := ctl.revokeAccessTokens(ctx, tokens); err != nil {
+		return err
+	}
+	return ctl.refreshTokens.RevokeFamily(ctx, family)
+}
+
+// revokeUser - Like revokeFamily, for every login of the user.
+func (ctl *Controller) revokeUser(ctx *gin.Context, userID string) error {
+	tokens, err := ctl.refreshTokens.FindByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := ctl.revokeAccessTokens(ctx, tokens); err != nil {
+		return err
+	}
+	return ctl.refreshTokens.RevokeUser(ctx, userID)
+}
+
+// revokeAccessTokens - Denies the access tokens issued with the refresh
+// tokens until they expire. An access token is issued just before its
+// refresh token is created, and its expiry is rounded down to the second,
+// so a second past the refresh token's creation plus the access token
+// lifetime covers it.
+func (ctl *Controller) revokeAccessTokens(ctx *gin.Context, tokens []interfaces.RefreshToken) error {
+	now := time.Now()
+	for _, token := range tokens {
+		expiresAt := token.CreatedAt.Add(ctl.auth.AccessTTL() + time.Second)
+		if token.AccessID == "" || !now.Before(expiresAt) {
+			continue
+		}
+		if err := ctl.revokedTokens.Revoke(ctx, token.AccessID, expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
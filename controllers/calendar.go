@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"webrtc/apierror"
+	"webrtc/ical"
+	"webrtc/interfaces"
+	"webrtc/logging"
+	"webrtc/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// errNotScheduled - The session has no schedule to put in a calendar.
+var errNotScheduled = errors.New("session is not scheduled")
+
+// ExportSessionCalendar - Downloads a scheduled session as an .ics file.
+// Passing one or more attendee query parameters produces an invitation
+// (METHOD:REQUEST) addressed to those emails instead of a plain export.
+func (ctl *Controller) ExportSessionCalendar(ctx *gin.Context) {
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+
+	method := ical.MethodPublish
+	var attendees []ical.Person
+	for _, email := range ctx.QueryArray("attendee") {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			invalidField(ctx, "attendee", "email", "Invalid attendee email: "+email)
+			return
+		}
+		attendees = append(attendees, ical.Person{Name: addr.Name, Email: addr.Address})
+		method = ical.MethodRequest
+	}
+
+	data, err := ctl.sessionCalendar(ctx, session, method, attendees)
+	if errors.Is(err, errNotScheduled) {
+		apierror.Write(ctx, http.StatusConflict, apierror.CodeNotScheduled, "Session is not scheduled.")
+		return
+	} else if err != nil {
+		logging.FromContext(ctx).Error("render calendar", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	filename := strings.Trim(unsafeFilenameChars.ReplaceAllString(session.Title, "-"), "-")
+	if filename == "" {
+		filename = "session"
+	}
+	ctx.Header("Content-Disposition", `attachment; filename="`+filename+`.ics"`)
+	ctx.Data(http.StatusOK, "text/calendar; charset=utf-8; method="+method, data)
+}
+
+// sessionCalendar - Renders a scheduled session as an iCalendar file, with
+// the join link, the host as organizer and the given attendees.
+func (ctl *Controller) sessionCalendar(ctx *gin.Context, session interfaces.Session, method string, attendees []ical.Person) ([]byte, error) {
+	if session.Schedule == nil {
+		return nil, errNotScheduled
+	}
+	sched, err := session.Schedule.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := ctl.sockets.FindBySessionID(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var organizer ical.Person
+	if host, err := ctl.users.FindByID(ctx, session.Host); err == nil {
+		organizer = ical.Person{Name: host.UserName, Email: host.Email}
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	joinURL := ctl.joinURL(socket.HashedURL)
+	event := ical.Event{
+		UID:         session.ID + "@" + ctl.calendarDomain(),
+		Summary:     session.Title,
+		Description: "Join the meeting: " + joinURL,
+		URL:         joinURL,
+		Start:       sched.Start,
+		End:         sched.End,
+		Location:    sched.Location,
+		Organizer:   organizer,
+		Attendees:   attendees,
+		Sequence:    session.Sequence,
+		Stamp:       time.Now(),
+	}
+	if sched.Rule != nil {
+		event.RRule = sched.Rule.String()
+	}
+
+	return ical.Render(method, event), nil
+}
+
+// joinURL - The link participants follow to join the room with code.
+func (ctl *Controller) joinURL(code string) string {
+	return strings.ReplaceAll(ctl.cfg.Server.JoinURL, "{code}", url.PathEscape(code))
+}
+
+// calendarDomain - Domain used to make event UIDs globally unique.
+func (ctl *Controller) calendarDomain() string {
+	if u, err := url.Parse(ctl.cfg.Server.HostURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
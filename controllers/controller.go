@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"webrtc/config"
+	"webrtc/handlers"
+	"webrtc/mailer"
+	"webrtc/ratelimit"
+	"webrtc/repository"
+	"webrtc/sso"
+	"webrtc/utils"
+)
+
+// Controller - Holds the dependencies shared by the HTTP handlers.
+type Controller struct {
+	cfg       *config.Config
+	auth      *handlers.Auth
+	sfu       *handlers.SFU
+	roomCodes *utils.RoomCodeGenerator
+	passwords *utils.PasswordHasher
+	limiter   *ratelimit.Limiter
+	mailer    mailer.Mailer
+	sso       *sso.Registry
+
+	users         repository.UserStore
+	sessions      repository.SessionStore
+	sockets       repository.SocketStore
+	refreshTokens repository.RefreshTokenStore
+	revokedTokens repository.RevokedTokenStore
+	actionTokens  repository.ActionTokenStore
+}
+
+// New - Creates a Controller.
+// The custom binding rules are registered on first use.
+func New(cfg *config.Config, auth *handlers.Auth, sfu *handlers.SFU, stores *repository.Stores, roomCodes *utils.RoomCodeGenerator, passwords *utils.PasswordHasher, mail mailer.Mailer, providers *sso.Registry) *Controller {
+	registerValidationsOnce.Do(registerValidations)
+
+	return &Controller{
+		cfg:       cfg,
+		auth:      auth,
+		sfu:       sfu,
+		roomCodes: roomCodes,
+		passwords: passwords,
+		limiter:   ratelimit.New(stores.Attempts),
+		mailer:    mail,
+		sso:       providers,
+
+		users:         stores.Users,
+		sessions:      stores.Sessions,
+		sockets:       stores.Sockets,
+		refreshTokens: stores.RefreshTokens,
+		revokedTokens: stores.RevokedTokens,
+		actionTokens:  stores.ActionTokens,
+	}
+}
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"webrtc/apierror"
+	"webrtc/config"
+	"webrtc/handlers"
+	"webrtc/interfaces"
+	"webrtc/mailer"
+	"webrtc/middleware"
+	"webrtc/repository"
+	"webrtc/sso"
+	"webrtc/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer - A Controller over the memory store behind the routes main
+// registers.
+type testServer struct {
+	t      *testing.T
+	cfg    *config.Config
+	ctl    *Controller
+	stores *repository.Stores
+	mail   *mailer.Memory
+	router *gin.Engine
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testConfig - The defaults, made cheap and self-contained.
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Store = "memory"
+	cfg.Auth.JWTSecret = "test-secret"
+	cfg.Password.BcryptCost = 4
+	cfg.Server.JoinURL = "http://localhost/join/{code}"
+	cfg.Mail.VerifyURL = "http://localhost/verify-email?token={token}"
+	cfg.Mail.ResetURL = "http://localhost/reset-password?token={token}"
+	cfg.OIDC.CompleteURL = "http://localhost/auth/complete"
+	return cfg
+}
+
+// newTestServer - Builds a testServer from the config, testConfig() if nil.
+func newTestServer(t *testing.T, cfg *config.Config) *testServer {
+	t.Helper()
+	if cfg == nil {
+		cfg = testConfig()
+	}
+
+	roomCodes, err := utils.NewRoomCodeGenerator(cfg.RoomCode.Alphabet, cfg.RoomCode.Pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := utils.NewPasswordHasher(cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	auth := handlers.NewAuth(cfg.Auth)
+	sfu, err := handlers.NewSFU(cfg.WebRTC, auth, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		t:      t,
+		cfg:    cfg,
+		stores: repository.NewMemory(),
+		mail:   mailer.NewMemory(cfg.Mail.From),
+	}
+	s.ctl = New(cfg, auth, sfu, s.stores, roomCodes, passwords, s.mail, sso.New(cfg.OIDC))
+	sfu.SetAdmission(s.ctl.AdmitRoom)
+
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(middleware.RequestLogger(logger), gin.CustomRecovery(apierror.Recovery))
+	router.NoRoute(apierror.NotFound)
+	router.POST("/createuser", s.ctl.CreateUser)
+	router.POST("/login", s.ctl.Login)
+	router.POST("/refresh", s.ctl.Refresh)
+	router.POST("/logout", s.ctl.Logout)
+	router.GET("/auth/oidc/:provider/login", s.ctl.StartSSO)
+	router.GET("/auth/oidc/:provider/callback", s.ctl.FinishSSO)
+	router.POST("/auth/oidc/exchange", s.ctl.ExchangeSSOCode)
+	router.GET("/connect", s.ctl.GetSession)
+	router.POST("/connect/:url", s.ctl.ConnectSession)
+	router.GET("/websocket/:roomId", func(c *gin.Context) {
+		sfu.WebsocketHandler(c.Writer, c.Request, c.Param("roomId"))
+	})
+
+	authed := router.Group("/", middleware.RequireAuth(auth, s.stores.RevokedTokens))
+	authed.GET("/me", s.ctl.GetMe)
+	authed.PATCH("/me", s.ctl.UpdateMe)
+	authed.DELETE("/me", s.ctl.DeleteMe)
+	authed.POST("/me/password", s.ctl.ChangePassword)
+	authed.POST("/session", s.ctl.CreateSession)
+	authed.GET("/sessions", s.ctl.ListSessions)
+	authed.GET("/sessions/:id", s.ctl.GetSessionByID)
+	authed.PATCH("/sessions/:id", s.ctl.UpdateSession)
+	authed.DELETE("/sessions/:id", s.ctl.DeleteSession)
+	authed.POST("/sessions/:id/invite", s.ctl.InviteToSession)
+	s.router = router
+
+	return s
+}
+
+// do - Sends a request with an optional JSON body and bearer token.
+func (s *testServer) do(method, path string, body interface{}, token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.doFrom("192.0.2.1:1234", method, path, body, token, cookies...)
+}
+
+// doFrom - Like do, from another client address.
+func (s *testServer) doFrom(remoteAddr, method, path string, body interface{}, token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.RemoteAddr = remoteAddr
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode - Decodes a JSON response, failing unless it has the wanted status.
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int) map[string]interface{} {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", w.Body, err)
+	}
+	return body
+}
+
+// errorCode - The code of an error envelope.
+func errorCode(body map[string]interface{}) string {
+	e, _ := body["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	return code
+}
+
+// signUp - Creates a user and returns their access token.
+func (s *testServer) signUp(username, email string) string {
+	s.t.Helper()
+
+	body := decode(s.t, s.do(http.MethodPost, "/createuser", gin.H{
+		"username": username,
+		"email":    email,
+		"password": "secret123",
+	}, ""), http.StatusOK)
+	token, _ := body["token"].(string)
+	if token == "" {
+		s.t.Fatalf("signup returned no token: %v", body)
+	}
+	return token
+}
+
+// createSession - Creates a session with password "letmein" and returns
+// the stored session and its room code.
+func (s *testServer) createSession(token string, body gin.H) (interfaces.Session, string) {
+	s.t.Helper()
+
+	if body == nil {
+		body = gin.H{}
+	}
+	if _, ok := body["title"]; !ok {
+		body["title"] = "Standup"
+	}
+	body["password"] = "letmein"
+
+	code, _ := decode(s.t, s.do(http.MethodPost, "/session", body, token), http.StatusOK)["socket"].(string)
+	socket, err := s.stores.Sockets.FindByHashedURL(context.Background(), code)
+	if err != nil {
+		s.t.Fatalf("room code %q not stored: %v", code, err)
+	}
+	session, err := s.stores.Sessions.FindByID(context.Background(), socket.SessionID)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return session, code
+}
+
+// waitForMail - Waits for the background sends to deliver n messages.
+func (s *testServer) waitForMail(n int) []mailer.Message {
+	s.t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		messages := s.mail.Messages()
+		if len(messages) >= n {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("%d messages sent, want %d", len(messages), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+	"webrtc/apierror"
+	"webrtc/ical"
+	"webrtc/interfaces"
+	"webrtc/logging"
+	"webrtc/mailer"
+	"webrtc/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type inviteInput struct {
+	// Emails may include display names, e.g. "Ann <ann@example.com>".
+	Emails  []string `json:"emails" binding:"required,min=1,max=50,dive,required,max=320"`
+	Message string   `json:"message" binding:"max=2000"`
+}
+
+// InviteToSession - Emails the room link to each address, with an .ics
+// invitation attached when the session is scheduled. Every recipient gets
+// their own message so addresses aren't disclosed to each other. Only hosts
+// with a verified email may invite, and each recipient counts against
+// their rate limit; a request that would go over it sends nothing.
+func (ctl *Controller) InviteToSession(ctx *gin.Context) {
+	var input inviteInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+	recipients := make([]*mail.Address, 0, len(input.Emails))
+	for i, email := range input.Emails {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			invalidField(ctx, fmt.Sprintf("emails[%d]", i), "email", "Invalid email: "+email)
+			return
+		}
+		recipients = append(recipients, addr)
+	}
+
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	host, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
+	}
+	if !host.EmailVerified {
+		apierror.Write(ctx, http.StatusForbidden, apierror.CodeEmailNotVerified, "Verify your email address before sending invitations.")
+		return
+	}
+	keys := ctl.invitationKeys(host.ID)
+	if ctl.mailThrottled(ctx, keys, len(recipients)) {
+		return
+	}
+
+	log := logging.FromContext(ctx).With("session_id", session.ID)
+
+	socket, err := ctl.sockets.FindBySessionID(ctx, session.ID)
+	if err != nil {
+		log.Error("find socket", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	invitation := mailer.Invitation{
+		HostName: host.UserName,
+		Title:    session.Title,
+		JoinURL:  ctl.joinURL(socket.HashedURL),
+		Note:     input.Message,
+	}
+	if session.Schedule != nil {
+		if sched, err := session.Schedule.Build(); err == nil {
+			if next, ok := sched.NextOccurrence(time.Now()); ok {
+				invitation.StartsAt = next.Start
+			}
+		}
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+	ctl.mailSent(ctx, keys, len(recipients))
+
+	sent := make([]string, 0, len(recipients))
+	failed := make([]string, 0)
+	for _, addr := range recipients {
+		invitation.To = addr.String()
+		invitation.Calendar = nil
+		if session.Schedule != nil {
+			attendee := []ical.Person{{Name: addr.Name, Email: addr.Address}}
+			invitation.Calendar, err = ctl.sessionCalendar(ctx, session, ical.MethodRequest, attendee)
+			if err != nil {
+				log.Error("render calendar", "err", err)
+				apierror.Internal(ctx)
+				return
+			}
+		}
+
+		if err := ctl.mailer.Send(sendCtx, mailer.InvitationMessage(invitation)); err != nil {
+			log.Error("send invitation", "err", err)
+			failed = append(failed, addr.Address)
+			continue
+		}
+		sent = append(sent, addr.Address)
+	}
+
+	if len(sent) == 0 {
+		apierror.WriteDetails(ctx, http.StatusBadGateway, apierror.CodeMailFailed, "No invitation could be delivered.", gin.H{"failed": failed})
+		return
+	}
+
+	session.Invitees = addInvitees(session.Invitees, recipients, sent)
+	if err := ctl.sessions.Update(ctx, session); err != nil {
+		// The invitations are out; only a later cancellation misses them
+		log.Error("record invitees", "err", err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{"sent": sent, "failed": failed})
+}
+
+// addInvitees - Adds the recipients whose invitation was sent to the
+// session's invitees, once per address.
+func addInvitees(invitees []string, recipients []*mail.Address, sent []string) []string {
+	known := map[string]bool{}
+	for _, invitee := range invitees {
+		if addr, err := mail.ParseAddress(invitee); err == nil {
+			known[strings.ToLower(addr.Address)] = true
+		}
+	}
+	for _, addr := range recipients {
+		key := strings.ToLower(addr.Address)
+		if !known[key] && slices.Contains(sent, addr.Address) {
+			invitees = append(invitees, addr.String())
+			known[key] = true
+		}
+	}
+	return invitees
+}
+
+// cancellationMessages - The emails telling each invitee of a session that
+// it was cancelled, with a calendar that removes a scheduled session from
+// theirs. Errors are logged and leave the invitees untold rather than
+// keep the session from being deleted.
+func (ctl *Controller) cancellationMessages(ctx *gin.Context, session interfaces.Session) []mailer.Message {
+	if len(session.Invitees) == 0 {
+		return nil
+	}
+	log := logging.FromContext(ctx).With("session_id", session.ID)
+
+	hostName := "Your host"
+	if host, err := ctl.users.FindByID(ctx, session.Host); err == nil {
+		hostName = host.UserName
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		log.Error("find host", "err", err)
+	}
+
+	cancellation := mailer.Invitation{HostName: hostName, Title: session.Title}
+	if session.Schedule != nil {
+		if sched, err := session.Schedule.Build(); err == nil {
+			if next, ok := sched.NextOccurrence(time.Now()); ok {
+				cancellation.StartsAt = next.Start
+			}
+		}
+	}
+	// A cancellation must outrank the last invitation
+	session.Sequence++
+
+	messages := make([]mailer.Message, 0, len(session.Invitees))
+	for _, invitee := range session.Invitees {
+		addr, err := mail.ParseAddress(invitee)
+		if err != nil {
+			continue
+		}
+		cancellation.To = addr.String()
+		cancellation.Calendar = nil
+		if session.Schedule != nil {
+			attendee := []ical.Person{{Name: addr.Name, Email: addr.Address}}
+			cancellation.Calendar, err = ctl.sessionCalendar(ctx, session, ical.MethodCancel, attendee)
+			if err != nil {
+				log.Error("render cancellation", "err", err)
+				return nil
+			}
+		}
+		messages = append(messages, mailer.CancellationMessage(cancellation))
+	}
+	return messages
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"webrtc/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInviteStaysWithinAllowance(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit.Invitations.FreeAttempts = 3
+	s := newTestServer(t, cfg)
+	token := s.signUp("ann", "ann@example.com")
+	s.waitForMail(1)
+
+	host, err := s.stores.Users.FindByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.EmailVerified = true
+	if err := s.stores.Users.Update(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+	session, _ := s.createSession(token, nil)
+	path := "/sessions/" + session.ID + "/invite"
+
+	body := decode(t, s.do(http.MethodPost, path, gin.H{"emails": []string{"bob@example.com", "cy@example.com"}}, token), http.StatusOK)
+	if sent, _ := body["sent"].([]interface{}); len(sent) != 2 {
+		t.Fatalf("invite = %v", body)
+	}
+
+	// Two more would go one over, so neither is sent
+	body = decode(t, s.do(http.MethodPost, path, gin.H{"emails": []string{"dee@example.com", "eve@example.com"}}, token), http.StatusTooManyRequests)
+	if got := errorCode(body); got != apierror.CodeTooManyAttempts {
+		t.Errorf("code = %q, want %q", got, apierror.CodeTooManyAttempts)
+	}
+	if details, _ := body["error"].(map[string]interface{})["details"].(map[string]interface{}); details["remaining"] != float64(1) {
+		t.Errorf("details = %v, want 1 remaining", details)
+	}
+	if n := len(s.mail.Messages()); n != 3 {
+		t.Errorf("%d messages sent, want the verification and 2 invitations", n)
+	}
+
+	decode(t, s.do(http.MethodPost, path, gin.H{"emails": []string{"dee@example.com"}}, token), http.StatusOK)
+	attempts, err := s.stores.Attempts.Get(context.Background(), "invite:account:"+host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 3 {
+		t.Errorf("counted %d invitations, want 3", attempts.Failures)
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"webrtc/apierror"
+	"webrtc/interfaces"
+	"webrtc/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hashPassword - Hashes under the current policy. On failure the error is
+// logged, the response has been written and ok is false.
+func (ctl *Controller) hashPassword(ctx *gin.Context, password string) (hash string, ok bool) {
+	hash, err := ctl.passwords.Hash(password)
+	if err != nil {
+		logging.FromContext(ctx).Error("hash password", "err", err)
+		apierror.Internal(ctx)
+		return "", false
+	}
+	return hash, true
+}
+
+// passwordMatches - Verifies password against a stored hash. A hash that
+// can't be parsed never matches and is logged, since only a password
+// reset can recover from it. Accounts created through single sign-on have
+// no hash until a reset sets one.
+func (ctl *Controller) passwordMatches(ctx *gin.Context, hash, password string) bool {
+	if hash == "" {
+		ctl.passwords.VerifyDummy(password)
+		return false
+	}
+	match, err := ctl.passwords.Verify(hash, password)
+	if err != nil {
+		logging.FromContext(ctx).Error("verify password", "err", err)
+		return false
+	}
+	return match
+}
+
+// upgradeUserHash - Rehashes a verified password made under an older
+// policy. Failing to is logged but not fatal; it is retried next time.
+func (ctl *Controller) upgradeUserHash(ctx *gin.Context, user interfaces.User, password string) {
+	if !ctl.passwords.NeedsRehash(user.Password) {
+		return
+	}
+
+	log := logging.FromContext(ctx)
+	hash, err := ctl.passwords.Hash(password)
+	if err != nil {
+		log.Error("rehash password", "err", err)
+		return
+	}
+	user.Password = hash
+	if err := ctl.users.Update(ctx, user); err != nil {
+		log.Error("store rehashed password", "err", err)
+		return
+	}
+	log.Info("upgraded password hash", "user_id", user.ID)
+}
+
+// upgradeSessionHash - upgradeUserHash for session passwords.
+func (ctl *Controller) upgradeSessionHash(ctx *gin.Context, session interfaces.Session, password string) {
+	if !ctl.passwords.NeedsRehash(session.Password) {
+		return
+	}
+
+	log := logging.FromContext(ctx)
+	hash, err := ctl.passwords.Hash(password)
+	if err != nil {
+		log.Error("rehash session password", "err", err)
+		return
+	}
+	session.Password = hash
+	if err := ctl.sessions.Update(ctx, session); err != nil {
+		log.Error("store rehashed session password", "err", err)
+		return
+	}
+	log.Info("upgraded session password hash", "session_id", session.ID)
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"webrtc/apierror"
+	"webrtc/logging"
+	"webrtc/ratelimit"
+	"webrtc/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginKeys - Rate limit keys for a sign in attempt: the client IP and the
+// account.
+func (ctl *Controller) loginKeys(ctx *gin.Context, email string) []ratelimit.Key {
+	return []ratelimit.Key{
+		{ID: "login:ip:" + ctx.ClientIP(), Policy: ctl.cfg.RateLimit.IP},
+		{ID: "login:account:" + repository.NormalizeEmail(email), Policy: ctl.cfg.RateLimit.Account},
+	}
+}
+
+// connectKeys - Rate limit keys for a session password attempt: the client
+// IP, and the client IP in the room. A key for the room alone would let
+// anyone lock every guest out of it with a few wrong passwords.
+func (ctl *Controller) connectKeys(ctx *gin.Context, code string) []ratelimit.Key {
+	return []ratelimit.Key{
+		{ID: "connect:ip:" + ctx.ClientIP(), Policy: ctl.cfg.RateLimit.IP},
+		{ID: "connect:room:" + code + ":ip:" + ctx.ClientIP(), Policy: ctl.cfg.RateLimit.Account},
+	}
+}
+
+// mailKeys - Rate limit keys for a password reset or verification email:
+// the client IP and the address it goes to.
+func (ctl *Controller) mailKeys(ctx *gin.Context, email string) []ratelimit.Key {
+	return []ratelimit.Key{
+		{ID: "mail:ip:" + ctx.ClientIP(), Policy: ctl.cfg.RateLimit.IP},
+		{ID: "mail:address:" + repository.NormalizeEmail(email), Policy: ctl.cfg.RateLimit.PasswordReset},
+	}
+}
+
+// invitationKeys - Rate limit keys for invitations: the sending account.
+func (ctl *Controller) invitationKeys(userID string) []ratelimit.Key {
+	return []ratelimit.Key{
+		{ID: "invite:account:" + userID, Policy: ctl.cfg.RateLimit.Invitations},
+	}
+}
+
+// throttled - Answers 429 and returns true if any key is locked out.
+func (ctl *Controller) throttled(ctx *gin.Context, keys []ratelimit.Key) bool {
+	return ctl.lockedOut(ctx, keys, "Too many failed attempts, try again later.")
+}
+
+// mailThrottled - Like throttled, for keys that count emails sent, and
+// also answers 429 if sending n more would go past what the keys allow
+// before locking. Once that is used up one email may be sent after each
+// wait, so n of 1 is always let through an open lock.
+func (ctl *Controller) mailThrottled(ctx *gin.Context, keys []ratelimit.Key, n int) bool {
+	if ctl.lockedOut(ctx, keys, "Too many emails sent, try again later.") {
+		return true
+	}
+	if n <= 1 {
+		return false
+	}
+
+	remaining, err := ctl.limiter.Remaining(ctx, keys...)
+	if err != nil {
+		logging.FromContext(ctx).Error("check rate limit", "err", err)
+		apierror.Internal(ctx)
+		return true
+	}
+	remaining = max(remaining, 1)
+	if n <= remaining {
+		return false
+	}
+
+	apierror.WriteDetails(ctx, http.StatusTooManyRequests, apierror.CodeTooManyAttempts,
+		fmt.Sprintf("Sending %d emails would go over the limit; %d can be sent now.", n, remaining),
+		gin.H{"remaining": remaining})
+	return true
+}
+
+// lockedOut - Answers 429 with message and returns true if any key is
+// locked out.
+func (ctl *Controller) lockedOut(ctx *gin.Context, keys []ratelimit.Key, message string) bool {
+	wait, err := ctl.limiter.RetryAfter(ctx, keys...)
+	if err != nil {
+		logging.FromContext(ctx).Error("check rate limit", "err", err)
+		apierror.Internal(ctx)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	tooManyAttempts(ctx, wait, message)
+	return true
+}
+
+// attemptFailed - Counts a failed password check against keys.
+func (ctl *Controller) attemptFailed(ctx *gin.Context, keys []ratelimit.Key) {
+	wait, err := ctl.limiter.Fail(ctx, keys...)
+	if err != nil {
+		logging.FromContext(ctx).Error("record failed attempt", "err", err)
+		return
+	}
+	if wait > 0 {
+		logging.FromContext(ctx).Warn("locking out after failed attempts", "keys", keyIDs(keys), "for", wait)
+	}
+}
+
+// mailSent - Counts n emails sent against keys.
+func (ctl *Controller) mailSent(ctx *gin.Context, keys []ratelimit.Key, n int) {
+	if _, err := ctl.limiter.FailN(ctx, n, keys...); err != nil {
+		logging.FromContext(ctx).Error("count emails sent", "err", err)
+	}
+}
+
+// attemptSucceeded - Clears the failures of keys after a correct password.
+func (ctl *Controller) attemptSucceeded(ctx *gin.Context, keys ...ratelimit.Key) {
+	if err := ctl.limiter.Reset(ctx, keys...); err != nil {
+		logging.FromContext(ctx).Error("reset rate limit", "err", err)
+	}
+}
+
+func tooManyAttempts(ctx *gin.Context, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	apierror.WriteDetails(ctx, http.StatusTooManyRequests, apierror.CodeTooManyAttempts,
+		message, gin.H{"retry_after": seconds})
+}
+
+func keyIDs(keys []ratelimit.Key) []string {
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+	}
+	return ids
+}
@@ -1,43 +1,368 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"webrtc/apierror"
+	"webrtc/handlers"
 	"webrtc/interfaces"
-	"webrtc/utils"
+	"webrtc/logging"
+	"webrtc/middleware"
+	"webrtc/repository"
+	"webrtc/schedule"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // CreateSession - Creates user session
-func CreateSession(ctx *gin.Context) {
-
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("sessions")
+func (ctl *Controller) CreateSession(ctx *gin.Context) {
 
 	var session interfaces.Session
 
 	if err := ctx.ShouldBindJSON(&session); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Bind(ctx, err)
 		return
 	}
 
-	session.Password = utils.HashPassword(session.Password)
+	if session.Schedule != nil {
+		if _, err := session.Schedule.Build(); err != nil {
+			invalidField(ctx, "schedule", "schedule", "Invalid schedule: "+err.Error())
+			return
+		}
+	}
+
+	// The host is always the authenticated user, never the request body.
+	session.Host = middleware.UserID(ctx)
+	hash, ok := ctl.hashPassword(ctx, session.Password)
+	if !ok {
+		return
+	}
+	session.Password = hash
 
-	result, err := collection.InsertOne(ctx, session)
+	if err := ctl.sessions.Create(ctx, &session); err != nil {
+		logging.FromContext(ctx).Error("insert session", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	url, err := ctl.CreateSocket(ctx, session.ID)
 	if err != nil {
-  ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-  return
+		if delErr := ctl.sessions.Delete(ctx, session.ID); delErr != nil {
+			logging.FromContext(ctx).Error("remove session without socket", "session_id", session.ID, "err", delErr)
+		}
+		if errors.Is(err, repository.ErrDuplicate) {
+			apierror.Write(ctx, http.StatusConflict, apierror.CodeRoomCodeConflict, "Room code already in use, please try again.")
+			return
+		}
+		logging.FromContext(ctx).Error("insert socket", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
+		return
 	}
+	ctx.JSON(http.StatusOK, gin.H{"socket": url})
+}
 
-	insertedID, ok := result.InsertedID.(primitive.ObjectID)
+type sessionPatch struct {
+	Title    *string `json:"title" binding:"omitempty,notblank,max=100"`
+	Password *string `json:"password" binding:"omitempty,min=1,max=72"`
+	Settings *struct {
+		MaxParticipants *int      `json:"max_participants" binding:"omitempty,min=0,max=1000"`
+		Locked          *bool     `json:"locked"`
+		GuestRole       *string   `json:"guest_role" binding:"omitempty,oneof=presenter viewer"`
+		Mode            *string   `json:"mode" binding:"omitempty,oneof=meeting webinar"`
+		LastN           *int      `json:"last_n" binding:"omitempty,min=0,max=100"`
+		Codecs          *[]string `json:"codecs" binding:"omitempty,max=8,unique,dive,oneof=opus pcmu pcma g722 vp8 vp9 h264 av1"`
+	} `json:"settings"`
+	// Schedule replaces the whole schedule; null removes it.
+	Schedule json.RawMessage `json:"schedule"`
+}
+
+type sessionListQuery struct {
+	Page  int64  `form:"page,default=1" binding:"min=1"`
+	Limit int64  `form:"limit,default=20" binding:"min=1,max=100"`
+	Sort  string `form:"sort,default=-created" binding:"oneof=created -created title -title"`
+	Title string `form:"title" binding:"max=100"` // substring filter
+}
+
+// GetSessionByID - Returns one of the authenticated host's sessions.
+func (ctl *Controller) GetSessionByID(ctx *gin.Context) {
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
 	if !ok {
-  // Handle unexpected insertion result type
-  ctx.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected insertion result"})
-  return
+		return
 	}
 
-	url := CreateSocket(session, ctx, insertedID.Hex())
-	ctx.JSON(http.StatusOK, gin.H{"socket": url})
-}
\ No newline at end of file
+	ctx.JSON(http.StatusOK, ctl.sessionResponse(ctx, session))
+}
+
+// ListSessions - Lists the authenticated host's sessions, one page at a time.
+// Accepts page, limit, sort (created, -created, title, -title) and title
+// (substring filter) query parameters.
+func (ctl *Controller) ListSessions(ctx *gin.Context) {
+	var query sessionListQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	opts := repository.SessionListOptions{
+		Host:          middleware.UserID(ctx),
+		TitleContains: query.Title,
+		SortBy:        strings.TrimPrefix(query.Sort, "-"),
+		Descending:    strings.HasPrefix(query.Sort, "-"),
+		Skip:          (query.Page - 1) * query.Limit,
+		Limit:         query.Limit,
+	}
+
+	sessions, total, err := ctl.sessions.List(ctx, opts)
+	if err != nil {
+		logging.FromContext(ctx).Error("list sessions", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	results := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		results = append(results, ctl.sessionResponse(ctx, session))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"sessions": results,
+		"page":     query.Page,
+		"limit":    query.Limit,
+		"total":    total,
+	})
+}
+
+// UpdateSession - Changes the title, password or settings of a session.
+// Settings reach a room in progress when someone next joins it, see
+// SessionSettings. A new title or schedule bumps the calendar sequence so
+// invitations sent afterwards replace the earlier ones.
+func (ctl *Controller) UpdateSession(ctx *gin.Context) {
+	var patch sessionPatch
+	if err := ctx.ShouldBindJSON(&patch); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+
+	if patch.Title != nil && *patch.Title != session.Title {
+		session.Title = *patch.Title
+		session.Sequence++
+	}
+	if patch.Password != nil {
+		hash, ok := ctl.hashPassword(ctx, *patch.Password)
+		if !ok {
+			return
+		}
+		session.Password = hash
+	}
+	if patch.Settings != nil {
+		if v := patch.Settings.MaxParticipants; v != nil {
+			session.Settings.MaxParticipants = *v
+		}
+		if v := patch.Settings.Locked; v != nil {
+			session.Settings.Locked = *v
+		}
+		if v := patch.Settings.GuestRole; v != nil {
+			session.Settings.GuestRole = *v
+		}
+		if v := patch.Settings.Mode; v != nil {
+			session.Settings.Mode = *v
+		}
+		if v := patch.Settings.LastN; v != nil {
+			session.Settings.LastN = *v
+		}
+		if v := patch.Settings.Codecs; v != nil {
+			session.Settings.Codecs = *v
+		}
+	}
+	if patch.Schedule != nil {
+		var sched *interfaces.SessionSchedule
+		if err := json.Unmarshal(patch.Schedule, &sched); err != nil {
+			invalidField(ctx, "schedule", "schedule", "Invalid schedule: "+err.Error())
+			return
+		}
+		if sched != nil {
+			if _, err := sched.Build(); err != nil {
+				invalidField(ctx, "schedule", "schedule", "Invalid schedule: "+err.Error())
+				return
+			}
+		}
+		if !reflect.DeepEqual(sched, session.Schedule) {
+			session.Sequence++
+		}
+		session.Schedule = sched
+	}
+
+	if err := ctl.sessions.Update(ctx, session); err != nil {
+		logging.FromContext(ctx).Error("update session", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, ctl.sessionResponse(ctx, session))
+}
+
+// DeleteSession - Retires a session: removes its room code and disconnects
+// anyone still in the room.
+func (ctl *Controller) DeleteSession(ctx *gin.Context) {
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+
+	if err := ctl.deleteSession(ctx, session); err != nil {
+		logging.FromContext(ctx).Error("delete session", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// deleteSession - Removes the session, its socket document and live room,
+// and tells the people invited by email that it was cancelled.
+func (ctl *Controller) deleteSession(ctx *gin.Context, session interfaces.Session) error {
+	socket, err := ctl.sockets.FindBySessionID(ctx, session.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	// Rendered first, as the calendars need the room link
+	cancellations := ctl.cancellationMessages(ctx, session)
+
+	if err := ctl.sockets.DeleteBySessionID(ctx, session.ID); err != nil {
+		return err
+	}
+	if err := ctl.sessions.Delete(ctx, session.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	if socket.SocketURL != "" {
+		ctl.sfu.CloseRoom(socket.SocketURL)
+	}
+	if len(cancellations) > 0 {
+		ctl.mailInBackground(ctx, func(bg context.Context) error {
+			var errs []error
+			for _, msg := range cancellations {
+				if err := ctl.mailer.Send(bg, msg); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		})
+	}
+	return nil
+}
+
+// ownedSession - Loads a session and checks the authenticated user hosts it.
+// Sessions owned by someone else are reported as not found so their
+// existence isn't leaked. On failure the response has been written.
+func (ctl *Controller) ownedSession(ctx *gin.Context, id string) (interfaces.Session, bool) {
+	session, err := ctl.sessions.FindByID(ctx, id)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logging.FromContext(ctx).Error("find session", "session_id", id, "err", err)
+		apierror.Internal(ctx)
+		return session, false
+	}
+	if err != nil || session.Host != middleware.UserID(ctx) {
+		apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "Session not found.")
+		return interfaces.Session{}, false
+	}
+	return session, true
+}
+
+// sessionResponse - Renders a session for its host. Never includes the
+// password hash.
+func (ctl *Controller) sessionResponse(ctx *gin.Context, session interfaces.Session) gin.H {
+	response := gin.H{
+		"id":       session.ID,
+		"title":    session.Title,
+		"settings": session.Settings,
+	}
+	if session.Schedule != nil {
+		response["schedule"] = session.Schedule
+		if sched, err := session.Schedule.Build(); err == nil {
+			if next, ok := sched.NextOccurrence(time.Now()); ok {
+				response["next_occurrence"] = gin.H{"starts_at": next.Start, "ends_at": next.End}
+			}
+		}
+	}
+	if objectID, err := primitive.ObjectIDFromHex(session.ID); err == nil {
+		response["created_at"] = objectID.Timestamp()
+	}
+
+	socket, err := ctl.sockets.FindBySessionID(ctx, session.ID)
+	if err == nil {
+		response["coderoom"] = socket.HashedURL
+		response["participants"] = ctl.sfu.ParticipantCount(socket.SocketURL)
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		logging.FromContext(ctx).Error("find socket", "session_id", session.ID, "err", err)
+	}
+	return response
+}
+
+// joinWindow - Checks the session's schedule allows joining now. It returns
+// the occurrence being joined and when its room closes, both zero for
+// unscheduled sessions.
+func (ctl *Controller) joinWindow(session interfaces.Session) (schedule.Occurrence, time.Time, error) {
+	if session.Schedule == nil {
+		return schedule.Occurrence{}, time.Time{}, nil
+	}
+
+	sched, err := session.Schedule.Build()
+	if err != nil {
+		return schedule.Occurrence{}, time.Time{}, err
+	}
+
+	grace := ctl.cfg.Schedule.GracePeriod.Duration
+	occurrence, err := sched.Check(time.Now(), ctl.cfg.Schedule.EarlyJoin.Duration, grace)
+	if err != nil {
+		return occurrence, time.Time{}, err
+	}
+	return occurrence, occurrence.End.Add(grace), nil
+}
+
+// AdmitRoom - Admission check for WebSocket joins: the room must belong to a
+// session whose schedule is open.
+func (ctl *Controller) AdmitRoom(ctx context.Context, roomId string) (handlers.Admission, error) {
+	socket, err := ctl.sockets.FindBySocketURL(ctx, roomId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return handlers.Admission{}, handlers.ErrRoomNotFound
+	} else if err != nil {
+		return handlers.Admission{}, err
+	}
+
+	session, err := ctl.sessions.FindByID(ctx, socket.SessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return handlers.Admission{}, handlers.ErrRoomNotFound
+	} else if err != nil {
+		return handlers.Admission{}, err
+	}
+
+	_, closesAt, err := ctl.joinWindow(session)
+	switch {
+	case errors.Is(err, schedule.ErrTooEarly):
+		return handlers.Admission{}, handlers.ErrRoomNotOpen
+	case errors.Is(err, schedule.ErrEnded):
+		return handlers.Admission{}, handlers.ErrRoomEnded
+	case err != nil:
+		return handlers.Admission{}, err
+	}
+
+	return handlers.Admission{
+		ClosesAt:        closesAt,
+		MaxParticipants: session.Settings.MaxParticipants,
+		Webinar:         session.Settings.Mode == interfaces.SessionModeWebinar,
+		LastN:           session.Settings.LastN,
+		Codecs:          session.Settings.Codecs,
+	}, nil
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"webrtc/apierror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestCreateSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+
+	session, code := s.createSession(token, gin.H{"settings": gin.H{"max_participants": 5}})
+	if session.Password == "letmein" {
+		t.Error("session password stored in the clear")
+	}
+	if session.Settings.MaxParticipants != 5 {
+		t.Errorf("settings = %+v", session.Settings)
+	}
+	if !regexp.MustCompile(`^[a-z]{3}-[a-z]{4}-[a-z]{3}$`).MatchString(code) {
+		t.Errorf("room code %q does not match the pattern", code)
+	}
+
+	body := decode(t, s.do(http.MethodGet, "/sessions/"+session.ID, nil, token), http.StatusOK)
+	if body["title"] != "Standup" || body["coderoom"] != code {
+		t.Errorf("session = %v", body)
+	}
+	if _, ok := body["password"]; ok {
+		t.Error("response includes the password")
+	}
+
+	if w := s.do(http.MethodPost, "/session", gin.H{"title": "x", "password": "y"}, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous create status = %d", w.Code)
+	}
+}
+
+func TestCreateSessionRejects(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+
+	for name, body := range map[string]gin.H{
+		"no title":       {"password": "letmein"},
+		"no password":    {"title": "Standup"},
+		"bad guest role": {"title": "Standup", "password": "letmein", "settings": gin.H{"guest_role": "host"}},
+		"negative limit": {"title": "Standup", "password": "letmein", "settings": gin.H{"max_participants": -1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := decode(t, s.do(http.MethodPost, "/session", body, token), http.StatusBadRequest)
+			if got := errorCode(body); got != apierror.CodeValidationFailed {
+				t.Errorf("code = %q, want %q", got, apierror.CodeValidationFailed)
+			}
+		})
+	}
+
+	list := decode(t, s.do(http.MethodGet, "/sessions", nil, token), http.StatusOK)
+	if list["total"] != float64(0) {
+		t.Errorf("rejected sessions stored: %v", list)
+	}
+}
+
+func TestCreateSessionValidatesSchedule(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	for name, schedule := range map[string]gin.H{
+		"ends before start": {"starts_at": start, "ends_at": start.Add(-time.Minute)},
+		"bad rrule":         {"starts_at": start, "ends_at": start.Add(time.Hour), "recurrence": "FREQ=SOMETIMES"},
+		"bad zone":          {"starts_at": start, "ends_at": start.Add(time.Hour), "time_zone": "Mars/Olympus"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := decode(t, s.do(http.MethodPost, "/session", gin.H{"title": "x", "password": "y", "schedule": schedule}, token), http.StatusBadRequest)
+			if got := errorCode(body); got != apierror.CodeValidationFailed {
+				t.Errorf("code = %q, want %q", got, apierror.CodeValidationFailed)
+			}
+		})
+	}
+}
+
+func TestSessionsAreOwned(t *testing.T) {
+	s := newTestServer(t, nil)
+	ann := s.signUp("ann", "ann@example.com")
+	bob := s.signUp("bob", "bob@example.com")
+
+	session, code := s.createSession(ann, nil)
+
+	for _, req := range []struct {
+		method string
+		body   interface{}
+	}{
+		{http.MethodGet, nil},
+		{http.MethodPatch, gin.H{"title": "Mine now"}},
+		{http.MethodDelete, nil},
+	} {
+		body := decode(t, s.do(req.method, "/sessions/"+session.ID, req.body, bob), http.StatusNotFound)
+		if got := errorCode(body); got != apierror.CodeNotFound {
+			t.Errorf("%s: code = %q, want %q", req.method, got, apierror.CodeNotFound)
+		}
+	}
+
+	list := decode(t, s.do(http.MethodGet, "/sessions", nil, bob), http.StatusOK)
+	if list["total"] != float64(0) {
+		t.Errorf("bob's sessions = %v", list)
+	}
+	if w := s.do(http.MethodGet, "/connect?url="+code, nil, ""); w.Code != http.StatusOK {
+		t.Errorf("session gone after bob's attempts: %d", w.Code)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+
+	for _, title := range []string{"Charlie", "Alpha", "Bravo"} {
+		s.createSession(token, gin.H{"title": title})
+	}
+
+	body := decode(t, s.do(http.MethodGet, "/sessions?sort=title&limit=2", nil, token), http.StatusOK)
+	if body["total"] != float64(3) || body["page"] != float64(1) || body["limit"] != float64(2) {
+		t.Errorf("page = %v", body)
+	}
+	sessions, _ := body["sessions"].([]interface{})
+	var titles []string
+	for _, session := range sessions {
+		titles = append(titles, session.(map[string]interface{})["title"].(string))
+	}
+	if len(titles) != 2 || titles[0] != "Alpha" || titles[1] != "Bravo" {
+		t.Errorf("titles = %v, want [Alpha Bravo]", titles)
+	}
+
+	body = decode(t, s.do(http.MethodGet, "/sessions?sort=title&limit=2&page=2", nil, token), http.StatusOK)
+	if sessions, _ := body["sessions"].([]interface{}); len(sessions) != 1 {
+		t.Errorf("second page = %v", body)
+	}
+
+	body = decode(t, s.do(http.MethodGet, "/sessions?title=rav", nil, token), http.StatusOK)
+	if body["total"] != float64(1) {
+		t.Errorf("filtered = %v", body)
+	}
+
+	body = decode(t, s.do(http.MethodGet, "/sessions?limit=1000", nil, token), http.StatusBadRequest)
+	if got := errorCode(body); got != apierror.CodeValidationFailed {
+		t.Errorf("code = %q, want %q", got, apierror.CodeValidationFailed)
+	}
+}
+
+func TestUpdateSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	session, code := s.createSession(token, nil)
+
+	start := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	body := decode(t, s.do(http.MethodPatch, "/sessions/"+session.ID, gin.H{
+		"title":    "Retro",
+		"password": "newpass",
+		"settings": gin.H{"locked": true, "mode": "webinar"},
+		"schedule": gin.H{"starts_at": start, "ends_at": start.Add(time.Hour)},
+	}, token), http.StatusOK)
+	if body["title"] != "Retro" || body["schedule"] == nil || body["next_occurrence"] == nil {
+		t.Errorf("updated = %v", body)
+	}
+
+	updated, err := s.stores.Sessions.FindByID(context.Background(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Settings.Locked || updated.Settings.Mode != "webinar" || updated.Settings.MaxParticipants != session.Settings.MaxParticipants {
+		t.Errorf("settings = %+v", updated.Settings)
+	}
+	if updated.Sequence != 2 {
+		t.Errorf("sequence = %d after a new title and schedule, want 2", updated.Sequence)
+	}
+	if match, _ := s.ctl.passwords.Verify(updated.Password, "newpass"); !match {
+		t.Error("password not changed")
+	}
+
+	// Settings alone leave the calendar sequence alone, and null removes
+	// the schedule
+	decode(t, s.do(http.MethodPatch, "/sessions/"+session.ID, gin.H{"settings": gin.H{"locked": false}}, token), http.StatusOK)
+	body = decode(t, s.do(http.MethodPatch, "/sessions/"+session.ID, map[string]interface{}{"schedule": nil}, token), http.StatusOK)
+	if _, ok := body["schedule"]; ok {
+		t.Errorf("schedule not removed: %v", body)
+	}
+	updated, _ = s.stores.Sessions.FindByID(context.Background(), session.ID)
+	if updated.Sequence != 3 || updated.Settings.Locked {
+		t.Errorf("sequence = %d, locked = %v; want 3, false", updated.Sequence, updated.Settings.Locked)
+	}
+
+	decode(t, s.do(http.MethodPatch, "/sessions/"+session.ID, gin.H{"title": " "}, token), http.StatusBadRequest)
+	if body["coderoom"] != code {
+		t.Errorf("room code changed to %v", body["coderoom"])
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	session, code := s.createSession(token, nil)
+
+	if w := s.do(http.MethodDelete, "/sessions/"+session.ID, nil, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body)
+	}
+
+	decode(t, s.do(http.MethodGet, "/sessions/"+session.ID, nil, token), http.StatusNotFound)
+	decode(t, s.do(http.MethodGet, "/connect?url="+code, nil, ""), http.StatusNotFound)
+	decode(t, s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "letmein"}, ""), http.StatusNotFound)
+}
+
+func TestSessionNotFound(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	session, _ := s.createSession(token, nil)
+	if w := s.do(http.MethodDelete, "/sessions/"+session.ID, nil, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body)
+	}
+
+	for _, id := range []string{session.ID, "not-an-id"} {
+		for _, req := range []struct {
+			method string
+			body   interface{}
+		}{
+			{http.MethodGet, nil},
+			{http.MethodPatch, gin.H{"title": "Retro"}},
+			{http.MethodDelete, nil},
+		} {
+			body := decode(t, s.do(req.method, "/sessions/"+id, req.body, token), http.StatusNotFound)
+			if got := errorCode(body); got != apierror.CodeNotFound {
+				t.Errorf("%s %s: code = %q, want %q", req.method, id, got, apierror.CodeNotFound)
+			}
+		}
+	}
+}
+
+func TestDeleteSessionClosesRoom(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	session, code := s.createSession(token, nil)
+
+	server := httptest.NewServer(s.router)
+	defer server.Close()
+	join := decode(t, s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "letmein"}, ""), http.StatusOK)
+	room := join["socket"].(string)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/websocket/" + room + "?token=" + join["token"].(string)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("join room: %v", err)
+	}
+	defer conn.Close()
+
+	if w := s.do(http.MethodDelete, "/sessions/"+session.ID, nil, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var message struct{ Event string }
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("connection ended without room-closed: %v", err)
+		}
+		if message.Event == "room-closed" {
+			break
+		}
+	}
+	if participants := s.ctl.sfu.Participants(room); len(participants) != 0 {
+		t.Errorf("participants %v still in the room", participants)
+	}
+}
+
+func TestCreateSessionRetriesRoomCodes(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomCode.Alphabet = "ab"
+	cfg.RoomCode.Pattern = "1"
+	cfg.RoomCode.MaxAttempts = 50
+	s := newTestServer(t, cfg)
+	token := s.signUp("ann", "ann@example.com")
+
+	// Drawing again finds the one free code
+	_, first := s.createSession(token, nil)
+	_, second := s.createSession(token, nil)
+	if first == second {
+		t.Fatalf("both sessions got room code %q", first)
+	}
+
+	body := decode(t, s.do(http.MethodPost, "/session", gin.H{"title": "x", "password": "y"}, token), http.StatusConflict)
+	if got := errorCode(body); got != apierror.CodeRoomCodeConflict {
+		t.Errorf("code = %q, want %q", got, apierror.CodeRoomCodeConflict)
+	}
+	list := decode(t, s.do(http.MethodGet, "/sessions", nil, token), http.StatusOK)
+	if list["total"] != float64(2) {
+		t.Errorf("session without a room code kept: %v", list)
+	}
+}
@@ -1,168 +1,255 @@
 package controllers
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
-	"fmt"
+	"errors"
 	"net/http"
-	"time"
+	"strings"
+	"webrtc/apierror"
+	"webrtc/handlers"
 	"webrtc/interfaces"
+	"webrtc/logging"
+	"webrtc/repository"
+	"webrtc/schedule"
 	"webrtc/utils"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/google/uuid"
 )
 
-// ConnectSession - Given a host and a password, returns the session object.
-func ConnectSession(ctx *gin.Context) {
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("sockets")
+type connectInput struct {
+	Password    string `json:"password" binding:"required,max=72"`
+	DisplayName string `json:"display_name" binding:"omitempty,notblank,max=64"`
+}
+
+// defaultGuestName - Shown for guests who don't give a name.
+const defaultGuestName = "Guest"
 
+// ConnectSession - Given a room code and the session password, returns a
+// guest join token for the session's room.
+func (ctl *Controller) ConnectSession(ctx *gin.Context) {
 	url := ctx.Param("url")
-	result := collection.FindOne(ctx, bson.M{"hashedurl": url})
 
-	var input interfaces.Session
+	var input connectInput
 	if err := ctx.ShouldBindJSON(&input); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Bind(ctx, err)
 		return
 	}
 
-	if result.Err() != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Socket connection not found."})
+	keys := ctl.connectKeys(ctx, url)
+	if ctl.throttled(ctx, keys) {
 		return
 	}
 
-	var socket interfaces.Socket
-	result.Decode(&socket)
+	socket, err := ctl.sockets.FindByHashedURL(ctx, url)
+	if err != nil {
+		// Guessing room codes counts against the IP like guessing passwords.
+		ctl.attemptFailed(ctx, keys[:1])
+		apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "Session not found.")
+		return
+	}
 
-	collection = db.Database("MeetKobi").Collection("sessions")
-	objectID, err := primitive.ObjectIDFromHex(socket.SessionID)
+	session, err := ctl.sessions.FindByID(ctx, socket.SessionID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Session not found."})
+		apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "Session not found.")
 		return
 	}
 
-	result = collection.FindOne(ctx, bson.M{"_id": objectID})
-	if result.Err() != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Session not found."})
+	if !ctl.passwordMatches(ctx, session.Password, input.Password) {
+		ctl.attemptFailed(ctx, keys)
+		apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid password.")
+		return
+	}
+	ctl.attemptSucceeded(ctx, keys[1])
+	ctl.upgradeSessionHash(ctx, session, input.Password)
+
+	occurrence, _, err := ctl.joinWindow(session)
+	switch {
+	case errors.Is(err, schedule.ErrTooEarly):
+		apierror.WriteDetails(ctx, http.StatusForbidden, apierror.CodeSessionNotStarted, "Session has not started yet.",
+			gin.H{"starts_at": occurrence.Start})
+		return
+	case errors.Is(err, schedule.ErrEnded):
+		apierror.Write(ctx, http.StatusGone, apierror.CodeSessionEnded, "Session has ended.")
+		return
+	case err != nil:
+		logging.FromContext(ctx).Error("check session schedule", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
 		return
 	}
 
-	var session interfaces.Session
-	result.Decode(&session)
+	if session.Settings.Locked {
+		apierror.Write(ctx, http.StatusForbidden, apierror.CodeSessionLocked, "Session is locked.")
+		return
+	}
+	if limit := session.Settings.MaxParticipants; limit > 0 && ctl.sfu.ParticipantCount(socket.SocketURL) >= limit {
+		apierror.Write(ctx, http.StatusForbidden, apierror.CodeSessionFull, "Session is full.")
+		return
+	}
 
-	if !utils.ComparePasswords(session.Password, []byte(input.Password)) {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password."})
+	name := strings.TrimSpace(input.DisplayName)
+	if name == "" {
+		name = defaultGuestName
+	}
+	role := session.Settings.GuestRole
+	switch {
+	case session.Settings.Mode == interfaces.SessionModeWebinar:
+		role = handlers.RoleViewer
+	case role == "":
+		role = handlers.RolePresenter
+	}
+	ctl.joinResponse(ctx, session, socket, handlers.Participant{
+		DisplayName: name,
+		Role:        role,
+		Guest:       true,
+	})
+}
+
+// JoinSession - Returns a join token for the authenticated host's own
+// session. The host may join when the session is locked or full.
+func (ctl *Controller) JoinSession(ctx *gin.Context) {
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	host, ok := ctl.currentUser(ctx)
+	if !ok {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"title":  session.Title,
-		"socket": socket.SocketURL,
+	socket, err := ctl.sockets.FindBySessionID(ctx, session.ID)
+	if err != nil {
+		logging.FromContext(ctx).Error("find socket", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctl.joinResponse(ctx, session, socket, handlers.Participant{
+		DisplayName: host.UserName,
+		Role:        handlers.RoleHost,
+		UserID:      host.ID,
 	})
 }
 
-// GetSession - Checks if session exists.
-func GetSession(ctx *gin.Context) {
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("sockets")
+// ListParticipants - Returns who is connected to the host's session.
+func (ctl *Controller) ListParticipants(ctx *gin.Context) {
+	session, ok := ctl.ownedSession(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
 
-	id := ctx.Request.URL.Query()["url"][0]
-	result := collection.FindOne(ctx, bson.M{"hashedurl": id})
+	socket, err := ctl.sockets.FindBySessionID(ctx, session.ID)
+	if err != nil {
+		logging.FromContext(ctx).Error("find socket", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
 
-	if result.Err() != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Socket connection not found."})
+	ctx.JSON(http.StatusOK, gin.H{"participants": ctl.sfu.Participants(socket.SocketURL)})
+}
+
+// joinResponse - Issues a join token admitting participant to the
+// session's room under a new participant ID.
+func (ctl *Controller) joinResponse(ctx *gin.Context, session interfaces.Session, socket interfaces.Socket, participant handlers.Participant) {
+	participant.ID = uuid.NewString()
+
+	token, err := ctl.auth.GenerateJoinToken(socket.SocketURL, participant)
+	if err != nil {
+		logging.FromContext(ctx).Error("issue join token", "session_id", session.ID, "err", err)
+		apierror.Internal(ctx)
 		return
 	}
 
-	ctx.Status(http.StatusOK)
+	ctx.JSON(http.StatusOK, gin.H{
+		"title":          session.Title,
+		"socket":         socket.SocketURL,
+		"token":          token,
+		"expires_in":     int(ctl.auth.JoinTTL().Seconds()),
+		"participant_id": participant.ID,
+	})
 }
 
-func GetSessionbyHost(ctx *gin.Context) {
-	db := ctx.MustGet("db").(*mongo.Client)
-	sessionCollection := db.Database("MeetKobi").Collection("sessions")
-	socketsCollection := db.Database("MeetKobi").Collection("sockets")
+// GetSession - Checks if session exists.
+func (ctl *Controller) GetSession(ctx *gin.Context) {
+	id := ctx.Query("url")
+	keys := ctl.connectKeys(ctx, id)[:1]
+	if ctl.throttled(ctx, keys) {
+		return
+	}
 
-	var session interfaces.Sessionget
-	if err := ctx.ShouldBindJSON(&session); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+	if _, err := ctl.sockets.FindByHashedURL(ctx, id); err != nil {
+		ctl.attemptFailed(ctx, keys)
+		apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "Session not found.")
+		return
 	}
 
-	// Define the options for sorting by _id field
-	findOptions := options.Find()
-	findOptions.SetSort(bson.D{{Key: "_id", Value: -1}}) // Sorting by _id field in descending order
+	ctx.Status(http.StatusOK)
+}
 
-	cursor, err := sessionCollection.Find(ctx, bson.M{"host": session.Host}, findOptions)
-	if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find sessions"})
-			return
+// GetSessionbyHost - Lists the sessions hosted by the authenticated user.
+func (ctl *Controller) GetSessionbyHost(ctx *gin.Context) {
+	host, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
 	}
-	defer cursor.Close(ctx)
 
-	var sessions []interfaces.Sessionget
-	if err = cursor.All(ctx, &sessions); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode sessions"})
-			return
+	sessions, err := ctl.sessions.FindByHost(ctx, host.ID)
+	if err != nil {
+		logging.FromContext(ctx).Error("find sessions", "err", err)
+		apierror.Internal(ctx)
+		return
 	}
 
 	if len(sessions) == 0 {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "No sessions found for the given host"})
-			return
+		apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "No sessions found for the given host.")
+		return
 	}
 
 	var results []gin.H
 
 	for _, sess := range sessions {
-			var socketData interfaces.Socket
-			err = socketsCollection.FindOne(ctx, bson.M{"sessionid": sess.ID}).Decode(&socketData)
-			if err != nil {
-					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find socket data"})
-					return
-			}
-			results = append(results, gin.H{
-					"host":  sess.Host,
-					"title": sess.Title,
-					"coderoom":  socketData.HashedURL,
-			})
+		socketData, err := ctl.sockets.FindBySessionID(ctx, sess.ID)
+		if err != nil {
+			logging.FromContext(ctx).Error("find socket", "session_id", sess.ID, "err", err)
+			apierror.Internal(ctx)
+			return
+		}
+		results = append(results, gin.H{
+			"id":       sess.ID,
+			"host":     host.UserName,
+			"title":    sess.Title,
+			"coderoom": socketData.HashedURL,
+		})
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"sessions": results})
 }
 
+// CreateSocket - Creates socket connection with given session. A fresh
+// room code is drawn until one is free, up to the configured attempts.
+func (ctl *Controller) CreateSocket(ctx *gin.Context, sessionID string) (string, error) {
+	socketURL, err := utils.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
 
-
-// CreateSocket - Creates socket connection with given session.
-func CreateSocket(session interfaces.Session, ctx *gin.Context, id string) string {
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("sockets")
-	now := time.Now()
-
-	seconds := now.Unix()
 	var socket interfaces.Socket
-	hashURL := hashURL(session.Host + session.Title + fmt.Sprintf("%d", seconds))
-	socketURL := hashSession(session.Host + session.Password)
-	socket.SessionID = id
-	socket.HashedURL = hashURL
+	socket.SessionID = sessionID
 	socket.SocketURL = socketURL
 
-	collection.InsertOne(ctx, socket)
-
-	return hashURL
-}
-
-func hashSession(str string) string {
-	hash := sha1.New()
-	hash.Write([]byte(str))
-	return hex.EncodeToString(hash.Sum(nil))
-}
-
-func hashURL(str string) string {
-	hash := sha1.New()
-	hash.Write([]byte(str))
-	hashSum := hash.Sum(nil)[:3] // Trim to first 3 bytes
-	return hex.EncodeToString(hashSum)
+	for attempt := 1; ; attempt++ {
+		code, err := ctl.roomCodes.Generate()
+		if err != nil {
+			return "", err
+		}
+		socket.HashedURL = code
+
+		err = ctl.sockets.Create(ctx, &socket)
+		if err == nil {
+			return code, nil
+		}
+		if !errors.Is(err, repository.ErrDuplicate) || attempt >= ctl.cfg.RoomCode.MaxAttempts {
+			return "", err
+		}
+		logging.FromContext(ctx).Warn("room code collision, retrying", "attempt", attempt)
+	}
 }
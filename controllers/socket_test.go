@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"webrtc/apierror"
+	"webrtc/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConnectSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	session, code := s.createSession(token, nil)
+
+	if w := s.do(http.MethodGet, "/connect?url="+code, nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("lookup status = %d", w.Code)
+	}
+
+	body := decode(t, s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "letmein", "display_name": " Bob "}, ""), http.StatusOK)
+	if body["title"] != session.Title || body["participant_id"] == "" {
+		t.Errorf("join = %v", body)
+	}
+	socket, _ := body["socket"].(string)
+	participant, _, err := s.ctl.auth.ValidateJoinToken(body["token"].(string), socket)
+	if err != nil {
+		t.Fatalf("join token: %v", err)
+	}
+	if participant.DisplayName != "Bob" || participant.Role != handlers.RolePresenter || !participant.Guest || participant.ID != body["participant_id"] {
+		t.Errorf("participant = %+v", participant)
+	}
+
+	body = decode(t, s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "letmein"}, ""), http.StatusOK)
+	participant, _, _ = s.ctl.auth.ValidateJoinToken(body["token"].(string), socket)
+	if participant.DisplayName != defaultGuestName {
+		t.Errorf("unnamed guest is called %q", participant.DisplayName)
+	}
+}
+
+func TestConnectSessionRejects(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	_, code := s.createSession(token, nil)
+	_, locked := s.createSession(token, gin.H{"settings": gin.H{"locked": true}})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	_, early := s.createSession(token, gin.H{"schedule": gin.H{"starts_at": now.Add(time.Hour), "ends_at": now.Add(2 * time.Hour)}})
+	_, ended := s.createSession(token, gin.H{"schedule": gin.H{"starts_at": now.Add(-3 * time.Hour), "ends_at": now.Add(-2 * time.Hour)}})
+
+	for _, tc := range []struct {
+		name     string
+		code     string
+		password string
+		status   int
+		error    string
+	}{
+		{"unknown room", "aaa-aaaa-aaa", "letmein", http.StatusNotFound, apierror.CodeNotFound},
+		{"wrong password", code, "wrong", http.StatusUnauthorized, apierror.CodeInvalidCredentials},
+		{"locked", locked, "letmein", http.StatusForbidden, apierror.CodeSessionLocked},
+		{"not started", early, "letmein", http.StatusForbidden, apierror.CodeSessionNotStarted},
+		{"ended", ended, "letmein", http.StatusGone, apierror.CodeSessionEnded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := decode(t, s.do(http.MethodPost, "/connect/"+tc.code, gin.H{"password": tc.password}, ""), tc.status)
+			if got := errorCode(body); got != tc.error {
+				t.Errorf("code = %q, want %q", got, tc.error)
+			}
+			if tc.error == apierror.CodeSessionNotStarted {
+				details, _ := body["error"].(map[string]interface{})["details"].(map[string]interface{})
+				if details["starts_at"] == nil {
+					t.Errorf("no starts_at in %v", body)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectSessionRoles(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+
+	for _, tc := range []struct {
+		settings gin.H
+		role     string
+	}{
+		{gin.H{"guest_role": "viewer"}, handlers.RoleViewer},
+		{gin.H{"mode": "webinar", "guest_role": "presenter"}, handlers.RoleViewer},
+		{gin.H{"mode": "meeting"}, handlers.RolePresenter},
+	} {
+		_, code := s.createSession(token, gin.H{"settings": tc.settings})
+		body := decode(t, s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "letmein"}, ""), http.StatusOK)
+		participant, _, err := s.ctl.auth.ValidateJoinToken(body["token"].(string), body["socket"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if participant.Role != tc.role {
+			t.Errorf("%v: role = %q, want %q", tc.settings, participant.Role, tc.role)
+		}
+	}
+}
+
+func TestConnectSessionThrottlesGuessing(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	_, code := s.createSession(token, nil)
+
+	w := s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "wrong"}, "")
+	for attempt := 1; w.Code == http.StatusUnauthorized; attempt++ {
+		if attempt > s.cfg.RateLimit.Account.FreeAttempts+1 {
+			t.Fatalf("not throttled after %d wrong passwords", attempt)
+		}
+		w = s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "wrong"}, "")
+	}
+	if got := errorCode(decode(t, w, http.StatusTooManyRequests)); got != apierror.CodeTooManyAttempts {
+		t.Errorf("code = %q, want %q", got, apierror.CodeTooManyAttempts)
+	}
+	// Guests elsewhere can still get in
+	decode(t, s.doFrom("198.51.100.7:1234", http.MethodPost, "/connect/"+code, gin.H{"password": "letmein"}, ""), http.StatusOK)
+	w = s.do(http.MethodPost, "/connect/"+code, gin.H{"password": "letmein"}, "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("guesser got status %d with the right password during the lockout", w.Code)
+	}
+}
@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"webrtc/apierror"
+	"webrtc/interfaces"
+	"webrtc/logging"
+	"webrtc/repository"
+	"webrtc/sso"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ssoCookie keeps the secrets of a sign-in in the browser while it is
+	// away at the provider.
+	ssoCookie     = "sso_login"
+	ssoCookiePath = "/auth/oidc/"
+	// ssoLoginTTL - How long the user has to sign in at the provider.
+	ssoLoginTTL = 10 * time.Minute
+	// ssoCodeTTL - How long the frontend has to exchange its code.
+	ssoCodeTTL = time.Minute
+	// ssoUsernameAttempts - Generated usernames tried before giving up.
+	ssoUsernameAttempts = 5
+)
+
+// ssoState - The cookie contents.
+type ssoState struct {
+	Provider string `json:"provider"`
+	sso.Login
+}
+
+type ssoExchangeInput struct {
+	Code string `json:"code" binding:"required,max=128"`
+}
+
+// ListSSOProviders - Lists the providers users can sign in with.
+func (ctl *Controller) ListSSOProviders(ctx *gin.Context) {
+	providers := make([]gin.H, 0)
+	for _, p := range ctl.sso.Providers() {
+		providers = append(providers, gin.H{
+			"name":         p.Name(),
+			"display_name": p.DisplayName(),
+			"login_url":    ssoCookiePath + p.Name() + "/login",
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// StartSSO - Sends the browser to the provider to sign in.
+func (ctl *Controller) StartSSO(ctx *gin.Context) {
+	provider, ok := ctl.ssoProvider(ctx)
+	if !ok {
+		return
+	}
+
+	state := ssoState{Provider: provider.Name(), Login: sso.NewLogin()}
+	target, err := provider.AuthCodeURL(ctx, state.Login)
+	if err != nil {
+		logging.FromContext(ctx).Error("start sso", "provider", provider.Name(), "err", err)
+		ctl.completeSSO(ctx, "error", apierror.CodeSSOFailed)
+		return
+	}
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		logging.FromContext(ctx).Error("encode sso state", "err", err)
+		ctl.completeSSO(ctx, "error", apierror.CodeSSOFailed)
+		return
+	}
+	ctl.setSSOCookie(ctx, base64.RawURLEncoding.EncodeToString(value), int(ssoLoginTTL.Seconds()))
+
+	ctx.Redirect(http.StatusFound, target)
+}
+
+// FinishSSO - Handles the provider's redirect back. The signed in user's
+// account is looked up, linked or created, and the browser is sent on to
+// the frontend with a one-time code for ExchangeSSOCode.
+func (ctl *Controller) FinishSSO(ctx *gin.Context) {
+	provider, ok := ctl.ssoProvider(ctx)
+	if !ok {
+		return
+	}
+
+	log := logging.FromContext(ctx).With("provider", provider.Name())
+
+	state, ok := readSSOState(ctx)
+	ctl.setSSOCookie(ctx, "", -1)
+	if !ok || state.Provider != provider.Name() ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(ctx.Query("state"))) != 1 {
+		log.Warn("sso callback with missing or mismatched state")
+		ctl.completeSSO(ctx, "error", apierror.CodeSSOFailed)
+		return
+	}
+
+	if reason := ctx.Query("error"); reason != "" {
+		log.Info("sso refused by provider", "reason", reason)
+		ctl.completeSSO(ctx, "error", apierror.CodeSSODenied)
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, ctx.Query("code"), state.Login)
+	if err != nil {
+		if errors.Is(err, sso.ErrNoEmail) {
+			ctl.completeSSO(ctx, "error", apierror.CodeSSONoEmail)
+			return
+		}
+		log.Error("finish sso", "err", err)
+		ctl.completeSSO(ctx, "error", apierror.CodeSSOFailed)
+		return
+	}
+
+	user, code := ctl.ssoAccount(ctx, identity)
+	if code != "" {
+		ctl.completeSSO(ctx, "error", code)
+		return
+	}
+
+	token, err := ctl.createActionToken(ctx, user, interfaces.PurposeSSOLogin, ssoCodeTTL)
+	if err != nil {
+		log.Error("create sso login code", "user_id", user.ID, "err", err)
+		ctl.completeSSO(ctx, "error", apierror.CodeInternal)
+		return
+	}
+
+	ctl.completeSSO(ctx, "code", token)
+}
+
+// ExchangeSSOCode - Trades the code from FinishSSO for the same tokens a
+// password login returns.
+func (ctl *Controller) ExchangeSSOCode(ctx *gin.Context) {
+	var input ssoExchangeInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	user, ok := ctl.consumeActionToken(ctx, input.Code, interfaces.PurposeSSOLogin)
+	if !ok {
+		return
+	}
+
+	tokens, err := ctl.issueTokens(ctx, user.ID, "")
+	if err != nil {
+		logging.FromContext(ctx).Error("issue tokens", "user_id", user.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	tokens["status"] = "success"
+	tokens["user"] = userResponse(user)
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// ssoAccount - Returns the user the identity is linked to. An unlinked
+// identity is only accepted if the provider vouches for its email: it is
+// linked to the account with that address if that account verified it
+// too, or otherwise gets a new account. On failure the user is empty and
+// the error code for the frontend is returned.
+func (ctl *Controller) ssoAccount(ctx *gin.Context, identity sso.Identity) (interfaces.User, string) {
+	log := logging.FromContext(ctx).With("provider", identity.Provider)
+
+	user, err := ctl.users.FindByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, ""
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Error("find user by identity", "err", err)
+		return user, apierror.CodeInternal
+	}
+
+	// Without the provider's word the address may belong to someone else,
+	// who must neither be signed in to their account nor have it claimed
+	// by a new one.
+	if !identity.EmailVerified {
+		log.Info("sso email not verified by provider, refusing new identity")
+		return interfaces.User{}, apierror.CodeSSOEmailUnverified
+	}
+
+	link := interfaces.Identity{Provider: identity.Provider, Subject: identity.Subject}
+
+	user, err = ctl.users.FindByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		// Anyone can sign up with an address they don't own. Linking such
+		// an account would hand the owner an account whose password the
+		// person who signed up still knows.
+		if !user.EmailVerified {
+			log.Info("sso email belongs to an unverified account, not linking", "user_id", user.ID)
+			return interfaces.User{}, apierror.CodeEmailTaken
+		}
+		user.Identities = append(user.Identities, link)
+		if err := ctl.users.Update(ctx, user); err != nil {
+			log.Error("link identity", "user_id", user.ID, "err", err)
+			return interfaces.User{}, apierror.CodeInternal
+		}
+		log.Info("linked identity to account", "user_id", user.ID)
+		return user, ""
+	case !errors.Is(err, repository.ErrNotFound):
+		log.Error("find user", "err", err)
+		return interfaces.User{}, apierror.CodeInternal
+	}
+
+	user, code := ctl.createSSOUser(ctx, identity, link)
+	if code != "" {
+		return user, code
+	}
+	log.Info("created account from identity", "user_id", user.ID)
+	return user, ""
+}
+
+// createSSOUser - Creates a passwordless account for the identity, whose
+// email the provider has verified. The username comes from the provider's
+// claims, with a random suffix if it is taken.
+func (ctl *Controller) createSSOUser(ctx *gin.Context, identity sso.Identity, link interfaces.Identity) (interfaces.User, string) {
+	base := ssoUsername(identity)
+
+	for attempt := 0; attempt < ssoUsernameAttempts; attempt++ {
+		user := interfaces.User{
+			UserName:      base,
+			Email:         identity.Email,
+			EmailVerified: true,
+			Identities:    []interfaces.Identity{link},
+		}
+		if attempt > 0 {
+			user.UserName = fmt.Sprintf("%s-%04d", base, rand.Intn(10000))
+		}
+
+		err := ctl.users.Create(ctx, &user)
+		if err == nil {
+			return user, ""
+		}
+
+		var dup *repository.DuplicateError
+		if !errors.As(err, &dup) {
+			logging.FromContext(ctx).Error("insert user", "err", err)
+			return interfaces.User{}, apierror.CodeInternal
+		}
+		if dup.Field != "username" {
+			// Lost a race with another sign-in for the same email or
+			// identity; signing in again finds that account.
+			return interfaces.User{}, apierror.CodeAlreadyExists
+		}
+	}
+
+	logging.FromContext(ctx).Error("no free username for sso account", "base", base)
+	return interfaces.User{}, apierror.CodeUsernameTaken
+}
+
+// ssoUsername - Picks a username from the identity's claims that passes
+// the same rules as a chosen one, leaving room for a suffix.
+func ssoUsername(identity sso.Identity) string {
+	local, _, _ := strings.Cut(identity.Email, "@")
+
+	for _, candidate := range []string{identity.Username, identity.Name, local} {
+		candidate = strings.TrimSpace(candidate)
+		for utf8.RuneCountInString(candidate) > 27 {
+			_, size := utf8.DecodeLastRuneInString(candidate)
+			candidate = strings.TrimSpace(candidate[:len(candidate)-size])
+		}
+		if utf8.RuneCountInString(candidate) >= 3 {
+			return candidate
+		}
+	}
+	return "user"
+}
+
+// ssoProvider - Looks up the provider named in the path. Writes a 404 and
+// returns false if there is none.
+func (ctl *Controller) ssoProvider(ctx *gin.Context) (*sso.Provider, bool) {
+	provider, ok := ctl.sso.Provider(ctx.Param("provider"))
+	if !ok {
+		apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "Unknown sign-in provider.")
+		return nil, false
+	}
+	return provider, true
+}
+
+// completeSSO - Redirects to the frontend's completion page with key=value
+// in the query.
+func (ctl *Controller) completeSSO(ctx *gin.Context, key, value string) {
+	// Validated when the configuration was loaded.
+	target, _ := url.Parse(ctl.cfg.OIDC.CompleteURL)
+	query := target.Query()
+	query.Set(key, value)
+	target.RawQuery = query.Encode()
+
+	ctx.Redirect(http.StatusFound, target.String())
+}
+
+// setSSOCookie - Sets or, with a negative maxAge, clears the state cookie.
+func (ctl *Controller) setSSOCookie(ctx *gin.Context, value string, maxAge int) {
+	secure := ctx.Request.TLS != nil || strings.HasPrefix(ctl.cfg.Server.HostURL, "https://")
+
+	// Lax, so the cookie comes along on the provider's redirect back.
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(ssoCookie, value, maxAge, ssoCookiePath, "", secure, true)
+}
+
+func readSSOState(ctx *gin.Context) (ssoState, bool) {
+	var state ssoState
+
+	value, err := ctx.Cookie(ssoCookie)
+	if err != nil {
+		return state, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return state, false
+	}
+	if err := json.Unmarshal(data, &state); err != nil || state.State == "" {
+		return state, false
+	}
+	return state, true
+}
@@ -0,0 +1,441 @@
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"webrtc/apierror"
+	"webrtc/config"
+	"webrtc/interfaces"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mockIssuer - An OpenID Connect provider that signs in whoever it is
+// told to, checking the client and the PKCE verifier at its token
+// endpoint.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant // by authorization code
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+const (
+	mockClientID     = "meetkobi"
+	mockClientSecret = "secret"
+	mockKeyID        = "test-key"
+)
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": mockKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize - Plays the provider's sign-in page: checks the request the
+// browser was sent with and returns the callback query.
+func (m *mockIssuer) authorize(location string, claims map[string]interface{}) url.Values {
+	m.t.Helper()
+
+	target, err := url.Parse(location)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	query := target.Query()
+	if !strings.HasPrefix(location, m.server.URL+"/authorize?") {
+		m.t.Fatalf("redirected to %s, want the authorization endpoint", location)
+	}
+	if query.Get("client_id") != mockClientID || query.Get("response_type") != "code" {
+		m.t.Fatalf("unexpected authorization request %v", query)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		m.t.Fatalf("authorization request without PKCE: %v", query)
+	}
+	if query.Get("nonce") == "" || query.Get("state") == "" {
+		m.t.Fatalf("authorization request without nonce or state: %v", query)
+	}
+
+	code := "code-" + query.Get("state")[:8]
+	m.mu.Lock()
+	m.grants[code] = mockGrant{query.Get("code_challenge"), query.Get("nonce"), claims}
+	m.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != mockClientID || secret != mockClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.grants[r.PostForm.Get("code")]
+	delete(m.grants, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   m.server.URL,
+		"aud":   mockClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     m.sign(claims),
+	})
+}
+
+// sign - Encodes the claims as an RS256 JWT.
+func (m *mockIssuer) sign(claims map[string]interface{}) string {
+	m.t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": mockKeyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// newSSOServer - A testServer with the issuer configured as provider "mock".
+func newSSOServer(t *testing.T, issuer *mockIssuer) *testServer {
+	cfg := testConfig()
+	cfg.OIDC.Providers = []config.OIDCProvider{{
+		Name:         "mock",
+		DisplayName:  "Mock",
+		Issuer:       issuer.server.URL,
+		ClientID:     mockClientID,
+		ClientSecret: mockClientSecret,
+		Scopes:       []string{"email", "profile"},
+		RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+	}}
+	return newTestServer(t, cfg)
+}
+
+// signIn - Runs the browser's side of a sign-in and returns the query the
+// frontend's completion page receives. tamper may alter the state cookie
+// before the callback.
+func signIn(t *testing.T, s *testServer, issuer *mockIssuer, claims map[string]interface{}, tamper func(*ssoState)) url.Values {
+	t.Helper()
+
+	w := s.do(http.MethodGet, "/auth/oidc/mock/login", nil, "")
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body)
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == ssoCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.Path != ssoCookiePath {
+		t.Fatalf("state cookie = %+v", cookie)
+	}
+
+	callback := issuer.authorize(w.Header().Get("Location"), claims)
+
+	if tamper != nil {
+		data, _ := base64.RawURLEncoding.DecodeString(cookie.Value)
+		var state ssoState
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatal(err)
+		}
+		tamper(&state)
+		data, _ = json.Marshal(state)
+		cookie.Value = base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	w = s.do(http.MethodGet, "/auth/oidc/mock/callback?"+callback.Encode(), nil, "", cookie)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	target, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(target.String(), s.cfg.OIDC.CompleteURL+"?") {
+		t.Fatalf("callback redirected to %s", target)
+	}
+	return target.Query()
+}
+
+func TestSSOCreatesAccount(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newSSOServer(t, issuer)
+
+	result := signIn(t, s, issuer, map[string]interface{}{
+		"sub":                "subject-1",
+		"email":              "ann@example.com",
+		"email_verified":     true,
+		"preferred_username": "ann",
+	}, nil)
+	if result.Get("code") == "" {
+		t.Fatalf("sign-in failed: %v", result)
+	}
+
+	body := decode(t, s.do(http.MethodPost, "/auth/oidc/exchange", gin.H{"code": result.Get("code")}, ""), http.StatusOK)
+	token, _ := body["token"].(string)
+	if token == "" || body["refresh_token"] == "" {
+		t.Fatalf("exchange returned no tokens: %v", body)
+	}
+	me := decode(t, s.do(http.MethodGet, "/me", nil, token), http.StatusOK)
+	if me["email"] != "ann@example.com" {
+		t.Fatalf("/me = %v", me)
+	}
+
+	user, err := s.stores.Users.FindByIdentity(context.Background(), "mock", "subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserName != "ann" || !user.EmailVerified {
+		t.Errorf("created user = %+v", user)
+	}
+
+	// The code is single use
+	w := s.do(http.MethodPost, "/auth/oidc/exchange", gin.H{"code": result.Get("code")}, "")
+	if w.Code == http.StatusOK {
+		t.Error("sign-in code was accepted twice")
+	}
+
+	// Signing in again finds the same account
+	result = signIn(t, s, issuer, map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "ann@example.com",
+		"email_verified": true,
+	}, nil)
+	if result.Get("code") == "" {
+		t.Fatalf("second sign-in failed: %v", result)
+	}
+}
+
+func TestSSOLinksVerifiedEmail(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newSSOServer(t, issuer)
+
+	existing := interfaces.User{UserName: "bob", Email: "bob@example.com", EmailVerified: true}
+	if err := s.stores.Users.Create(context.Background(), &existing); err != nil {
+		t.Fatal(err)
+	}
+
+	result := signIn(t, s, issuer, map[string]interface{}{
+		"sub":            "subject-2",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	}, nil)
+	if result.Get("code") == "" {
+		t.Fatalf("sign-in failed: %v", result)
+	}
+
+	user, err := s.stores.Users.FindByIdentity(context.Background(), "mock", "subject-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != existing.ID || !user.EmailVerified {
+		t.Errorf("identity linked to %+v, want %s with a verified email", user, existing.ID)
+	}
+}
+
+func TestSSODoesNotLinkUnverifiedAccount(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newSSOServer(t, issuer)
+
+	// Someone signs up with the address before its owner ever does
+	s.signUp("squatter", "victim@example.com")
+
+	result := signIn(t, s, issuer, map[string]interface{}{
+		"sub":            "victim",
+		"email":          "victim@example.com",
+		"email_verified": true,
+	}, nil)
+	if got := result.Get("error"); got != apierror.CodeEmailTaken {
+		t.Errorf("error = %q, want %q", got, apierror.CodeEmailTaken)
+	}
+
+	user, err := s.stores.Users.FindByEmail(context.Background(), "victim@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Identities) != 0 || user.EmailVerified {
+		t.Errorf("unverified account was linked: %+v", user)
+	}
+}
+
+func TestSSORefusesUnverifiedEmail(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newSSOServer(t, issuer)
+
+	existing := interfaces.User{UserName: "carol", Email: "carol@example.com"}
+	if err := s.stores.Users.Create(context.Background(), &existing); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"carol@example.com", "dave@example.com"} {
+		result := signIn(t, s, issuer, map[string]interface{}{
+			"sub":            "subject-" + email,
+			"email":          email,
+			"email_verified": false,
+		}, nil)
+		if got := result.Get("error"); got != apierror.CodeSSOEmailUnverified {
+			t.Errorf("%s: error = %q, want %q", email, got, apierror.CodeSSOEmailUnverified)
+		}
+		if _, err := s.stores.Users.FindByIdentity(context.Background(), "mock", "subject-"+email); err == nil {
+			t.Errorf("%s: identity was stored", email)
+		}
+	}
+	if _, err := s.stores.Users.FindByEmail(context.Background(), "dave@example.com"); err == nil {
+		t.Error("account created for an unverified email")
+	}
+}
+
+func TestSSOMissingEmail(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newSSOServer(t, issuer)
+
+	result := signIn(t, s, issuer, map[string]interface{}{"sub": "subject-3"}, nil)
+	if got := result.Get("error"); got != apierror.CodeSSONoEmail {
+		t.Errorf("error = %q, want %q", got, apierror.CodeSSONoEmail)
+	}
+}
+
+func TestSSORejectsTamperedLogin(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":            "subject-4",
+		"email":          "erin@example.com",
+		"email_verified": true,
+	}
+
+	for name, tamper := range map[string]func(*ssoState){
+		"pkce verifier": func(state *ssoState) { state.Verifier += "x" },
+		"nonce":         func(state *ssoState) { state.Nonce += "x" },
+		"state":         func(state *ssoState) { state.State += "x" },
+		"provider":      func(state *ssoState) { state.Provider = "other" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			s := newSSOServer(t, issuer)
+
+			result := signIn(t, s, issuer, claims, tamper)
+			if got := result.Get("error"); got != apierror.CodeSSOFailed {
+				t.Errorf("error = %q, want %q", got, apierror.CodeSSOFailed)
+			}
+			if _, err := s.stores.Users.FindByEmail(context.Background(), "erin@example.com"); err == nil {
+				t.Error("account created by a failed sign-in")
+			}
+		})
+	}
+}
+
+func TestSSOProviderDenied(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newSSOServer(t, issuer)
+
+	w := s.do(http.MethodGet, "/auth/oidc/mock/login", nil, "")
+	location, _ := url.Parse(w.Header().Get("Location"))
+	query := url.Values{"error": {"access_denied"}, "state": {location.Query().Get("state")}}
+
+	w = s.do(http.MethodGet, "/auth/oidc/mock/callback?"+query.Encode(), nil, "", w.Result().Cookies()...)
+	target, _ := url.Parse(w.Header().Get("Location"))
+	if got := target.Query().Get("error"); got != apierror.CodeSSODenied {
+		t.Errorf("error = %q, want %q", got, apierror.CodeSSODenied)
+	}
+}
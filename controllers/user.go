@@ -1,110 +1,356 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
-	"webrtc/handlers"
+	"webrtc/apierror"
 	"webrtc/interfaces"
-	"webrtc/utils"
+	"webrtc/logging"
+	"webrtc/middleware"
+	"webrtc/repository"
 
 	"github.com/gin-gonic/gin"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func CreateUser(ctx *gin.Context){
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("users")
-
+func (ctl *Controller) CreateUser(ctx *gin.Context) {
 	var user interfaces.User
 
-	if err := ctx.ShouldBindJSON(&user); err != nil{
-		ctx.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	hash, ok := ctl.hashPassword(ctx, user.Password)
+	if !ok {
+		return
+	}
+	user.Password = hash
+
+	if err := ctl.users.Create(ctx, &user); err != nil {
+		var dup *repository.DuplicateError
+		if errors.As(err, &dup) {
+			duplicateUser(ctx, dup.Field)
+			return
+		}
+		logging.FromContext(ctx).Error("insert user", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	ctl.mailInBackground(ctx, func(bg context.Context) error {
+		return ctl.sendVerification(bg, user)
+	})
+
+	tokens, err := ctl.issueTokens(ctx, user.ID, "")
+	if err != nil {
+		logging.FromContext(ctx).Error("issue tokens", "user_id", user.ID, "err", err)
+		apierror.Internal(ctx)
 		return
 	}
 
-	user.Password = utils.HashPassword(user.Password)
+	tokens["status"] = "success"
+	tokens["user"] = userResponse(user)
+
+	ctx.JSON(http.StatusOK, tokens)
+
+}
 
-	result,err := collection.InsertOne(ctx, user)
-	if err!= nil{
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error":err.Error()})
+func (ctl *Controller) Login(ctx *gin.Context) {
+	var login interfaces.Login
+
+	if err := ctx.ShouldBindJSON(&login); err != nil {
+		apierror.Bind(ctx, err)
 		return
 	}
-	token, err := handlers.GenerateToken(result.InsertedID.(primitive.ObjectID).Hex()) // Generate token from ObjectID
+
+	keys := ctl.loginKeys(ctx, login.Email)
+	if ctl.throttled(ctx, keys) {
+		return
+	}
+
+	// Unknown emails and wrong passwords get the same answer, after the
+	// same amount of hashing work, so the endpoint can't be used to probe
+	// for accounts.
+	user, err := ctl.users.FindByEmail(ctx, login.Email)
 	if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if !errors.Is(err, repository.ErrNotFound) {
+			logging.FromContext(ctx).Error("find user", "err", err)
+			apierror.Internal(ctx)
 			return
+		}
+		ctl.passwords.VerifyDummy(login.Password)
+		logging.FromContext(ctx).Info("login for unknown user")
+		ctl.attemptFailed(ctx, keys)
+		apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid email or password.")
+		return
 	}
 
-	responseUser := gin.H{
-		"username": user.UserName,
-		"email":   user.Email,
+	if !ctl.passwordMatches(ctx, user.Password, login.Password) {
+		logging.FromContext(ctx).Info("login with invalid password", "user_id", user.ID)
+		ctl.attemptFailed(ctx, keys)
+		apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid email or password.")
+		return
+	}
+	// Only the account is cleared: one good password must not reset the
+	// IP's failures against other accounts.
+	ctl.attemptSucceeded(ctx, keys[1])
+	ctl.upgradeUserHash(ctx, user, login.Password)
+
+	tokens, err := ctl.issueTokens(ctx, user.ID, "")
+	if err != nil {
+		logging.FromContext(ctx).Error("issue tokens", "user_id", user.ID, "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	tokens["status"] = "success"
+	tokens["user"] = userResponse(user)
+
+	ctx.JSON(http.StatusOK, tokens)
 }
 
+type profilePatch struct {
+	UserName *string `json:"username" binding:"omitempty,notblank,min=3,max=32"`
+	Email    *string `json:"email" binding:"omitempty,email,max=254"`
+	// CurrentPassword is required to change the email.
+	CurrentPassword string `json:"current_password" binding:"max=72"`
+}
 
+type passwordChangeInput struct {
+	CurrentPassword string `json:"current_password" binding:"required,max=72"`
+	NewPassword     string `json:"new_password" binding:"required,password"`
+}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": "success", "token": token, "user":responseUser})
+type accountDeleteInput struct {
+	Password string `json:"password" binding:"required,max=72"`
+}
 
+// GetMe - Returns the authenticated user's profile.
+func (ctl *Controller) GetMe(ctx *gin.Context) {
+	user, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
+	}
 
+	ctx.JSON(http.StatusOK, userResponse(user))
 }
 
-func Login(ctx *gin.Context){
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("users")
+// UpdateMe - Changes the authenticated user's username or email. A new
+// email has to be verified again.
+func (ctl *Controller) UpdateMe(ctx *gin.Context) {
+	var patch profilePatch
+	if err := ctx.ShouldBindJSON(&patch); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
 
-	var login interfaces.Login
+	user, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
+	}
 
-	if err := ctx.ShouldBindJSON(&login); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+	if patch.UserName != nil {
+		user.UserName = *patch.UserName
 	}
 
-	filter := bson.M{"email": login.Email}
+	emailChanged := patch.Email != nil && repository.NormalizeEmail(*patch.Email) != user.Email
+	if emailChanged {
+		if !ctl.passwordMatches(ctx, user.Password, patch.CurrentPassword) {
+			apierror.Write(ctx, http.StatusForbidden, apierror.CodeIncorrectPassword, "Current password is incorrect.")
+			return
+		}
+		user.Email = repository.NormalizeEmail(*patch.Email)
+		user.EmailVerified = false
+	}
 
-	// Find the user and get the ObjectID directly
-	var result bson.M
-	if err := collection.FindOne(ctx, filter).Decode(&result); err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "user tidak ditemukan"})
+	if err := ctl.users.Update(ctx, user); err != nil {
+		var dup *repository.DuplicateError
+		if errors.As(err, &dup) {
+			duplicateUser(ctx, dup.Field)
 			return
+		}
+		logging.FromContext(ctx).Error("update user", "err", err)
+		apierror.Internal(ctx)
+		return
 	}
 
-	// Extract the ObjectID from the result
-	userID := result["_id"].(primitive.ObjectID).Hex() 
+	if emailChanged {
+		ctl.mailInBackground(ctx, func(bg context.Context) error {
+			return ctl.sendVerification(bg, user)
+		})
+	}
 
-	if !utils.ComparePasswords(result["password"].(string), []byte(login.Password)) {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password."})
-			return
+	ctx.JSON(http.StatusOK, userResponse(user))
+}
+
+// ChangePassword - Sets a new password after checking the current one.
+// Every other login is signed out; the caller gets fresh tokens.
+func (ctl *Controller) ChangePassword(ctx *gin.Context) {
+	var input passwordChangeInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	user, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	if !ctl.passwordMatches(ctx, user.Password, input.CurrentPassword) {
+		apierror.Write(ctx, http.StatusForbidden, apierror.CodeIncorrectPassword, "Current password is incorrect.")
+		return
+	}
+
+	hash, ok := ctl.hashPassword(ctx, input.NewPassword)
+	if !ok {
+		return
+	}
+
+	log := logging.FromContext(ctx)
+	user.Password = hash
+	if err := ctl.users.Update(ctx, user); err != nil {
+		log.Error("update user", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
+
+	if err := ctl.revokeUser(ctx, user.ID); err != nil {
+		log.Error("revoke tokens", "err", err)
+	}
+	if err := ctl.actionTokens.DeleteUser(ctx, user.ID, interfaces.PurposeResetPassword); err != nil {
+		log.Error("delete reset tokens", "err", err)
 	}
 
-	token, err := handlers.GenerateToken(userID) // Generate token from ObjectID
+	tokens, err := ctl.issueTokens(ctx, user.ID, "")
 	if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		log.Error("issue tokens", "err", err)
+		apierror.Internal(ctx)
+		return
 	}
-	responseUser := gin.H{
-		"username": result["username"],
-		"email":    result["email"],
+
+	tokens["status"] = "success"
+	ctx.JSON(http.StatusOK, tokens)
 }
 
+// DeleteMe - Deletes the authenticated user's account after confirming the
+// password. Their sessions are deleted and their live rooms closed, and
+// every token is revoked.
+func (ctl *Controller) DeleteMe(ctx *gin.Context) {
+	var input accountDeleteInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		apierror.Bind(ctx, err)
+		return
+	}
+
+	user, ok := ctl.currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	if !ctl.passwordMatches(ctx, user.Password, input.Password) {
+		apierror.Write(ctx, http.StatusForbidden, apierror.CodeIncorrectPassword, "Password is incorrect.")
+		return
+	}
 
+	log := logging.FromContext(ctx)
+	if err := ctl.deleteAccount(ctx, user); err != nil {
+		log.Error("delete account", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": "success", "token": token, "user":responseUser})
+	log.Info("account deleted")
+	ctx.Status(http.StatusNoContent)
 }
 
+// GetUser - Looks up another user's public profile.
+func (ctl *Controller) GetUser(ctx *gin.Context) {
+	user, err := ctl.users.FindByID(ctx, ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Write(ctx, http.StatusNotFound, apierror.CodeNotFound, "User not found.")
+			return
+		}
+		logging.FromContext(ctx).Error("find user", "err", err)
+		apierror.Internal(ctx)
+		return
+	}
 
-func GetUserByID(ctx *gin.Context,  userID string) (interfaces.User, error) {
-	db := ctx.MustGet("db").(*mongo.Client)
-	collection := db.Database("MeetKobi").Collection("users")
-	var user interfaces.User
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":       user.ID,
+		"username": user.UserName,
+	})
+}
+
+// deleteAccount - Removes the user and everything they own. Sessions go
+// first so a failure part-way leaves an account the user can retry
+// deleting.
+func (ctl *Controller) deleteAccount(ctx *gin.Context, user interfaces.User) error {
+	sessions, err := ctl.sessions.FindByHost(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := ctl.deleteSession(ctx, session); err != nil {
+			return err
+		}
+	}
 
-	filter := bson.M{"_id": userID}
+	if err := ctl.revokeUser(ctx, user.ID); err != nil {
+		return err
+	}
+	for _, purpose := range []string{interfaces.PurposeVerifyEmail, interfaces.PurposeResetPassword, interfaces.PurposeSSOLogin} {
+		if err := ctl.actionTokens.DeleteUser(ctx, user.ID, purpose); err != nil {
+			return err
+		}
+	}
+
+	if err := ctl.users.Delete(ctx, user.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	return nil
+}
 
-	err := collection.FindOne(ctx, filter).Decode(&user)
+// currentUser - Loads the authenticated user. An account deleted since the
+// access token was issued is reported as unauthorized. On failure the
+// response has been written.
+func (ctl *Controller) currentUser(ctx *gin.Context) (interfaces.User, bool) {
+	user, err := ctl.users.FindByID(ctx, middleware.UserID(ctx))
 	if err != nil {
-		return user, err
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Write(ctx, http.StatusUnauthorized, apierror.CodeAccountDeleted, "Account no longer exists.")
+			return user, false
+		}
+		logging.FromContext(ctx).Error("find user", "err", err)
+		apierror.Internal(ctx)
+		return user, false
 	}
+	return user, true
+}
+
+// userResponse - Renders a user for themselves. Never includes the password
+// hash.
+func userResponse(user interfaces.User) gin.H {
+	return gin.H{
+		"id":             user.ID,
+		"username":       user.UserName,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+}
 
-	return user, nil
-}
\ No newline at end of file
+// duplicateUser - Responds to a user insert or update that collided on a
+// unique field.
+func duplicateUser(ctx *gin.Context, field string) {
+	switch field {
+	case "email":
+		apierror.Write(ctx, http.StatusConflict, apierror.CodeEmailTaken, "Email is already registered.")
+	case "username":
+		apierror.Write(ctx, http.StatusConflict, apierror.CodeUsernameTaken, "Username is already taken.")
+	default:
+		apierror.Write(ctx, http.StatusConflict, apierror.CodeAlreadyExists, "User already exists.")
+	}
+}
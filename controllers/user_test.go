@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"webrtc/apierror"
+	"webrtc/handlers"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateUser(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	body := decode(t, s.do(http.MethodPost, "/createuser", gin.H{
+		"username": "ann",
+		"email":    "ann@example.com",
+		"password": "secret123",
+	}, ""), http.StatusOK)
+	if body["token"] == "" || body["refresh_token"] == "" {
+		t.Fatalf("signup returned no tokens: %v", body)
+	}
+	user, _ := body["user"].(map[string]interface{})
+	if user["username"] != "ann" || user["email"] != "ann@example.com" || user["email_verified"] != false {
+		t.Errorf("user = %v", user)
+	}
+	if _, ok := user["password"]; ok {
+		t.Error("response includes the password")
+	}
+
+	messages := s.waitForMail(1)
+	if len(messages[0].To) != 1 || messages[0].To[0] != "ann@example.com" || !strings.Contains(messages[0].Text, "http://localhost/verify-email?token=") {
+		t.Errorf("verification mail = %+v", messages[0])
+	}
+
+	me := decode(t, s.do(http.MethodGet, "/me", nil, body["token"].(string)), http.StatusOK)
+	if me["username"] != "ann" {
+		t.Errorf("/me = %v", me)
+	}
+}
+
+func TestCreateUserRejects(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.signUp("ann", "ann@example.com")
+
+	for _, tc := range []struct {
+		name   string
+		body   gin.H
+		status int
+		code   string
+	}{
+		{"email taken", gin.H{"username": "other", "email": "ann@example.com", "password": "secret123"}, http.StatusConflict, apierror.CodeEmailTaken},
+		{"username taken", gin.H{"username": "ann", "email": "other@example.com", "password": "secret123"}, http.StatusConflict, apierror.CodeUsernameTaken},
+		{"weak password", gin.H{"username": "bob", "email": "bob@example.com", "password": "password"}, http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"invalid email", gin.H{"username": "bob", "email": "bob", "password": "secret123"}, http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"blank username", gin.H{"username": "   ", "email": "bob@example.com", "password": "secret123"}, http.StatusBadRequest, apierror.CodeValidationFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := decode(t, s.do(http.MethodPost, "/createuser", tc.body, ""), tc.status)
+			if got := errorCode(body); got != tc.code {
+				t.Errorf("code = %q, want %q", got, tc.code)
+			}
+		})
+	}
+}
+
+func TestEmailsIgnoreCase(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "Ann@Example.com")
+
+	me := decode(t, s.do(http.MethodGet, "/me", nil, token), http.StatusOK)
+	if me["email"] != "ann@example.com" {
+		t.Errorf("stored email = %v, want it lower-cased", me["email"])
+	}
+
+	body := decode(t, s.do(http.MethodPost, "/createuser", gin.H{"username": "other", "email": "ANN@example.COM", "password": "secret123"}, ""), http.StatusConflict)
+	if got := errorCode(body); got != apierror.CodeEmailTaken {
+		t.Errorf("code = %q, want %q", got, apierror.CodeEmailTaken)
+	}
+	decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "aNN@example.com", "password": "secret123"}, ""), http.StatusOK)
+	if _, err := s.stores.Users.FindByEmail(context.Background(), "ANN@EXAMPLE.COM"); err != nil {
+		t.Errorf("FindByEmail with other case: %v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.signUp("ann", "ann@example.com")
+
+	body := decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusOK)
+	if user, _ := body["user"].(map[string]interface{}); user["username"] != "ann" {
+		t.Errorf("user = %v", body["user"])
+	}
+
+	// Unknown accounts and wrong passwords look the same
+	for _, login := range []gin.H{
+		{"email": "ann@example.com", "password": "wrong1234"},
+		{"email": "nobody@example.com", "password": "secret123"},
+	} {
+		body := decode(t, s.do(http.MethodPost, "/login", login, ""), http.StatusUnauthorized)
+		if got := errorCode(body); got != apierror.CodeInvalidCredentials {
+			t.Errorf("%v: code = %q, want %q", login, got, apierror.CodeInvalidCredentials)
+		}
+	}
+}
+
+func TestLoginLocksOutAccount(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.signUp("ann", "ann@example.com")
+
+	w := s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "wrong1234"}, "")
+	for attempt := 1; w.Code == http.StatusUnauthorized; attempt++ {
+		if attempt > s.cfg.RateLimit.Account.FreeAttempts+1 {
+			t.Fatalf("not locked out after %d failures", attempt)
+		}
+		w = s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "wrong1234"}, "")
+	}
+	body := decode(t, w, http.StatusTooManyRequests)
+	if got := errorCode(body); got != apierror.CodeTooManyAttempts {
+		t.Errorf("code = %q, want %q", got, apierror.CodeTooManyAttempts)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	// Even the right password waits out the lock
+	w = s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d during lockout, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.signUp("ann", "ann@example.com")
+
+	login := decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusOK)
+	first := login["refresh_token"].(string)
+
+	refreshed := decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": first}, ""), http.StatusOK)
+	second, _ := refreshed["refresh_token"].(string)
+	if second == "" || second == first {
+		t.Fatalf("refresh returned %v", refreshed)
+	}
+
+	decode(t, s.do(http.MethodGet, "/me", nil, refreshed["token"].(string)), http.StatusOK)
+
+	// Reusing the old token revokes the whole login, access tokens included
+	decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": first}, ""), http.StatusUnauthorized)
+	decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": second}, ""), http.StatusUnauthorized)
+	for _, token := range []string{login["token"].(string), refreshed["token"].(string)} {
+		body := decode(t, s.do(http.MethodGet, "/me", nil, token), http.StatusUnauthorized)
+		if got := errorCode(body); got != apierror.CodeInvalidToken {
+			t.Errorf("code = %q, want %q", got, apierror.CodeInvalidToken)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	s := newTestServer(t, nil)
+	other := s.signUp("ann", "ann@example.com")
+
+	login := decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusOK)
+	refresh := login["refresh_token"].(string)
+
+	for i := 0; i < 2; i++ {
+		if w := s.do(http.MethodPost, "/logout", gin.H{"refresh_token": refresh}, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("logout %d status = %d: %s", i, w.Code, w.Body)
+		}
+	}
+	decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": refresh}, ""), http.StatusUnauthorized)
+	decode(t, s.do(http.MethodGet, "/me", nil, login["token"].(string)), http.StatusUnauthorized)
+
+	// Other logins stay signed in
+	decode(t, s.do(http.MethodGet, "/me", nil, other), http.StatusOK)
+}
+
+func TestRequireAuthRejects(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.signUp("ann", "ann@example.com")
+	user, err := s.stores.Users.FindByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiredCfg := s.cfg.Auth
+	expiredCfg.AccessTokenTTL.Duration = -time.Minute
+	expired, _, err := handlers.NewAuth(expiredCfg).GenerateToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(secret string, claims handlers.Claims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	later := time.Now().Add(time.Hour).Unix()
+	join, err := s.ctl.auth.GenerateJoinToken("abc-defg-hij", handlers.Participant{ID: "p1", UserID: user.ID, Role: handlers.RoleHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{
+		"expired":        expired,
+		"wrong audience": sign(s.cfg.Auth.JWTSecret, handlers.Claims{UserID: user.ID, StandardClaims: jwt.StandardClaims{Id: "x", Audience: "billing", ExpiresAt: later}}),
+		"no token id":    sign(s.cfg.Auth.JWTSecret, handlers.Claims{UserID: user.ID, StandardClaims: jwt.StandardClaims{ExpiresAt: later}}),
+		"wrong key":      sign("other-secret", handlers.Claims{UserID: user.ID, StandardClaims: jwt.StandardClaims{Id: "x", ExpiresAt: later}}),
+		"join token":     join,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := s.do(http.MethodGet, "/me", nil, token)
+			body := decode(t, w, http.StatusUnauthorized)
+			if got := errorCode(body); got != apierror.CodeInvalidToken {
+				t.Errorf("code = %q, want %q", got, apierror.CodeInvalidToken)
+			}
+			if !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+				t.Errorf("WWW-Authenticate = %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	body := decode(t, s.do(http.MethodGet, "/me", nil, ""), http.StatusUnauthorized)
+	if got := errorCode(body); got != apierror.CodeUnauthorized {
+		t.Errorf("no token: code = %q, want %q", got, apierror.CodeUnauthorized)
+	}
+}
+
+func TestUpdateMe(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	s.signUp("bob", "bob@example.com")
+	s.waitForMail(2)
+
+	body := decode(t, s.do(http.MethodPatch, "/me", gin.H{"username": "annie"}, token), http.StatusOK)
+	if body["username"] != "annie" || body["email"] != "ann@example.com" {
+		t.Errorf("profile = %v, want username annie and the email kept", body)
+	}
+
+	body = decode(t, s.do(http.MethodPatch, "/me", gin.H{"username": "bob"}, token), http.StatusConflict)
+	if got := errorCode(body); got != apierror.CodeUsernameTaken {
+		t.Errorf("code = %q, want %q", got, apierror.CodeUsernameTaken)
+	}
+
+	// A new email needs the password and has to be verified again
+	for _, password := range []string{"", "wrong-password"} {
+		body = decode(t, s.do(http.MethodPatch, "/me", gin.H{"email": "ann@new.example", "current_password": password}, token), http.StatusForbidden)
+		if got := errorCode(body); got != apierror.CodeIncorrectPassword {
+			t.Errorf("code = %q, want %q", got, apierror.CodeIncorrectPassword)
+		}
+	}
+	body = decode(t, s.do(http.MethodPatch, "/me", gin.H{"email": "Ann@New.Example", "current_password": "secret123"}, token), http.StatusOK)
+	if body["email"] != "ann@new.example" || body["email_verified"] != false {
+		t.Errorf("profile = %v, want the new email unverified", body)
+	}
+	messages := s.waitForMail(3)
+	if to := messages[2].To; len(to) != 1 || to[0] != "ann@new.example" {
+		t.Errorf("verification sent to %v, want the new email", to)
+	}
+
+	body = decode(t, s.do(http.MethodPatch, "/me", gin.H{"email": "bob@example.com", "current_password": "secret123"}, token), http.StatusConflict)
+	if got := errorCode(body); got != apierror.CodeEmailTaken {
+		t.Errorf("code = %q, want %q", got, apierror.CodeEmailTaken)
+	}
+
+	decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@new.example", "password": "secret123"}, ""), http.StatusOK)
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	other := decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusOK)
+
+	body := decode(t, s.do(http.MethodPost, "/me/password", gin.H{"current_password": "wrong-password", "new_password": "newsecret456"}, token), http.StatusForbidden)
+	if got := errorCode(body); got != apierror.CodeIncorrectPassword {
+		t.Errorf("code = %q, want %q", got, apierror.CodeIncorrectPassword)
+	}
+
+	changed := decode(t, s.do(http.MethodPost, "/me/password", gin.H{"current_password": "secret123", "new_password": "newsecret456"}, token), http.StatusOK)
+	fresh, _ := changed["token"].(string)
+	if fresh == "" || changed["refresh_token"] == nil {
+		t.Fatalf("password change returned %v, want new tokens", changed)
+	}
+
+	// Every earlier login is signed out, the caller's own included
+	for _, old := range []string{token, other["token"].(string)} {
+		decode(t, s.do(http.MethodGet, "/me", nil, old), http.StatusUnauthorized)
+	}
+	decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": other["refresh_token"]}, ""), http.StatusUnauthorized)
+	decode(t, s.do(http.MethodGet, "/me", nil, fresh), http.StatusOK)
+	decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": changed["refresh_token"]}, ""), http.StatusOK)
+
+	decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusUnauthorized)
+	decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "newsecret456"}, ""), http.StatusOK)
+}
+
+func TestDeleteMe(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	login := decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusOK)
+	session, _ := s.createSession(token, nil)
+
+	body := decode(t, s.do(http.MethodDelete, "/me", gin.H{"password": "wrong-password"}, token), http.StatusForbidden)
+	if got := errorCode(body); got != apierror.CodeIncorrectPassword {
+		t.Errorf("code = %q, want %q", got, apierror.CodeIncorrectPassword)
+	}
+	decode(t, s.do(http.MethodGet, "/me", nil, token), http.StatusOK)
+
+	if w := s.do(http.MethodDelete, "/me", gin.H{"password": "secret123"}, token); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := s.stores.Users.FindByEmail(context.Background(), "ann@example.com"); err == nil {
+		t.Error("user still stored")
+	}
+	if _, err := s.stores.Sessions.FindByID(context.Background(), session.ID); err == nil {
+		t.Error("session still stored")
+	}
+	for _, old := range []string{token, login["token"].(string)} {
+		decode(t, s.do(http.MethodGet, "/me", nil, old), http.StatusUnauthorized)
+	}
+	decode(t, s.do(http.MethodPost, "/refresh", gin.H{"refresh_token": login["refresh_token"]}, ""), http.StatusUnauthorized)
+	decode(t, s.do(http.MethodPost, "/login", gin.H{"email": "ann@example.com", "password": "secret123"}, ""), http.StatusUnauthorized)
+
+	// The address can sign up again
+	s.signUp("ann", "ann@example.com")
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"webrtc/apierror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var registerValidationsOnce sync.Once
+
+// registerValidations - Adds the custom binding rules used by the request
+// types and reports fields by their JSON or query name.
+func registerValidations() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	// Both only fail on programming errors: an empty tag or a nil function.
+	_ = v.RegisterValidation("password", validatePassword)
+	_ = v.RegisterValidation("notblank", validateNotBlank)
+}
+
+// invalidField - Responds with a validation failure for one field that
+// passed the binding rules but failed a check in the handler.
+func invalidField(ctx *gin.Context, field, rule, message string) {
+	apierror.Invalid(ctx, apierror.FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// validatePassword - 8 to 72 bytes (bcrypt ignores anything longer) with at
+// least one letter and one digit.
+func validatePassword(fl validator.FieldLevel) bool {
+	password := fl.Field().String()
+	if len(password) < 8 || len(password) > 72 {
+		return false
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	return letter && digit
+}
+
+// validateNotBlank - Rejects strings made only of whitespace.
+func validateNotBlank(fl validator.FieldLevel) bool {
+	return strings.TrimSpace(fl.Field().String()) != ""
+}
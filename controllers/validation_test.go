@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"webrtc/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldErrors - The details of a validation_failed envelope.
+func fieldErrors(t *testing.T, body map[string]interface{}) []apierror.FieldError {
+	t.Helper()
+
+	if got := errorCode(body); got != apierror.CodeValidationFailed {
+		t.Fatalf("code = %q, want %q", got, apierror.CodeValidationFailed)
+	}
+	details, _ := body["error"].(map[string]interface{})["details"].([]interface{})
+	fields := make([]apierror.FieldError, 0, len(details))
+	for _, detail := range details {
+		d, _ := detail.(map[string]interface{})
+		field, _ := d["field"].(string)
+		rule, _ := d["rule"].(string)
+		message, _ := d["message"].(string)
+		fields = append(fields, apierror.FieldError{Field: field, Rule: rule, Message: message})
+	}
+	return fields
+}
+
+func TestValidationDetails(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+	session, _ := s.createSession(token, nil)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   []apierror.FieldError
+	}{
+		{
+			name:   "every invalid field",
+			method: http.MethodPost,
+			path:   "/createuser",
+			body:   gin.H{"username": "al", "email": "al", "password": "letters"},
+			want: []apierror.FieldError{
+				{Field: "username", Rule: "min", Message: "username must be at least 3 characters."},
+				{Field: "email", Rule: "email", Message: "email must be a valid email address."},
+				{Field: "password", Rule: "password", Message: "password must be 8 to 72 characters and contain a letter and a digit."},
+			},
+		},
+		{
+			name:   "missing fields",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   gin.H{},
+			want: []apierror.FieldError{
+				{Field: "email", Rule: "required", Message: "email is required."},
+				{Field: "password", Rule: "required", Message: "password is required."},
+			},
+		},
+		{
+			name:   "nested fields",
+			method: http.MethodPatch,
+			path:   "/sessions/" + session.ID,
+			body:   gin.H{"settings": gin.H{"max_participants": 5000, "mode": "party", "codecs": []string{"vp8", "mp3"}}},
+			want: []apierror.FieldError{
+				{Field: "settings.max_participants", Rule: "max", Message: "settings.max_participants must be at most 1000."},
+				{Field: "settings.mode", Rule: "oneof", Message: "settings.mode must be one of: meeting webinar."},
+				{Field: "settings.codecs[1]", Rule: "oneof", Message: "settings.codecs[1] must be one of: opus pcmu pcma g722 vp8 vp9 h264 av1."},
+			},
+		},
+		{
+			name:   "query parameters",
+			method: http.MethodGet,
+			path:   "/sessions?limit=500&sort=newest",
+			want: []apierror.FieldError{
+				{Field: "limit", Rule: "max", Message: "limit must be at most 100."},
+				{Field: "sort", Rule: "oneof", Message: "sort must be one of: created -created title -title."},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := decode(t, s.do(tc.method, tc.path, tc.body, token), http.StatusBadRequest)
+			if got := fieldErrors(t, body); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("details = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	s := newTestServer(t, nil)
+	token := s.signUp("ann", "ann@example.com")
+
+	malformed := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":`))
+	malformed.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, malformed)
+
+	for _, tc := range []struct {
+		name   string
+		w      *httptest.ResponseRecorder
+		status int
+		code   string
+	}{
+		{"malformed body", w, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"unknown route", s.do(http.MethodGet, "/nowhere", nil, ""), http.StatusNotFound, apierror.CodeRouteNotFound},
+		{"no credentials", s.do(http.MethodGet, "/me", nil, ""), http.StatusUnauthorized, apierror.CodeUnauthorized},
+		{"unknown session", s.do(http.MethodGet, "/sessions/000000000000000000000000", nil, token), http.StatusNotFound, apierror.CodeNotFound},
+		{"conflict", s.do(http.MethodPost, "/createuser", gin.H{"username": "ann", "email": "other@example.com", "password": "secret123"}, ""), http.StatusConflict, apierror.CodeUsernameTaken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if ct := tc.w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("content type = %q", ct)
+			}
+			body := decode(t, tc.w, tc.status)
+			if len(body) != 1 {
+				t.Errorf("body = %v, want only the error", body)
+			}
+			e, _ := body["error"].(map[string]interface{})
+			if e["code"] != tc.code {
+				t.Errorf("code = %v, want %q", e["code"], tc.code)
+			}
+			if message, _ := e["message"].(string); message == "" {
+				t.Error("no message")
+			}
+			if _, ok := e["details"]; ok {
+				t.Errorf("details = %v, want none", e["details"])
+			}
+		})
+	}
+}
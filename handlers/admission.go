@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"webrtc/apierror"
+)
+
+var (
+	// ErrRoomNotFound - No session uses the room.
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrRoomNotOpen - The room's session has not started yet.
+	ErrRoomNotOpen = errors.New("room is not open yet")
+	// ErrRoomEnded - The room's session is over.
+	ErrRoomEnded = errors.New("room has ended")
+	// ErrRoomFull - The room reached its participant limit.
+	ErrRoomFull = errors.New("room is full")
+)
+
+// Admission - What the SFU needs to know about a room when someone joins.
+type Admission struct {
+	ClosesAt        time.Time // zero means the room never closes on its own
+	MaxParticipants int       // 0 means unlimited
+	Webinar         bool      // a large audience of viewers watches a few presenters
+	LastN           int       // camera videos forwarded at once, 0 for all
+	Codecs          []string  // codec names publishers may use, most preferred first; empty for all
+}
+
+// AdmissionFunc - Decides whether a room may be joined right now. It returns
+// one of the ErrRoom errors to refuse the join.
+type AdmissionFunc func(ctx context.Context, roomId string) (Admission, error)
+
+// SetAdmission - Installs the check run before every join. Without one,
+// every join is accepted.
+func (s *SFU) SetAdmission(admit AdmissionFunc) {
+	s.admit = admit
+}
+
+// refuseJoin - Answers a refused join before the WebSocket upgrade.
+func refuseJoin(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrJoinToken):
+		apierror.WriteHTTP(w, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid, expired or already used join token.")
+	case errors.Is(err, ErrRoomNotFound):
+		apierror.WriteHTTP(w, http.StatusNotFound, apierror.CodeNotFound, "Room not found.")
+	case errors.Is(err, ErrRoomEnded):
+		apierror.WriteHTTP(w, http.StatusGone, apierror.CodeSessionEnded, "Session has ended.")
+	case errors.Is(err, ErrRoomNotOpen):
+		apierror.WriteHTTP(w, http.StatusForbidden, apierror.CodeSessionNotStarted, "Session has not started yet.")
+	case errors.Is(err, ErrRoomFull):
+		apierror.WriteHTTP(w, http.StatusForbidden, apierror.CodeSessionFull, "Session is full.")
+	default:
+		apierror.WriteHTTP(w, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error.")
+	}
+}
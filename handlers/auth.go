@@ -1,49 +1,177 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"os"
+	"time"
+	"webrtc/config"
+	"webrtc/utils"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
+// Claims - Claims carried by access tokens.
+type Claims struct {
+	UserID string `json:"user_id"`
+	jwt.StandardClaims
+}
+
+// JoinClaims - Claims carried by join tokens. The token ID is the ID of
+// the participant the token admits.
+type JoinClaims struct {
+	RoomID      string `json:"room_id"`
+	DisplayName string `json:"name"`
+	Role        string `json:"role"`
+	UserID      string `json:"user_id,omitempty"` // empty for guests
+	jwt.StandardClaims
+}
+
+// joinAudience - Sets join tokens apart from access tokens, so neither is
+// accepted in place of the other.
+const joinAudience = "room"
+
+// Auth - Issues and validates signed tokens.
+type Auth struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	joinTTL    time.Duration
+}
+
+// NewAuth - Creates an Auth from the token settings.
+func NewAuth(cfg config.Auth) *Auth {
+	return &Auth{
+		secret:     []byte(cfg.JWTSecret),
+		accessTTL:  cfg.AccessTokenTTL.Duration,
+		refreshTTL: cfg.RefreshTokenTTL.Duration,
+		joinTTL:    cfg.JoinTokenTTL.Duration,
+	}
+}
 
-var SECRET_KEY = []byte(os.Getenv("SECRET_KEY"))
+// AccessTTL - Lifetime of access tokens.
+func (a *Auth) AccessTTL() time.Duration {
+	return a.accessTTL
+}
 
+// RefreshTTL - Lifetime of refresh tokens.
+func (a *Auth) RefreshTTL() time.Duration {
+	return a.refreshTTL
+}
 
+// JoinTTL - Lifetime of join tokens.
+func (a *Auth) JoinTTL() time.Duration {
+	return a.joinTTL
+}
 
-func  GenerateToken(userID string) (string, error) {
+// GenerateToken - Issues a short lived access token for the given user.
+// Also returns the token's ID, under which it can be revoked.
+func (a *Auth) GenerateToken(userID string) (string, string, error) {
+	now := time.Now()
+	id := uuid.NewString()
+	claim := Claims{
+		UserID: userID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Subject:   userID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(a.accessTTL).Unix(),
+		},
+	}
 
-	claim := jwt.MapClaims{}
-	claim["user_id"] = userID
-	
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
 
-	signedToken, err := token.SignedString(SECRET_KEY)
+	signedToken, err := token.SignedString(a.secret)
 	if err != nil {
-		return signedToken, err
+		return signedToken, "", err
 	}
 
-	return signedToken, nil
+	return signedToken, id, nil
 
 }
 
-func ValidateToken(encodedToken string) (*jwt.Token, error) {
-	token, err := jwt.Parse(encodedToken, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+// ValidateToken - Parses an access token, checking its signature and expiry.
+func (a *Auth) ValidateToken(encodedToken string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(encodedToken, claims, a.keyFunc)
 
-		if !ok {
-			return nil, errors.New("invalid token")
-		}
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.UserID == "" || claims.Id == "" || claims.ExpiresAt == 0 || claims.Audience != "" {
+		return nil, errors.New("invalid token")
+	}
 
-		return []byte(SECRET_KEY), nil
-	})
+	return claims, nil
 
+}
+
+// GenerateJoinToken - Issues a short lived token admitting the participant
+// to a room.
+func (a *Auth) GenerateJoinToken(roomID string, p Participant) (string, error) {
+	now := time.Now()
+	claims := JoinClaims{
+		RoomID:      roomID,
+		DisplayName: p.DisplayName,
+		Role:        p.Role,
+		UserID:      p.UserID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        p.ID,
+			Audience:  joinAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(a.joinTTL).Unix(),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+}
+
+// ValidateJoinToken - Parses a join token, checking its signature, expiry
+// and that it was issued for roomID. It returns the participant and when
+// the token expires.
+func (a *Auth) ValidateJoinToken(encodedToken, roomID string) (Participant, time.Time, error) {
+	claims := &JoinClaims{}
+	token, err := jwt.ParseWithClaims(encodedToken, claims, a.keyFunc)
 	if err != nil {
-		return token, err
+		return Participant{}, time.Time{}, err
+	}
+	if !token.Valid || claims.Audience != joinAudience || claims.Id == "" || claims.ExpiresAt == 0 {
+		return Participant{}, time.Time{}, errors.New("invalid token")
+	}
+	if claims.RoomID != roomID {
+		return Participant{}, time.Time{}, errors.New("token is for another room")
 	}
 
-	return token, nil
+	return Participant{
+		ID:          claims.Id,
+		DisplayName: claims.DisplayName,
+		Role:        claims.Role,
+		UserID:      claims.UserID,
+		Guest:       claims.UserID == "",
+	}, time.Unix(claims.ExpiresAt, 0), nil
+}
 
+// keyFunc - Only accepts tokens signed with HMAC under the secret.
+func (a *Auth) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, errors.New("invalid token")
+	}
+	return a.secret, nil
 }
 
+// GenerateOpaqueToken - Returns a new opaque token, such as a refresh token
+// or an emailed link token, and the hash under which it should be stored.
+func GenerateOpaqueToken() (token, hash string, err error) {
+	token, err = utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// HashToken - Hashes an opaque token for storage and lookup.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"webrtc/config"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// codecMimeTypes - The MIME type of each codec name used in settings.
+var codecMimeTypes = map[string]string{
+	"opus": webrtc.MimeTypeOpus,
+	"pcmu": webrtc.MimeTypePCMU,
+	"pcma": webrtc.MimeTypePCMA,
+	"g722": webrtc.MimeTypeG722,
+	"vp8":  webrtc.MimeTypeVP8,
+	"vp9":  webrtc.MimeTypeVP9,
+	"h264": webrtc.MimeTypeH264,
+	"av1":  webrtc.MimeTypeAV1,
+}
+
+// mimeTypeRTX - Retransmissions of a video codec (RFC 4588), which pion
+// has no constant for.
+const mimeTypeRTX = "video/rtx"
+
+// mandatoryCodecs - Codecs every browser must be able to receive (RFC 7742
+// and RFC 7874), so tracks in them are sent before a peer's answers show
+// what it supports. Of H.264 only the Constrained Baseline profile is,
+// see mandatoryCodec.
+var mandatoryCodecs = []string{
+	webrtc.MimeTypeOpus, webrtc.MimeTypePCMU, webrtc.MimeTypePCMA,
+	webrtc.MimeTypeVP8, webrtc.MimeTypeH264,
+}
+
+// audioCodecs - The audio codecs the SFU can register, with the static
+// payload types browsers expect.
+var audioCodecs = map[string]webrtc.RTPCodecParameters{
+	"opus": {RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, PayloadType: 111},
+	"pcmu": {RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, PayloadType: 0},
+	"pcma": {RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, PayloadType: 8},
+	"g722": {RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeG722, ClockRate: 8000}, PayloadType: 9},
+}
+
+// videoRTCPFeedback - Feedback every video codec is offered with, as in
+// pion's defaults.
+var videoRTCPFeedback = []webrtc.RTCPFeedback{
+	{Type: "goog-remb"},
+	{Type: "ccm", Parameter: "fir"},
+	{Type: "nack"},
+	{Type: "nack", Parameter: "pli"},
+}
+
+// Function to register the configured codecs with the media engine, most
+// preferred first. Video codecs get dynamic payload types in that order,
+// each followed by its RTX codec. Returns the codecs registered.
+func registerCodecs(m *webrtc.MediaEngine, cfg config.Codecs) ([]webrtc.RTPCodecParameters, error) {
+	var registered []webrtc.RTPCodecParameters
+	register := func(codec webrtc.RTPCodecParameters, kind webrtc.RTPCodecType) error {
+		if err := m.RegisterCodec(codec, kind); err != nil {
+			return fmt.Errorf("register %s: %w", codec.MimeType, err)
+		}
+		registered = append(registered, codec)
+		return nil
+	}
+
+	opus := audioCodecs["opus"]
+	opusFmtp := []string{"minptime=10"}
+	if cfg.OpusFEC {
+		opusFmtp = append(opusFmtp, "useinbandfec=1")
+	}
+	if cfg.OpusDTX {
+		opusFmtp = append(opusFmtp, "usedtx=1")
+	}
+	opus.SDPFmtpLine = strings.Join(opusFmtp, ";")
+
+	for _, name := range cfg.Audio {
+		codec := audioCodecs[name]
+		if name == "opus" {
+			codec = opus
+		}
+		if err := register(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+
+	payloadType := webrtc.PayloadType(96)
+	nextPayloadType := func() webrtc.PayloadType {
+		next := payloadType
+		payloadType++
+		if payloadType == opus.PayloadType {
+			payloadType++
+		}
+		return next
+	}
+
+	for _, name := range cfg.Video {
+		fmtpLines := []string{""}
+		switch name {
+		case "vp9":
+			fmtpLines = []string{"profile-id=0"}
+		case "h264":
+			fmtpLines = nil
+			for _, profile := range cfg.H264Profiles {
+				fmtpLines = append(fmtpLines, "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id="+profile)
+			}
+		}
+
+		for _, fmtpLine := range fmtpLines {
+			codec := webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: codecMimeTypes[name], ClockRate: 90000, SDPFmtpLine: fmtpLine, RTCPFeedback: videoRTCPFeedback},
+				PayloadType:        nextPayloadType(),
+			}
+			rtx := webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeRTX, ClockRate: 90000, SDPFmtpLine: fmt.Sprintf("apt=%d", codec.PayloadType)},
+				PayloadType:        nextPayloadType(),
+			}
+			if err := register(codec, webrtc.RTPCodecTypeVideo); err != nil {
+				return nil, err
+			}
+			if err := register(rtx, webrtc.RTPCodecTypeVideo); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return registered, nil
+}
+
+// Function to order the registered codecs of a kind by a session's codec
+// policy, leaving out the ones it doesn't name. Returns nil, meaning no
+// restriction, when the policy names none of the kind that the SFU offers.
+func (s *SFU) codecPreferences(policy []string, kind webrtc.RTPCodecType) []webrtc.RTPCodecParameters {
+	var preferred []webrtc.RTPCodecParameters
+	for _, name := range policy {
+		if !strings.HasPrefix(codecMimeTypes[name], kind.String()+"/") {
+			continue
+		}
+		for _, codec := range s.codecs {
+			if !strings.EqualFold(codec.MimeType, codecMimeTypes[name]) {
+				continue
+			}
+			preferred = append(preferred, codec)
+			for _, rtx := range s.codecs {
+				if strings.EqualFold(rtx.MimeType, mimeTypeRTX) && rtx.SDPFmtpLine == fmt.Sprintf("apt=%d", codec.PayloadType) {
+					preferred = append(preferred, rtx)
+				}
+			}
+		}
+	}
+	return preferred
+}
+
+// Outcomes of checking whether a peer can receive a codec.
+const (
+	codecSupported = iota
+	codecUnsupported
+	codecUnknown // the peer hasn't answered for the kind yet
+)
+
+// Function to parse an SDP fmtp line into its parameters
+func fmtpParameters(line string) map[string]string {
+	parameters := map[string]string{}
+	for _, parameter := range strings.Split(line, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+		if key != "" {
+			parameters[strings.ToLower(key)] = value
+		}
+	}
+	return parameters
+}
+
+// Function to return the H.264 profile and packetization mode in an fmtp
+// line, with the defaults of RFC 6184 for the ones left out. The profile is
+// profile_idc and profile-iop, the first two bytes of profile-level-id; the
+// level that follows doesn't have to match.
+func h264Format(line string) (profile, packetizationMode string) {
+	parameters := fmtpParameters(line)
+	profileLevelID, ok := parameters["profile-level-id"]
+	if !ok || len(profileLevelID) != 6 {
+		profileLevelID = "42000a"
+	}
+	packetizationMode, ok = parameters["packetization-mode"]
+	if !ok {
+		packetizationMode = "0"
+	}
+	return strings.ToLower(profileLevelID[:4]), packetizationMode
+}
+
+// Function to report whether a stream sent with one fmtp line can be sent
+// as a codec negotiated with another. Only H.264 has format parameters that
+// must agree (RFC 6184 section 8.2.2): the profile and packetization mode.
+func fmtpCompatible(mimeType, a, b string) bool {
+	if !strings.EqualFold(mimeType, webrtc.MimeTypeH264) {
+		return true
+	}
+	aProfile, aMode := h264Format(a)
+	bProfile, bMode := h264Format(b)
+	return aProfile == bProfile && aMode == bMode
+}
+
+// Function to report whether every browser can receive a codec in this
+// format. For H.264 that is the Constrained Baseline profile: profile_idc
+// 66 with constraint_set1_flag set.
+func mandatoryCodec(mimeType, fmtp string) bool {
+	if !slices.ContainsFunc(mandatoryCodecs, func(m string) bool { return strings.EqualFold(m, mimeType) }) {
+		return false
+	}
+	if !strings.EqualFold(mimeType, webrtc.MimeTypeH264) {
+		return true
+	}
+	profile, _ := h264Format(fmtp)
+	iop, err := strconv.ParseUint(profile[2:], 16, 8)
+	return profile[:2] == "42" && err == nil && iop&0x40 != 0
+}
+
+// Struct to learn from a peer's answers which codecs it can receive. Like
+// pion, only the first answered m-section of each kind counts: later
+// offers are limited to the codecs it accepted.
+type codecSupport struct {
+	mu       sync.Mutex
+	accepted map[string]map[string][]string // by kind, then lower case MIME type: fmtp lines
+	refused  map[string]bool                // track IDs the peer was told it can't receive
+}
+
+// Function to create an empty record of a peer's codecs
+func newCodecSupport() *codecSupport {
+	return &codecSupport{
+		accepted: make(map[string]map[string][]string),
+		refused:  make(map[string]bool),
+	}
+}
+
+// Function to record the codecs a peer accepted in an answer. Reports
+// whether a kind became known.
+func (c *codecSupport) learn(answer webrtc.SessionDescription) (bool, error) {
+	parsed, err := answer.Unmarshal()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	learned := false
+	for _, media := range parsed.MediaDescriptions {
+		kind := strings.ToLower(media.MediaName.Media)
+		if kind != "audio" && kind != "video" || c.accepted[kind] != nil {
+			continue
+		}
+
+		accepted := map[string][]string{}
+		for _, format := range media.MediaName.Formats {
+			payloadType, err := strconv.ParseUint(format, 10, 8)
+			if err != nil {
+				continue
+			}
+			if codec, err := parsed.GetCodecForPayloadType(uint8(payloadType)); err == nil {
+				mimeType := strings.ToLower(kind + "/" + codec.Name)
+				accepted[mimeType] = append(accepted[mimeType], codec.Fmtp)
+			}
+		}
+		c.accepted[kind] = accepted
+		learned = true
+	}
+	return learned, nil
+}
+
+// Function to check whether the peer can receive a codec of a kind in the
+// format fmtp describes
+func (c *codecSupport) check(kind, mimeType, fmtp string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	accepted, ok := c.accepted[kind]
+	switch {
+	case ok && slices.ContainsFunc(accepted[strings.ToLower(mimeType)], func(f string) bool { return fmtpCompatible(mimeType, f, fmtp) }):
+		return codecSupported
+	case ok:
+		return codecUnsupported
+	case mandatoryCodec(mimeType, fmtp):
+		return codecSupported
+	default:
+		return codecUnknown
+	}
+}
+
+// Function to replace the tracks the peer can't receive, returning the
+// ones it hasn't been told about yet
+func (c *codecSupport) refuse(tracks []trackInfo) []trackInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var untold []trackInfo
+	refused := make(map[string]bool, len(tracks))
+	for _, track := range tracks {
+		if !c.refused[track.TrackID] {
+			untold = append(untold, track)
+		}
+		refused[track.TrackID] = true
+	}
+	c.refused = refused
+	return untold
+}
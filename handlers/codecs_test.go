@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"webrtc/config"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// An answer accepting Opus, VP8 and Constrained Baseline H.264 in its
+// first m-sections, and VP9 only in a later video one
+const testAnswer = `v=0
+o=- 1 2 IN IP4 127.0.0.1
+s=-
+t=0 0
+m=audio 9 UDP/TLS/RTP/SAVPF 111
+c=IN IP4 0.0.0.0
+a=mid:0
+a=rtpmap:111 opus/48000/2
+a=fmtp:111 minptime=10;useinbandfec=1
+m=video 9 UDP/TLS/RTP/SAVPF 96 102
+c=IN IP4 0.0.0.0
+a=mid:1
+a=rtpmap:96 VP8/90000
+a=rtpmap:102 H264/90000
+a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f
+m=video 9 UDP/TLS/RTP/SAVPF 98
+c=IN IP4 0.0.0.0
+a=mid:2
+a=rtpmap:98 VP9/90000
+`
+
+const (
+	h264ConstrainedBaseline = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"
+	h264Baseline            = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f"
+	h264Main                = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f"
+)
+
+func TestCodecSupportLearn(t *testing.T) {
+	c := newCodecSupport()
+	if got := c.check("video", webrtc.MimeTypeVP9, ""); got != codecUnknown {
+		t.Errorf("VP9 before any answer = %d, want unknown", got)
+	}
+
+	learned, err := c.learn(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: testAnswer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !learned {
+		t.Fatal("learned nothing from the first answer")
+	}
+
+	tests := []struct {
+		kind, mimeType, fmtp string
+		want                 int
+	}{
+		{"audio", webrtc.MimeTypeOpus, "", codecSupported},
+		{"audio", webrtc.MimeTypePCMU, "", codecUnsupported},
+		{"video", webrtc.MimeTypeVP8, "", codecSupported},
+		{"video", "video/vp8", "", codecSupported},
+		{"video", webrtc.MimeTypeH264, h264ConstrainedBaseline, codecSupported},
+		{"video", webrtc.MimeTypeH264, "profile-level-id=42e034;packetization-mode=1", codecSupported},
+		{"video", webrtc.MimeTypeH264, h264Baseline, codecUnsupported},
+		{"video", webrtc.MimeTypeH264, h264Main, codecUnsupported},
+		{"video", webrtc.MimeTypeH264, "profile-level-id=42e01f", codecUnsupported},
+		// only the first m-section of a kind counts
+		{"video", webrtc.MimeTypeVP9, "", codecUnsupported},
+	}
+	for _, test := range tests {
+		if got := c.check(test.kind, test.mimeType, test.fmtp); got != test.want {
+			t.Errorf("check(%s, %q) = %d, want %d", test.mimeType, test.fmtp, got, test.want)
+		}
+	}
+
+	if learned, _ := c.learn(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: testAnswer}); learned {
+		t.Error("learned again from an answer for known kinds")
+	}
+}
+
+func TestMandatoryCodecsBeforeAnswer(t *testing.T) {
+	c := newCodecSupport()
+	tests := []struct {
+		mimeType, fmtp string
+		want           int
+	}{
+		{webrtc.MimeTypeOpus, "", codecSupported},
+		{webrtc.MimeTypeVP8, "", codecSupported},
+		{webrtc.MimeTypeH264, h264ConstrainedBaseline, codecSupported},
+		{webrtc.MimeTypeH264, h264Baseline, codecUnknown},
+		{webrtc.MimeTypeH264, h264Main, codecUnknown},
+		{webrtc.MimeTypeVP9, "profile-id=0", codecUnknown},
+		{webrtc.MimeTypeAV1, "", codecUnknown},
+	}
+	for _, test := range tests {
+		kind := "video"
+		if test.mimeType == webrtc.MimeTypeOpus {
+			kind = "audio"
+		}
+		if got := c.check(kind, test.mimeType, test.fmtp); got != test.want {
+			t.Errorf("check(%s, %q) = %d, want %d", test.mimeType, test.fmtp, got, test.want)
+		}
+	}
+}
+
+func TestCodecSupportRefuse(t *testing.T) {
+	c := newCodecSupport()
+	a, b := trackInfo{TrackID: "a"}, trackInfo{TrackID: "b"}
+
+	if untold := c.refuse([]trackInfo{a}); len(untold) != 1 {
+		t.Errorf("first refusal told %d tracks, want 1", len(untold))
+	}
+	if untold := c.refuse([]trackInfo{a, b}); len(untold) != 1 || untold[0].TrackID != "b" {
+		t.Errorf("second refusal told %v, want only b", untold)
+	}
+	c.refuse(nil)
+	if untold := c.refuse([]trackInfo{a}); len(untold) != 1 {
+		t.Error("a track that became receivable and unreceivable again was not told")
+	}
+}
+
+func TestMatchCodec(t *testing.T) {
+	negotiated := []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, PayloadType: 96},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: h264ConstrainedBaseline}, PayloadType: 102},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: h264Main}, PayloadType: 104},
+	}
+
+	tests := []struct {
+		mimeType, fmtp string
+		want           webrtc.PayloadType // 0 for no match
+	}{
+		{webrtc.MimeTypeVP8, "", 96},
+		{webrtc.MimeTypeH264, h264Main, 104},
+		{webrtc.MimeTypeH264, "packetization-mode=1;profile-level-id=42e034", 102},
+		{webrtc.MimeTypeH264, h264Baseline, 0},
+		{webrtc.MimeTypeVP9, "", 0},
+	}
+	for _, test := range tests {
+		codec, ok := matchCodec(webrtc.RTPCodecCapability{MimeType: test.mimeType, SDPFmtpLine: test.fmtp}, negotiated)
+		if got := codec.PayloadType; !ok && test.want != 0 || ok && got != test.want {
+			t.Errorf("matchCodec(%s, %q) = %d, %v, want %d", test.mimeType, test.fmtp, got, ok, test.want)
+		}
+	}
+}
+
+func TestRegisterCodecs(t *testing.T) {
+	registered, err := registerCodecs(&webrtc.MediaEngine{}, config.Codecs{
+		Audio:        []string{"pcmu", "opus"},
+		Video:        []string{"h264", "vp8"},
+		H264Profiles: []string{"4d001f", "42e01f"},
+		OpusFEC:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		mimeType, fmtp string
+		payloadType    webrtc.PayloadType
+	}{
+		{webrtc.MimeTypePCMU, "", 0},
+		{webrtc.MimeTypeOpus, "minptime=10;useinbandfec=1", 111},
+		{webrtc.MimeTypeH264, h264Main, 96},
+		{mimeTypeRTX, "apt=96", 97},
+		{webrtc.MimeTypeH264, h264ConstrainedBaseline, 98},
+		{mimeTypeRTX, "apt=98", 99},
+		{webrtc.MimeTypeVP8, "", 100},
+		{mimeTypeRTX, "apt=100", 101},
+	}
+	if len(registered) != len(want) {
+		t.Fatalf("registered %d codecs, want %d", len(registered), len(want))
+	}
+	for i, codec := range registered {
+		if codec.MimeType != want[i].mimeType || codec.SDPFmtpLine != want[i].fmtp || codec.PayloadType != want[i].payloadType {
+			t.Errorf("codec %d = %s %q %d, want %s %q %d", i, codec.MimeType, codec.SDPFmtpLine, codec.PayloadType, want[i].mimeType, want[i].fmtp, want[i].payloadType)
+		}
+	}
+}
+
+func TestRegisterCodecsSkipsOpusPayloadType(t *testing.T) {
+	video := []string{}
+	for i := 0; i < 8; i++ {
+		video = append(video, "vp8")
+	}
+	registered, err := registerCodecs(&webrtc.MediaEngine{}, config.Codecs{Audio: []string{"opus"}, Video: video})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, codec := range registered[1:] {
+		if codec.PayloadType == 111 {
+			t.Errorf("%s %q took Opus's payload type", codec.MimeType, codec.SDPFmtpLine)
+		}
+	}
+}
+
+func TestCodecPreferences(t *testing.T) {
+	codecs, err := registerCodecs(&webrtc.MediaEngine{}, config.Default().WebRTC.Codecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SFU{codecs: codecs}
+	describe := func(preferred []webrtc.RTPCodecParameters) []string {
+		names := []string{}
+		for _, codec := range preferred {
+			names = append(names, fmt.Sprintf("%s %s", codec.MimeType, codec.SDPFmtpLine))
+		}
+		return names
+	}
+
+	tests := []struct {
+		name   string
+		policy []string
+		kind   webrtc.RTPCodecType
+		want   []string
+	}{
+		{"no policy", nil, webrtc.RTPCodecTypeVideo, []string{}},
+		{"no codecs of the kind", []string{"pcmu"}, webrtc.RTPCodecTypeVideo, []string{}},
+		{"audio in policy order", []string{"vp8", "pcmu", "opus"}, webrtc.RTPCodecTypeAudio, []string{
+			"audio/PCMU ", "audio/opus minptime=10;useinbandfec=1",
+		}},
+		{"video with RTX", []string{"vp9", "opus", "vp8"}, webrtc.RTPCodecTypeVideo, []string{
+			"video/VP9 profile-id=0", "video/rtx apt=104", "video/VP8 ", "video/rtx apt=96",
+		}},
+		{"every H.264 profile", []string{"h264"}, webrtc.RTPCodecTypeVideo, []string{
+			"video/H264 " + h264ConstrainedBaseline, "video/rtx apt=98",
+			"video/H264 " + h264Baseline, "video/rtx apt=100",
+			"video/H264 " + h264Main, "video/rtx apt=102",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := describe(s.codecPreferences(test.policy, test.kind))
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("codecPreferences = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnsupportedCodecIsAnnounced(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, true)
+	host.publishCodec(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0"})
+	host.answer()
+	eventually(t, "the host's video", func() bool { return len(publishedBy(sfu, "host")) == 1 })
+
+	// Bob's client can receive VP8 but not the host's VP9
+	engine := &webrtc.MediaEngine{}
+	if err := engine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        96,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(engine))
+	bob := joinWith(t, api.NewPeerConnection, sfu, server, Participant{ID: "bob", Role: RolePresenter}, false)
+
+	unsupported := trackInfo{}
+	bob.waitFor("track-unsupported", &unsupported)
+	if unsupported.TrackID != "host-video" || unsupported.Codec != webrtc.MimeTypeVP9 {
+		t.Errorf("track-unsupported %+v, want the host's VP9 video", unsupported)
+	}
+	if tracks := sentTo(sfu, "bob"); len(tracks) > 0 {
+		t.Errorf("bob sent %v", tracks)
+	}
+
+	// Carol's can, so she gets it
+	carol := join(t, sfu, server, Participant{ID: "carol", Role: RolePresenter}, false)
+	carol.waitFor("roster", nil)
+	eventually(t, "carol sent the video", func() bool { return len(sentTo(sfu, "carol")) == 1 })
+}
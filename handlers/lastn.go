@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// Payload of a last-n event: the participants whose camera video is
+// forwarded, most recent speaker first. Null when the room no longer
+// limits video.
+type lastNMessage struct {
+	ParticipantIDs []string `json:"participant_ids"`
+}
+
+// Function to pause or resume forwarding the track. Reports whether it
+// was resumed, in which case subscribers need a key frame.
+func (p *publishedTrack) setPaused(paused bool) bool {
+	return p.paused.Swap(paused) && !paused
+}
+
+// Function to ask the publisher for a key frame of the track
+func (p *publishedTrack) requestKeyFrame() {
+	_ = p.publisher.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(p.ssrc)},
+	})
+}
+
+// Function to pick whose camera video is forwarded under the room's last-N
+// policy and pause the rest, caller must hold listLock. Participants are
+// ranked by when they last held the floor; those who never spoke follow
+// in the order they started publishing. Reports whether the selection
+// changed.
+func (room *Room) applyLastN() bool {
+	type candidate struct {
+		participantID string
+		spoke         time.Time
+		since         time.Time
+	}
+
+	spoke := room.speakers.recency()
+	byParticipant := map[string]candidate{}
+	for _, published := range room.published {
+		if published.source != SourceVideo {
+			continue
+		}
+		c, ok := byParticipant[published.participantID]
+		if !ok || published.since.Before(c.since) {
+			byParticipant[published.participantID] = candidate{published.participantID, spoke[published.participantID], published.since}
+		}
+	}
+
+	ranked := make([]candidate, 0, len(byParticipant))
+	for _, c := range byParticipant {
+		ranked = append(ranked, c)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if !ranked[i].spoke.Equal(ranked[j].spoke) {
+			return ranked[i].spoke.After(ranked[j].spoke)
+		}
+		return ranked[i].since.Before(ranked[j].since)
+	})
+
+	forwarded := []string{}
+	for i, c := range ranked {
+		if room.lastN > 0 && i >= room.lastN {
+			break
+		}
+		forwarded = append(forwarded, c.participantID)
+	}
+
+	for _, published := range room.published {
+		if published.source != SourceVideo {
+			continue
+		}
+		if published.setPaused(!slices.Contains(forwarded, published.participantID)) {
+			published.requestKeyFrame()
+		}
+	}
+
+	if room.lastN == 0 {
+		// The limit was lifted and everything resumed
+		forwarded = nil
+	}
+	changed := !slices.Equal(forwarded, room.lastNForwarded) || (forwarded == nil) != (room.lastNForwarded == nil)
+	room.lastNForwarded = forwarded
+	return changed
+}
+
+// Function to re-apply the last-N policy of a room that has or had one and
+// tell the room if the selection changed
+func (s *SFU) updateLastN(room *Room) {
+	s.listLock.Lock()
+	if room.lastN == 0 && room.lastNForwarded == nil {
+		s.listLock.Unlock()
+		return
+	}
+	changed := room.applyLastN()
+	forwarded := slices.Clone(room.lastNForwarded)
+	s.listLock.Unlock()
+
+	if changed {
+		room.log.Debug("last-n video changed", "participant_ids", forwarded)
+		s.broadcast(room, "last-n", lastNMessage{ParticipantIDs: forwarded}, "")
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestApplyLastN(t *testing.T) {
+	publisher, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	start := time.Now()
+	track := func(participantID, source string, since time.Duration) *publishedTrack {
+		kind := webrtc.RTPCodecTypeVideo.String()
+		if source == SourceAudio {
+			kind = webrtc.RTPCodecTypeAudio.String()
+		}
+		return &publishedTrack{participantID: participantID, source: source, kind: kind, since: start.Add(since), publisher: publisher}
+	}
+	room := &Room{
+		lastN:    2,
+		speakers: *newTestDetector(),
+		published: map[string]*publishedTrack{
+			"alice-video":  track("alice", SourceVideo, 0),
+			"alice-screen": track("alice", SourceScreen, 0),
+			"bob-video":    track("bob", SourceVideo, time.Second),
+			"carol-video":  track("carol", SourceVideo, 2*time.Second),
+			"carol-audio":  track("carol", SourceAudio, 2*time.Second),
+		},
+	}
+	paused := func() []string {
+		trackIDs := []string{}
+		for trackID, published := range room.published {
+			if published.paused.Load() {
+				trackIDs = append(trackIDs, trackID)
+			}
+		}
+		slices.Sort(trackIDs)
+		return trackIDs
+	}
+	check := func(step string, wantChanged bool, wantForwarded, wantPaused []string) {
+		t.Helper()
+		if changed := room.applyLastN(); changed != wantChanged {
+			t.Errorf("%s: changed %v, want %v", step, changed, wantChanged)
+		}
+		if !slices.Equal(room.lastNForwarded, wantForwarded) {
+			t.Errorf("%s: forwarded %v, want %v", step, room.lastNForwarded, wantForwarded)
+		}
+		if got := paused(); !slices.Equal(got, wantPaused) {
+			t.Errorf("%s: paused %v, want %v", step, got, wantPaused)
+		}
+	}
+
+	// Nobody spoke yet, so the first to publish are forwarded. Screen
+	// shares and audio are never paused.
+	check("first publishers", true, []string{"alice", "bob"}, []string{"carol-video"})
+	check("nothing new", false, []string{"alice", "bob"}, []string{"carol-video"})
+
+	room.speakers.spoke["carol"] = start.Add(3 * time.Second)
+	check("carol spoke", true, []string{"carol", "alice"}, []string{"bob-video"})
+
+	room.speakers.spoke["bob"] = start.Add(4 * time.Second)
+	check("bob spoke", true, []string{"bob", "carol"}, []string{"alice-video"})
+
+	room.lastN = 0
+	check("limit lifted", true, nil, []string{})
+	if room.lastNForwarded != nil {
+		t.Error("forwarded not null once the limit was lifted")
+	}
+}
+
+func TestLastNPausesLaterPublishers(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{LastN: 1})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, true)
+	host.publish(webrtc.RTPCodecTypeVideo)
+	host.answer()
+	selection := lastNMessage{}
+	host.waitFor("last-n", &selection)
+	if !slices.Equal(selection.ParticipantIDs, []string{"host"}) {
+		t.Fatalf("last-n %v, want host", selection.ParticipantIDs)
+	}
+
+	alice := join(t, sfu, server, Participant{ID: "alice", Role: RolePresenter}, true)
+	alice.publish(webrtc.RTPCodecTypeVideo)
+	alice.answer()
+	// The selection is applied just after the track is added
+	eventually(t, "alice's video paused", func() bool {
+		sfu.listLock.RLock()
+		defer sfu.listLock.RUnlock()
+		for _, published := range sfu.rooms[testRoomID].published {
+			if published.participantID == "alice" {
+				return published.paused.Load()
+			}
+		}
+		return false
+	})
+
+	sfu.listLock.RLock()
+	defer sfu.listLock.RUnlock()
+	for _, published := range sfu.rooms[testRoomID].published {
+		if published.participantID == "host" && published.paused.Load() {
+			t.Error("host's video paused")
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+	"webrtc/audio"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// maxMixerLag - The most decoded audio an input may have waiting. Anything
+// older is dropped so a publisher sending too fast can't add delay.
+const maxMixerLag = 3 * audio.FrameSamples
+
+// Struct to mix a room's audio for the peers that asked for one mixed
+// track instead of a track per publisher
+type audioMixer struct {
+	codec audio.Codec // of the mixed tracks
+	log   *slog.Logger
+
+	mu      sync.Mutex
+	inputs  map[string]*mixerInput  // by track ID
+	outputs map[string]*mixerOutput // by participant ID
+	running bool
+}
+
+// Struct to hold one published audio track's decoded audio
+type mixerInput struct {
+	participantID string
+	decoder       audio.Decoder // nil when the codec can't be decoded
+	pending       []int16
+}
+
+// Struct to hold one listener's mixed track
+type mixerOutput struct {
+	track   *webrtc.TrackLocalStaticSample
+	encoder audio.Encoder
+}
+
+// Function to create a mixer producing tracks in codec
+func newAudioMixer(codec audio.Codec, log *slog.Logger) *audioMixer {
+	return &audioMixer{
+		codec:   codec,
+		log:     log,
+		inputs:  make(map[string]*mixerInput),
+		outputs: make(map[string]*mixerOutput),
+	}
+}
+
+// Function to feed the payload of an audio packet to the mixer. Nothing
+// is decoded while no one listens to the mix.
+func (m *audioMixer) push(trackID, participantID, mimeType string, payload []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.outputs) == 0 {
+		return
+	}
+
+	input, ok := m.inputs[trackID]
+	if !ok {
+		input = &mixerInput{participantID: participantID}
+		if codec, ok := audio.Lookup(mimeType); ok {
+			decoder, err := codec.NewDecoder()
+			if err != nil {
+				m.log.Warn("create audio decoder", "track_id", trackID, "codec", mimeType, "err", err)
+			}
+			input.decoder = decoder
+		} else {
+			m.log.Warn("no decoder for audio codec, forwarding track unmixed", "track_id", trackID, "codec", mimeType)
+		}
+		m.inputs[trackID] = input
+	}
+	if input.decoder == nil {
+		return
+	}
+
+	pcm, err := input.decoder.Decode(payload)
+	if err != nil {
+		m.log.Debug("decode audio", "track_id", trackID, "err", err)
+		return
+	}
+	input.pending = append(input.pending, pcm...)
+	if excess := len(input.pending) - maxMixerLag; excess > 0 {
+		input.pending = input.pending[excess:]
+	}
+}
+
+// Function to stop mixing a track that is no longer published
+func (m *audioMixer) removeInput(trackID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.inputs, trackID)
+}
+
+// Function to return a listener's mixed track, creating it and starting
+// the mixer if needed
+func (m *audioMixer) output(participantID string) (*webrtc.TrackLocalStaticSample, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if output, ok := m.outputs[participantID]; ok {
+		return output.track, nil
+	}
+
+	encoder, err := m.codec.NewEncoder()
+	if err != nil {
+		return nil, err
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:  m.codec.MimeType(),
+		ClockRate: m.codec.ClockRate(),
+		Channels:  m.codec.Channels(),
+	}, "mix-"+participantID, "mix")
+	if err != nil {
+		return nil, err
+	}
+
+	m.outputs[participantID] = &mixerOutput{track: track, encoder: encoder}
+	if !m.running {
+		m.running = true
+		go m.run()
+	}
+	return track, nil
+}
+
+// Function to stop mixing for a listener, safe to call when it has no mix
+func (m *audioMixer) removeOutput(participantID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.outputs, participantID)
+}
+
+// Function to mix a frame every FrameDuration until no one listens
+func (m *audioMixer) run() {
+	ticker := time.NewTicker(audio.FrameDuration)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !m.mix() {
+			return
+		}
+	}
+}
+
+// Function to mix and send one frame to every listener. Reports false,
+// having marked the mixer stopped, when there are no listeners left.
+func (m *audioMixer) mix() bool {
+	frames, ok := m.mixFrame()
+	if !ok {
+		return false
+	}
+	for _, f := range frames {
+		_ = f.track.WriteSample(media.Sample{Data: f.data, Duration: audio.FrameDuration})
+	}
+	return true
+}
+
+// Struct to hold one listener's encoded share of a mixed frame
+type mixedFrame struct {
+	participantID string
+	track         *webrtc.TrackLocalStaticSample
+	data          []byte
+}
+
+// Function to mix one frame of everyone's pending audio and encode it for
+// each listener. Reports false, having marked the mixer stopped, when
+// there are no listeners left.
+func (m *audioMixer) mixFrame() ([]mixedFrame, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.outputs) == 0 {
+		m.running = false
+		clear(m.inputs)
+		return nil, false
+	}
+
+	sum := make([]int32, audio.FrameSamples)
+	own := map[string][]int32{} // each participant's part of the sum
+	for _, input := range m.inputs {
+		n := min(len(input.pending), audio.FrameSamples)
+		frame := input.pending[:n]
+		input.pending = input.pending[n:]
+
+		audio.Accumulate(sum, frame)
+		if own[input.participantID] == nil {
+			own[input.participantID] = make([]int32, audio.FrameSamples)
+		}
+		audio.Accumulate(own[input.participantID], frame)
+	}
+
+	frames := make([]mixedFrame, 0, len(m.outputs))
+	frame := make([]int16, audio.FrameSamples)
+	for participantID, output := range m.outputs {
+		audio.Render(frame, sum, own[participantID])
+		data, err := output.encoder.Encode(frame)
+		if err != nil {
+			m.log.Warn("encode mixed audio", "participant_id", participantID, "err", err)
+			continue
+		}
+		frames = append(frames, mixedFrame{participantID, output.track, data})
+	}
+	return frames, true
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+	"webrtc/audio"
+	"webrtc/config"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Function to create a PCMU mixer that only mixes when the test asks
+func newTestMixer(t *testing.T) *audioMixer {
+	t.Helper()
+	codec, ok := audio.Lookup(webrtc.MimeTypePCMU)
+	if !ok {
+		t.Fatal("PCMU is not registered")
+	}
+	m := newAudioMixer(codec, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.running = true // keep output from starting the ticker
+	return m
+}
+
+// Function to encode 20 ms of a constant level as a PCMU payload
+func pcmuPayload(t *testing.T, level int16) []byte {
+	t.Helper()
+	codec, _ := audio.Lookup(webrtc.MimeTypePCMU)
+	encoder, _ := codec.NewEncoder()
+	frame := make([]int16, audio.FrameSamples)
+	for i := range frame {
+		frame[i] = level
+	}
+	payload, err := encoder.Encode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// Function to decode the level at the end of a mixed PCMU frame
+func pcmuLevel(t *testing.T, payload []byte) int {
+	t.Helper()
+	codec, _ := audio.Lookup(webrtc.MimeTypePCMU)
+	decoder, _ := codec.NewDecoder()
+	pcm, err := decoder.Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(pcm[len(pcm)-1])
+}
+
+func TestMixerLeavesOutOwnVoice(t *testing.T) {
+	m := newTestMixer(t)
+	for _, participantID := range []string{"alice", "bob", "carol"} {
+		if _, err := m.output(participantID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.push("a-audio", "alice", webrtc.MimeTypePCMU, pcmuPayload(t, 1000))
+	m.push("b-audio", "bob", webrtc.MimeTypePCMU, pcmuPayload(t, 2000))
+
+	frames, ok := m.mixFrame()
+	if !ok {
+		t.Fatal("mixer stopped with listeners left")
+	}
+	want := map[string]int{"alice": 2000, "bob": 1000, "carol": 3000}
+	if len(frames) != len(want) {
+		t.Fatalf("mixed %d frames, want %d", len(frames), len(want))
+	}
+	for _, frame := range frames {
+		if len(frame.data) != 160 {
+			t.Errorf("%s: frame of %d bytes, want 160", frame.participantID, len(frame.data))
+		}
+		got, expected := pcmuLevel(t, frame.data), want[frame.participantID]
+		if tolerance := expected / 10; got < expected-tolerance || got > expected+tolerance {
+			t.Errorf("%s hears level %d, want about %d", frame.participantID, got, expected)
+		}
+	}
+}
+
+func TestMixerSkipsUndecodableTracks(t *testing.T) {
+	m := newTestMixer(t)
+	if _, err := m.output("carol"); err != nil {
+		t.Fatal(err)
+	}
+	m.push("a-audio", "alice", webrtc.MimeTypeOpus, []byte{0xfc, 0xff, 0xfe})
+
+	if input := m.inputs["a-audio"]; input == nil || input.decoder != nil || len(input.pending) != 0 {
+		t.Fatalf("Opus input = %+v, want one without a decoder or audio", input)
+	}
+	frames, _ := m.mixFrame()
+	if len(frames) != 1 || pcmuLevel(t, frames[0].data) != 0 {
+		t.Errorf("mix of an undecodable track is not silence")
+	}
+}
+
+func TestMixerIdleWithoutListeners(t *testing.T) {
+	m := newTestMixer(t)
+	m.push("a-audio", "alice", webrtc.MimeTypePCMU, pcmuPayload(t, 1000))
+	if len(m.inputs) != 0 {
+		t.Error("decoded audio no one listens to")
+	}
+	if _, ok := m.mixFrame(); ok || m.running {
+		t.Error("mixer kept running without listeners")
+	}
+}
+
+func TestMixedAudioKeepsUnmixableTracks(t *testing.T) {
+	available := map[string]*publishedTrack{
+		"a-opus": {participantID: "alice", kind: "audio", codec: webrtc.MimeTypeOpus, source: SourceAudio},
+		"b-pcmu": {participantID: "bob", kind: "audio", codec: webrtc.MimeTypePCMU, source: SourceAudio},
+		"b-cam":  {participantID: "bob", kind: "video", codec: webrtc.MimeTypeVP8, source: SourceVideo},
+	}
+	subs := newSubscriptions()
+	subs.update(subscriptionMessage{MixedAudio: true}, true)
+
+	wanted := subs.filter(available, "")
+	if !wanted["a-opus"] {
+		t.Error("Opus track dropped although the mixer can't decode it")
+	}
+	if wanted["b-pcmu"] {
+		t.Error("PCMU track forwarded on top of the mix")
+	}
+	if !wanted["b-cam"] {
+		t.Error("video dropped by mixed audio")
+	}
+}
+
+func TestCanMixNeedsDecodableAudioCodecs(t *testing.T) {
+	sfu, err := NewSFU(config.Default().WebRTC, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy []string
+		want   bool
+	}{
+		{nil, false}, // every configured codec, Opus included
+		{[]string{"opus", "pcmu"}, false},
+		{[]string{"vp8"}, false}, // no audio named, so all of it is allowed
+		{[]string{"pcmu", "vp8"}, true},
+	} {
+		if got := sfu.canMix(tc.policy); got != tc.want {
+			t.Errorf("canMix(%v) = %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}
+
+// Function to connect two peer connections over loopback
+func connectPeers(t *testing.T, offerer, answerer *webrtc.PeerConnection) {
+	t.Helper()
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := offerer.SetRemoteDescription(*answerer.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMixerMixesPublishedTracks(t *testing.T) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterCodec(audioCodecs["pcmu"], webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	settings := webrtc.SettingEngine{}
+	settings.SetIncludeLoopbackCandidate(true)
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(settings))
+	newPeer := func() *webrtc.PeerConnection {
+		pc, err := api.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = pc.Close() })
+		return pc
+	}
+
+	codec, _ := audio.Lookup(webrtc.MimeTypePCMU)
+	mixer := newAudioMixer(codec, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer mixer.removeOutput("carol")
+
+	// Alice and Bob publish to the server, which feeds their audio to the
+	// mixer the way the SFU does
+	publisher, server := newPeer(), newPeer()
+	server.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		mimeType := trackCodec(track, receiver).MimeType
+		for {
+			packet, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			mixer.push(track.ID(), track.StreamID(), mimeType, packet.Payload)
+		}
+	})
+	levels := map[string]int16{"alice": 1000, "bob": 2000}
+	var published []*webrtc.TrackLocalStaticSample
+	var payloads [][]byte
+	for participantID, level := range levels {
+		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, participantID+"-audio", participantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := publisher.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+		published = append(published, track)
+		payloads = append(payloads, pcmuPayload(t, level))
+	}
+	connectPeers(t, publisher, server)
+
+	// Carol listens to the mix
+	mix, err := mixer.output("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder, listener := newPeer(), newPeer()
+	if _, err := forwarder.AddTrack(mix); err != nil {
+		t.Fatal(err)
+	}
+	heard := make(chan int, 100)
+	listener.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		decoder, _ := codec.NewDecoder()
+		for {
+			packet, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			pcm, err := decoder.Decode(packet.Payload)
+			if err != nil || len(pcm) == 0 {
+				continue
+			}
+			select {
+			case heard <- int(pcm[len(pcm)-1]):
+			default:
+			}
+		}
+	})
+	connectPeers(t, forwarder, listener)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(audio.FrameDuration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for i, track := range published {
+				_ = track.WriteSample(media.Sample{Data: payloads[i], Duration: audio.FrameDuration})
+			}
+		}
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case level := <-heard:
+			if level >= 2700 && level <= 3300 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("never heard Alice and Bob mixed together")
+		}
+	}
+}
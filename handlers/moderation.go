@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+	"webrtc/apierror"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Payload of a track-source message.
+type trackSourceMessage struct {
+	TrackID string `json:"track_id"`
+	Source  string `json:"source"`
+}
+
+// Payload of set-role and remove-participant messages.
+type moderationMessage struct {
+	ParticipantID string `json:"participant_id"`
+	Role          string `json:"role,omitempty"`
+}
+
+// Struct to remember the sources a participant announced for its tracks
+type trackSources struct {
+	mu      sync.Mutex
+	sources map[string]string // by track ID
+}
+
+// Function to record a track's announced source
+func (t *trackSources) set(trackID, source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sources[trackID] = source
+}
+
+// Function to look up a track's source, defaulting to its kind
+func (t *trackSources) get(track *webrtc.TrackRemote) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if source, ok := t.sources[track.ID()]; ok {
+		return source
+	}
+	if track.Kind() == webrtc.RTPCodecTypeAudio {
+		return SourceAudio
+	}
+	return SourceVideo
+}
+
+// Function to look up a participant's peer in a room, caller must hold
+// listLock
+func (room *Room) findPeer(participantID string) (*peerConnectionState, bool) {
+	for _, peer := range room.peerConnections {
+		if peer.participant.ID == participantID && peer.open() {
+			return peer, true
+		}
+	}
+	return nil, false
+}
+
+// Function to return a participant as they currently are, role changes
+// included
+func (s *SFU) currentParticipant(room *Room, participantID string) (Participant, bool) {
+	s.listLock.RLock()
+	defer s.listLock.RUnlock()
+
+	peer, ok := room.findPeer(participantID)
+	if !ok {
+		return Participant{}, false
+	}
+	return peer.participant, true
+}
+
+// Function to add the receiving transceivers a participant's role lets it
+// publish on: one for audio, one for camera video and one for screen
+// sharing. Transceivers it already has for publishing are kept; they are
+// told apart from the ones added to send it tracks by being counted here,
+// as sending turns either kind into sendrecv. All of them only accept the
+// codecs the session's policy allows.
+func (s *SFU) addPublishTransceivers(peer *peerConnectionState, participant Participant, policy []string) error {
+	preferences := map[webrtc.RTPCodecType][]webrtc.RTPCodecParameters{
+		webrtc.RTPCodecTypeAudio: s.codecPreferences(policy, webrtc.RTPCodecTypeAudio),
+		webrtc.RTPCodecTypeVideo: s.codecPreferences(policy, webrtc.RTPCodecTypeVideo),
+	}
+	restrict := func(transceiver *webrtc.RTPTransceiver) error {
+		if preferences[transceiver.Kind()] == nil {
+			return nil
+		}
+		return transceiver.SetCodecPreferences(preferences[transceiver.Kind()])
+	}
+
+	peer.publishLock.Lock()
+	defer peer.publishLock.Unlock()
+
+	pc := peer.peerConnection
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Direction() == webrtc.RTPTransceiverDirectionRecvonly {
+			// Includes one added to learn the codecs of a viewer, which
+			// the peer could start sending on once it may publish
+			if err := restrict(transceiver); err != nil {
+				return err
+			}
+		}
+	}
+
+	want := map[webrtc.RTPCodecType]int{}
+	if participant.CanPublish(SourceAudio) {
+		want[webrtc.RTPCodecTypeAudio]++
+	}
+	if participant.CanPublish(SourceVideo) {
+		want[webrtc.RTPCodecTypeVideo]++
+	}
+	if participant.CanPublish(SourceScreen) {
+		want[webrtc.RTPCodecTypeVideo]++
+	}
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		for ; peer.publishSlots[kind] < want[kind]; peer.publishSlots[kind]++ {
+			transceiver, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			})
+			if err != nil {
+				return err
+			}
+			if err := restrict(transceiver); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Function to change another participant's role on behalf of a moderator.
+// Tracks the new role may not publish stop being forwarded, and so does a
+// recording it may not run.
+func (s *SFU) setRole(room *Room, actorID string, change moderationMessage) *apierror.Error {
+	if !ValidRole(change.Role) || change.Role == RoleHost {
+		return &apierror.Error{Code: apierror.CodeValidationFailed, Message: "Role must be co-host, presenter or viewer."}
+	}
+
+	s.listLock.Lock()
+	target, err := room.moderationTarget(actorID, change.ParticipantID)
+	if err != nil {
+		s.listLock.Unlock()
+		return err
+	}
+
+	target.participant.Role = change.Role
+	updated := target.participant
+	policy := room.codecs
+
+	var halted []trackInfo
+	for trackID, published := range room.published {
+		if published.participantID == updated.ID && !updated.CanPublish(published.source) {
+			published.halt()
+			delete(room.trackLocals, trackID)
+			delete(room.published, trackID)
+			halted = append(halted, published.info(trackID))
+		}
+	}
+	if len(halted) > 0 {
+		room.tracksChanged()
+	}
+	s.listLock.Unlock()
+
+	for _, track := range halted {
+		s.broadcast(room, "track-unpublished", track, updated.ID)
+	}
+	if len(halted) > 0 {
+		s.updateLastN(room)
+	}
+
+	room.log.Info("role changed", "participant_id", updated.ID, "role", updated.Role, "by", actorID)
+	if !updated.Can(PermRecord) {
+		s.stopRecording(room, updated.ID)
+	}
+	if err := s.addPublishTransceivers(target, updated, policy); err != nil {
+		room.log.Warn("add transceivers", "participant_id", updated.ID, "err", err)
+	}
+	s.broadcast(room, "role-changed", updated, "")
+	// The role may allow publishing, or no longer allow subscribing
+	target.requestNegotiation()
+	return nil
+}
+
+// Function to disconnect another participant on behalf of a moderator
+func (s *SFU) removeParticipant(room *Room, actorID string, removal moderationMessage) *apierror.Error {
+	s.listLock.RLock()
+	peer, err := room.moderationTarget(actorID, removal.ParticipantID)
+	s.listLock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	room.log.Info("participant removed", "participant_id", peer.participant.ID, "by", actorID)
+	_ = peer.websocket.WriteJSON(&websocketMessage{Event: "removed"})
+	if err := peer.peerConnection.Close(); err != nil {
+		room.log.Warn("close peer connection", "participant_id", peer.participant.ID, "err", err)
+	}
+	peer.websocket.Close()
+	return nil
+}
+
+// Function to check the actor may moderate the target and find the
+// target's peer, caller must hold listLock. The host can't be moderated.
+func (room *Room) moderationTarget(actorID, targetID string) (*peerConnectionState, *apierror.Error) {
+	actor, ok := room.findPeer(actorID)
+	if !ok || !actor.participant.Can(PermModerate) {
+		return nil, &apierror.Error{Code: apierror.CodePermissionDenied, Message: "Only the host and co-hosts can moderate."}
+	}
+
+	target, ok := room.findPeer(targetID)
+	if !ok {
+		return nil, &apierror.Error{Code: apierror.CodeNotFound, Message: "Participant not found."}
+	}
+	if target.participant.Role == RoleHost {
+		return nil, &apierror.Error{Code: apierror.CodePermissionDenied, Message: "The host can't be moderated."}
+	}
+	return target, nil
+}
+
+// Function to tell a participant a request was refused
+func sendError(c *threadSafeWriter, refusal *apierror.Error) error {
+	data, err := json.Marshal(refusal)
+	if err != nil {
+		return err
+	}
+	return c.WriteJSON(&websocketMessage{Event: "error", Data: string(data)})
+}
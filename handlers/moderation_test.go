@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"webrtc/apierror"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Function to add an Opus or VP8 track to a test client and keep sending
+// it samples until the test ends, so the SFU sees it once negotiated. The
+// track's ID is the participant's followed by the kind, e.g. alice-video.
+func (c *testClient) publish(kind webrtc.RTPCodecType) *webrtc.TrackLocalStaticSample {
+	c.t.Helper()
+	if kind == webrtc.RTPCodecTypeVideo {
+		return c.publishCodec(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000})
+	}
+	return c.publishCodec(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2})
+}
+
+// Function to add a track in the codec to a test client, as publish does
+func (c *testClient) publishCodec(capability webrtc.RTPCodecCapability) *webrtc.TrackLocalStaticSample {
+	c.t.Helper()
+	kind := webrtc.RTPCodecTypeVideo
+	if strings.HasPrefix(capability.MimeType, "audio/") {
+		kind = webrtc.RTPCodecTypeAudio
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(capability, c.id+"-"+kind.String(), c.id)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.pc.AddTrack(track); err != nil {
+		c.t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	c.t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = track.WriteSample(media.Sample{Data: []byte{0xfc, 0xff, 0xfe}, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+	return track
+}
+
+func TestModerationNeedsModerator(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, false)
+	host.waitFor("roster", nil)
+	alice := join(t, sfu, server, Participant{ID: "alice", Role: RolePresenter}, false)
+	alice.waitFor("roster", nil)
+	bob := join(t, sfu, server, Participant{ID: "bob", Role: RolePresenter}, false)
+	bob.waitFor("roster", nil)
+
+	alice.send("set-role", moderationMessage{ParticipantID: "bob", Role: RoleViewer})
+	if code := alice.waitForError(); code != apierror.CodePermissionDenied {
+		t.Errorf("presenter set-role: %s, want %s", code, apierror.CodePermissionDenied)
+	}
+	alice.send("remove-participant", moderationMessage{ParticipantID: "bob"})
+	if code := alice.waitForError(); code != apierror.CodePermissionDenied {
+		t.Errorf("presenter remove-participant: %s, want %s", code, apierror.CodePermissionDenied)
+	}
+
+	for _, role := range []string{RoleHost, "admin"} {
+		host.send("set-role", moderationMessage{ParticipantID: "alice", Role: role})
+		if code := host.waitForError(); code != apierror.CodeValidationFailed {
+			t.Errorf("set-role %q: %s, want %s", role, code, apierror.CodeValidationFailed)
+		}
+	}
+	host.send("set-role", moderationMessage{ParticipantID: "nobody", Role: RoleViewer})
+	if code := host.waitForError(); code != apierror.CodeNotFound {
+		t.Errorf("set-role of someone absent: %s, want %s", code, apierror.CodeNotFound)
+	}
+
+	// A co-host may moderate, but not the host
+	host.send("set-role", moderationMessage{ParticipantID: "alice", Role: RoleCoHost})
+	changed := Participant{}
+	bob.waitFor("role-changed", &changed)
+	if changed.ID != "alice" || changed.Role != RoleCoHost {
+		t.Fatalf("role-changed %+v, want alice as co-host", changed)
+	}
+	alice.send("set-role", moderationMessage{ParticipantID: "host", Role: RoleViewer})
+	if code := alice.waitForError(); code != apierror.CodePermissionDenied {
+		t.Errorf("co-host moderating the host: %s, want %s", code, apierror.CodePermissionDenied)
+	}
+
+	alice.send("remove-participant", moderationMessage{ParticipantID: "bob"})
+	bob.waitFor("removed", nil)
+	left := Participant{}
+	host.waitFor("participant-left", &left)
+	if left.ID != "bob" {
+		t.Errorf("participant-left %q, want bob", left.ID)
+	}
+	for _, p := range sfu.Participants(testRoomID) {
+		if p.ID == "bob" {
+			t.Error("removed participant still in the room")
+		}
+	}
+}
+
+func TestDemotionStopsPublishing(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, false)
+	host.waitFor("roster", nil)
+
+	alice := join(t, sfu, server, Participant{ID: "alice", Role: RolePresenter}, true)
+	alice.publish(webrtc.RTPCodecTypeAudio)
+	alice.answer()
+	published := trackInfo{}
+	host.waitFor("track-published", &published)
+	if published.ParticipantID != "alice" || published.Source != SourceAudio {
+		t.Fatalf("track-published %+v, want alice's audio", published)
+	}
+
+	host.send("set-role", moderationMessage{ParticipantID: "alice", Role: RoleViewer})
+	unpublished := trackInfo{}
+	host.waitFor("track-unpublished", &unpublished)
+	if unpublished.TrackID != published.TrackID {
+		t.Errorf("track-unpublished %q, want %q", unpublished.TrackID, published.TrackID)
+	}
+	changed := Participant{}
+	alice.waitFor("role-changed", &changed)
+	if changed.ID != "alice" || changed.Role != RoleViewer {
+		t.Errorf("role-changed %+v, want alice as viewer", changed)
+	}
+}
+
+func TestOnTrackChecksCurrentRole(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, false)
+	host.waitFor("roster", nil)
+
+	// Alice is demoted while her first answer is still pending, so her
+	// media arrives on transceivers added while she was a presenter
+	alice := join(t, sfu, server, Participant{ID: "alice", Role: RolePresenter}, true)
+	alice.waitFor("roster", nil)
+	host.send("set-role", moderationMessage{ParticipantID: "alice", Role: RoleViewer})
+	alice.waitFor("role-changed", nil)
+
+	alice.publish(webrtc.RTPCodecTypeVideo)
+	alice.answer()
+	if code := alice.waitForError(); code != apierror.CodePermissionDenied {
+		t.Fatalf("viewer publishing: %s, want %s", code, apierror.CodePermissionDenied)
+	}
+
+	if tracks := publishedBy(sfu, "alice"); len(tracks) > 0 {
+		t.Errorf("viewer's tracks %v forwarded", tracks)
+	}
+}
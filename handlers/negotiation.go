@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// negotiationRetry - How long a peer waits before retrying a failed
+	// negotiation.
+	negotiationRetry = 3 * time.Second
+	// keyFrameInterval - The least time between key frame requests a room
+	// sends its publishers, however many peers start receiving at once.
+	keyFrameInterval = 500 * time.Millisecond
+)
+
+// Function to ask for the peer's tracks to be brought up to date. Requests
+// made while one is already waiting are merged into it.
+func (p *peerConnectionState) requestNegotiation() {
+	select {
+	case p.negotiate <- struct{}{}:
+	default:
+	}
+}
+
+// Function to return the channel closed on the room's next track change
+func (s *SFU) trackChange(room *Room) <-chan struct{} {
+	s.listLock.RLock()
+	defer s.listLock.RUnlock()
+	return room.trackChange
+}
+
+// Function to wake every peer in the room because tracks were added or
+// removed, caller must hold listLock. Closing the channel reaches all the
+// room's negotiators at once instead of visiting each peer.
+func (room *Room) tracksChanged() {
+	close(room.trackChange)
+	room.trackChange = make(chan struct{})
+}
+
+// Function to negotiate one peer's tracks until done is closed. Every peer
+// has its own negotiator, so a slow or unanswered peer holds up no one
+// else.
+func (s *SFU) negotiator(room *Room, peer *peerConnectionState, done <-chan struct{}) {
+	changed := s.trackChange(room)
+	for {
+		select {
+		case <-done:
+			return
+		case <-peer.negotiate:
+		case <-changed:
+		}
+
+		// Taken before the tracks are read, so a change made while
+		// negotiating wakes the loop again.
+		changed = s.trackChange(room)
+		if err := s.negotiate(room, peer); err != nil {
+			room.log.Warn("negotiation failed, retrying later", "participant_id", peer.participant.ID, "err", err)
+			time.AfterFunc(negotiationRetry, peer.requestNegotiation)
+		}
+	}
+}
+
+// Function to sync the peer's senders with the room's tracks and, if
+// anything changed, send it a new offer
+func (s *SFU) negotiate(room *Room, peer *peerConnectionState) error {
+	pc := peer.peerConnection
+	if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return nil
+	}
+	if pc.SignalingState() != webrtc.SignalingStateStable {
+		// An offer is still unanswered; the answer brings us back here.
+		peer.renegotiate.Store(true)
+		return nil
+	}
+
+	changed, err := s.syncSenders(room, peer)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		// Includes a viewer with nothing to receive yet, which has
+		// nothing to offer either
+		return nil
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err = pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	// pion refuses edited offers, so only the peer's copy has them
+	if offer, err = addRTXSSRCs(pc, offer); err != nil {
+		return err
+	}
+
+	offerString, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	return peer.websocket.WriteJSON(&websocketMessage{
+		Event: "offer",
+		Data:  string(offerString),
+	})
+}
+
+// Function to add the room's tracks the peer subscribes to and remove the
+// ones that are gone or unsubscribed. Tracks in a codec the peer can't
+// receive are left out and the peer is told about them. Reports whether the
+// session description needs to change.
+func (s *SFU) syncSenders(room *Room, peer *peerConnectionState) (bool, error) {
+	wanted := map[string]webrtc.TrackLocal{}
+	publishers := map[string]*publishedTrack{} // of the wanted tracks, by track ID
+	unknown := map[string]bool{}               // kinds the peer must be asked about
+	var unsupported []trackInfo
+	s.listLock.RLock()
+	canSubscribe := peer.participant.Can(PermSubscribe)
+	if canSubscribe {
+		for trackID, subscribed := range peer.subscriptions.filter(room.published, room.speakers.speaker()) {
+			published := room.published[trackID]
+			// Never send a participant its own tracks
+			if !subscribed || published.participantID == peer.participant.ID {
+				continue
+			}
+			switch peer.codecs.check(published.kind, published.codec, published.fmtp) {
+			case codecSupported:
+				wanted[trackID] = room.trackLocals[trackID]
+				publishers[trackID] = published
+			case codecUnsupported:
+				unsupported = append(unsupported, published.info(trackID))
+			case codecUnknown:
+				unknown[published.kind] = true
+			}
+		}
+	}
+	s.listLock.RUnlock()
+
+	for _, track := range peer.codecs.refuse(unsupported) {
+		if data, err := json.Marshal(track); err == nil {
+			_ = peer.websocket.WriteJSON(&websocketMessage{Event: "track-unsupported", Data: string(data)})
+		}
+	}
+
+	mixing := canSubscribe && peer.subscriptions.mixedAudio()
+	if mixing {
+		switch peer.codecs.check(webrtc.RTPCodecTypeAudio.String(), room.mixer.codec.MimeType(), "") {
+		case codecUnsupported:
+			mixing = false
+		case codecUnknown:
+			mixing = false
+			unknown[webrtc.RTPCodecTypeAudio.String()] = true
+		}
+	}
+	if mixing {
+		mix, err := room.mixer.output(peer.participant.ID)
+		if err != nil {
+			return false, err
+		}
+		wanted[mix.ID()] = mix
+	} else {
+		room.mixer.removeOutput(peer.participant.ID)
+	}
+
+	pc := peer.peerConnection
+	changed := false
+
+	// Remove tracks that are no longer available, or were replaced
+	for _, sender := range pc.GetSenders() {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		if trackLocal, ok := wanted[track.ID()]; ok && trackLocal == track {
+			delete(wanted, track.ID())
+			continue
+		}
+		if err := pc.RemoveTrack(sender); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	// Add new tracks
+	for trackID, trackLocal := range wanted {
+		sender, err := pc.AddTrack(trackLocal)
+		if err != nil {
+			return changed, err
+		}
+		if forwarded, ok := trackLocal.(*forwardedTrack); ok {
+			go s.readFeedback(sender, forwarded, publishers[trackID])
+		}
+		peer.keyFrames.Store(true)
+		changed = true
+	}
+
+	// The answer to an m-section of a kind the peer hasn't answered for yet
+	// shows which codecs it can receive. Peers without one, like viewers,
+	// get one to ask; it carries the first track of the kind later on.
+	for _, transceiver := range pc.GetTransceivers() {
+		delete(unknown, transceiver.Kind().String())
+	}
+	for kind := range unknown {
+		if _, err := pc.AddTransceiverFromKind(webrtc.NewRTPCodecType(kind), webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			return changed, err
+		}
+	}
+
+	// Transceivers added for a new role or a probe have not been offered yet
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Mid() == "" {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// Function to apply a peer's answer and follow up on what happened while
+// the offer was out
+func (s *SFU) answered(room *Room, peer *peerConnectionState, answer webrtc.SessionDescription) error {
+	if err := peer.peerConnection.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	learned, err := peer.codecs.learn(answer)
+	if err != nil {
+		return err
+	}
+	// Tracks held back until the peer's codecs were known can go out now
+	if peer.renegotiate.Swap(false) || learned {
+		peer.requestNegotiation()
+	}
+	if peer.keyFrames.Swap(false) {
+		s.requestKeyFrames(room)
+	}
+	return nil
+}
+
+// Function to ask the room's publishers for key frames so new receivers
+// can start decoding. Requests are spread at least keyFrameInterval apart;
+// ones made in between are merged into the next.
+func (s *SFU) requestKeyFrames(room *Room) {
+	room.keyFrameLock.Lock()
+	defer room.keyFrameLock.Unlock()
+
+	if room.keyFrameQueued {
+		return
+	}
+	room.keyFrameQueued = true
+
+	wait := max(time.Until(room.lastKeyFrame.Add(keyFrameInterval)), 0)
+	time.AfterFunc(wait, func() {
+		room.keyFrameLock.Lock()
+		room.keyFrameQueued = false
+		room.lastKeyFrame = time.Now()
+		room.keyFrameLock.Unlock()
+
+		s.listLock.RLock()
+		defer s.listLock.RUnlock()
+		room.sendKeyFrameRequests()
+	})
+}
+
+// Function to send a picture loss indication for every track the room's
+// peers publish, caller must hold listLock
+func (room *Room) sendKeyFrameRequests() {
+	for _, peer := range room.peerConnections {
+		for _, receiver := range peer.peerConnection.GetReceivers() {
+			if receiver.Track() == nil {
+				continue
+			}
+
+			_ = peer.peerConnection.WriteRTCP([]rtcp.Packet{
+				&rtcp.PictureLossIndication{
+					MediaSSRC: uint32(receiver.Track().SSRC()),
+				},
+			})
+		}
+	}
+}
+
+// Function to take a closed peer out of its room
+func (s *SFU) removePeer(room *Room, peer *peerConnectionState) {
+	s.listLock.Lock()
+	defer s.listLock.Unlock()
+
+	for i, p := range room.peerConnections {
+		if p == peer {
+			room.peerConnections = append(room.peerConnections[:i], room.peerConnections[i+1:]...)
+			room.log.Debug("removing closed peer", "participant_id", peer.participant.ID)
+			return
+		}
+	}
+}
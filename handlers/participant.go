@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrJoinToken - The join token is missing, invalid, for another room or
+// already used.
+var ErrJoinToken = errors.New("invalid join token")
+
+// Participant - Someone in a room, as described by their join token.
+type Participant struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Role        string `json:"role"`
+	UserID      string `json:"user_id,omitempty"`
+	Guest       bool   `json:"guest"` // joined without an account
+}
+
+// redeemedTokens - IDs of join tokens already used, kept until the tokens
+// expire so each admits only one connection.
+type redeemedTokens struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// redeem - Marks the token used. Returns false if it already was.
+func (r *redeemedTokens) redeem(id string, expiresAt time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for used, expiry := range r.ids {
+		if now.After(expiry) {
+			delete(r.ids, used)
+		}
+	}
+
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	r.ids[id] = expiresAt
+	return true
+}
+
+// Participants - Lists the participants connected to a room.
+func (s *SFU) Participants(roomId string) []Participant {
+	s.listLock.RLock()
+	defer s.listLock.RUnlock()
+
+	room, ok := s.rooms[roomId]
+	if !ok {
+		return []Participant{}
+	}
+	return room.roster()
+}
+
+// Function to list the open peers' participants, caller must hold listLock
+func (room *Room) roster() []Participant {
+	participants := []Participant{}
+	for _, peer := range room.peerConnections {
+		if peer.open() {
+			participants = append(participants, peer.participant)
+		}
+	}
+	return participants
+}
+
+// Function to list the participants a newcomer is told about, caller must
+// hold listLock
+func (room *Room) rosterFor(newcomer Participant) []Participant {
+	participants := []Participant{}
+	for _, p := range room.roster() {
+		if room.hearsAbout(newcomer, p) {
+			participants = append(participants, p)
+		}
+	}
+	return participants
+}
+
+// Function to decide whether recipient hears about subject joining and
+// leaving, caller must hold listLock. In a webinar viewers are only told
+// about the people on stage and only moderators about viewers, so a large
+// audience coming and going doesn't reach everyone.
+func (room *Room) hearsAbout(recipient, subject Participant) bool {
+	if !room.webinar || subject.Role != RoleViewer {
+		return true
+	}
+	return recipient.Can(PermModerate)
+}
+
+// Function to tell the peers who should hear about it that a participant
+// joined or left
+func (s *SFU) announce(room *Room, event string, subject Participant) {
+	s.send(room, event, subject, func(recipient Participant) bool {
+		return recipient.ID != subject.ID && room.hearsAbout(recipient, subject)
+	})
+}
+
+// Function to send an event with a JSON payload to every open peer in a
+// room but one
+func (s *SFU) broadcast(room *Room, event string, payload any, exceptID string) {
+	s.send(room, event, payload, func(recipient Participant) bool {
+		return recipient.ID != exceptID
+	})
+}
+
+// Function to send an event with a JSON payload to the open peers in a
+// room whose participant passes to, which runs with listLock held
+func (s *SFU) send(room *Room, event string, payload any, to func(Participant) bool) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		room.log.Error("marshal event", "event", event, "err", err)
+		return
+	}
+
+	s.listLock.RLock()
+	var peers []*peerConnectionState
+	for _, peer := range room.peerConnections {
+		if to(peer.participant) && peer.open() {
+			peers = append(peers, peer)
+		}
+	}
+	s.listLock.RUnlock()
+
+	for _, peer := range peers {
+		if err := peer.websocket.WriteJSON(&websocketMessage{Event: event, Data: string(data)}); err != nil {
+			room.log.Debug("send event", "event", event, "participant_id", peer.participant.ID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+	"webrtc/apierror"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestWebinarViewersCannotPublish(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{Webinar: true})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, true)
+	host.publish(webrtc.RTPCodecTypeAudio)
+	host.answer()
+
+	// The viewer has no transceivers to publish on; the one it is offered
+	// to learn its audio codecs must not carry its media either
+	eventually(t, "the host's track", func() bool { return len(publishedBy(sfu, "host")) > 0 })
+	hostTracks := publishedBy(sfu, "host")
+
+	viewer := join(t, sfu, server, Participant{ID: "viewer", Role: RoleViewer}, true)
+	catalog := []trackInfo{}
+	viewer.waitFor("tracks", &catalog)
+	if len(catalog) != 1 || catalog[0].TrackID != hostTracks[0] {
+		t.Errorf("viewer told about %+v, want the host's track", catalog)
+	}
+
+	viewer.publish(webrtc.RTPCodecTypeAudio)
+	viewer.answer()
+	if code := viewer.waitForError(); code != apierror.CodePermissionDenied {
+		t.Fatalf("viewer publishing: %s, want %s", code, apierror.CodePermissionDenied)
+	}
+
+	if tracks := publishedBy(sfu, "viewer"); len(tracks) > 0 {
+		t.Errorf("viewer's tracks %v forwarded", tracks)
+	}
+}
+
+func TestWebinarHidesViewers(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{Webinar: true})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, false)
+	host.waitFor("roster", nil)
+	first := join(t, sfu, server, Participant{ID: "first", Role: RoleViewer}, false)
+	first.waitFor("roster", nil)
+
+	second := join(t, sfu, server, Participant{ID: "second", Role: RoleViewer}, false)
+	roster := []Participant{}
+	second.waitFor("roster", &roster)
+	if len(roster) != 1 || roster[0].ID != "host" {
+		t.Errorf("viewer's roster %+v, want only the host", roster)
+	}
+
+	joined := Participant{}
+	host.waitFor("participant-joined", &joined)
+	if joined.ID != "first" {
+		t.Errorf("host told %q joined, want first", joined.ID)
+	}
+	host.waitFor("participant-joined", &joined)
+	if joined.ID != "second" {
+		t.Errorf("host told %q joined, want second", joined.ID)
+	}
+
+	// Viewers hear about those on stage
+	presenter := join(t, sfu, server, Participant{ID: "presenter", Role: RolePresenter}, false)
+	presenter.waitFor("roster", nil)
+	first.waitFor("participant-joined", &joined)
+	if joined.ID != "presenter" {
+		t.Errorf("viewer told %q joined, want presenter", joined.ID)
+	}
+}
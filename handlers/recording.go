@@ -0,0 +1,50 @@
+package handlers
+
+import "webrtc/apierror"
+
+// Payload of a recording event, sent to everyone in the room when
+// recording starts or stops and to newcomers while it runs. Clients do the
+// recording; the room makes sure everyone knows about it.
+type recordingMessage struct {
+	Active        bool   `json:"active"`
+	ParticipantID string `json:"participant_id,omitempty"` // who records
+}
+
+// Function to start or stop recording the room on behalf of a participant
+// whose role may record
+func (s *SFU) setRecording(room *Room, actorID string, active bool) *apierror.Error {
+	s.listLock.Lock()
+	actor, ok := room.findPeer(actorID)
+	if !ok || !actor.participant.Can(PermRecord) {
+		s.listLock.Unlock()
+		return &apierror.Error{Code: apierror.CodePermissionDenied, Message: "Your role may not record."}
+	}
+	if active == (room.recordedBy != "") {
+		s.listLock.Unlock()
+		return nil
+	}
+	room.recordedBy = ""
+	if active {
+		room.recordedBy = actorID
+	}
+	s.listLock.Unlock()
+
+	room.log.Info("recording changed", "active", active, "by", actorID)
+	s.broadcast(room, "recording", recordingMessage{Active: active, ParticipantID: actorID}, "")
+	return nil
+}
+
+// Function to stop the recording a participant runs because they left or
+// may no longer record, safe to call when they don't
+func (s *SFU) stopRecording(room *Room, participantID string) {
+	s.listLock.Lock()
+	if room.recordedBy != participantID {
+		s.listLock.Unlock()
+		return
+	}
+	room.recordedBy = ""
+	s.listLock.Unlock()
+
+	room.log.Info("recording changed", "active", false, "by", participantID)
+	s.broadcast(room, "recording", recordingMessage{Active: false, ParticipantID: participantID}, "")
+}
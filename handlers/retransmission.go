@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// packetCacheSize - How many of a video track's latest packets are kept
+// for retransmission, about a second at a high bitrate. A power of two so
+// sequence numbers wrap around cleanly.
+const packetCacheSize = 512
+
+// Struct to keep a track's most recent packets, indexed by sequence number
+type packetCache struct {
+	mu      sync.Mutex
+	packets [packetCacheSize]cachedPacket
+}
+
+// Struct to hold one cached packet in the wire form it was received in,
+// with the sequence number and timestamp it was forwarded with
+type cachedPacket struct {
+	sequenceNumber uint16
+	timestamp      uint32
+	data           []byte
+	valid          bool
+}
+
+// Function to keep a copy of a packet as forwarded, replacing the one that
+// came packetCacheSize packets earlier
+func (c *packetCache) store(sequenceNumber uint16, timestamp uint32, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slot := &c.packets[sequenceNumber%packetCacheSize]
+	slot.sequenceNumber = sequenceNumber
+	slot.timestamp = timestamp
+	slot.data = append(slot.data[:0], data...)
+	slot.valid = true
+}
+
+// Function to look up a cached packet by the sequence number it was
+// forwarded with, parsed into a copy of its own
+func (c *packetCache) get(sequenceNumber uint16) (*rtp.Packet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slot := &c.packets[sequenceNumber%packetCacheSize]
+	if !slot.valid || slot.sequenceNumber != sequenceNumber {
+		return nil, false
+	}
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(append([]byte(nil), slot.data...)); err != nil {
+		return nil, false
+	}
+	packet.SequenceNumber = slot.sequenceNumber
+	packet.Timestamp = slot.timestamp
+	return packet, true
+}
+
+// Struct to forward a published track to the peers subscribed to it. Works
+// like pion's TrackLocalStaticRTP, but video keeps its recent packets to
+// resend the ones subscribers report lost.
+type forwardedTrack struct {
+	codec        webrtc.RTPCodecCapability
+	id, streamID string
+	cache        *packetCache // nil for audio, which isn't retransmitted
+
+	mu       sync.RWMutex
+	bindings []*forwardBinding
+	rtxSSRCs map[webrtc.SSRC]webrtc.SSRC // by the SSRC of the sender, see rtxSSRC
+
+	// Sequence numbers and timestamps are rewritten so that a pause leaves
+	// no gap for subscribers to report lost, see rewrite
+	seqMu     sync.Mutex
+	started   bool      // a packet has been forwarded
+	resuming  bool      // packets were skipped since the last one forwarded
+	lastSeq   uint16    // of the newest packet forwarded, as sent
+	lastTS    uint32    // of the newest packet forwarded, as sent
+	lastSent  time.Time // when the newest packet was forwarded
+	seqOffset uint16    // taken off the publisher's sequence numbers
+	tsOffset  uint32    // taken off the publisher's timestamps
+	runStart  uint16    // the publisher's sequence number the current run began with
+}
+
+// maxRunAge - How far behind the newest packet the start of a run is
+// kept, so comparing sequence numbers against it survives wrap-around.
+const maxRunAge = 1 << 14
+
+// Struct to hold what a peer connection negotiated for a forwarded track
+type forwardBinding struct {
+	id             string
+	ssrc           webrtc.SSRC
+	payloadType    webrtc.PayloadType
+	rtxSSRC        webrtc.SSRC        // 0 without RTX
+	rtxPayloadType webrtc.PayloadType // 0 without RTX
+	writeStream    webrtc.TrackLocalWriter
+
+	mu          sync.Mutex
+	rtxSequence uint16
+}
+
+// Function to create a track forwarding codec
+func newForwardedTrack(codec webrtc.RTPCodecCapability, id, streamID string) *forwardedTrack {
+	track := &forwardedTrack{
+		codec:    codec,
+		id:       id,
+		streamID: streamID,
+		rtxSSRCs: make(map[webrtc.SSRC]webrtc.SSRC),
+	}
+	if strings.HasPrefix(strings.ToLower(codec.MimeType), "video/") {
+		track.cache = &packetCache{}
+	}
+	return track
+}
+
+// Bind - Sets the track up for a peer connection that negotiated it, with
+// RTX when the peer accepted it too.
+func (f *forwardedTrack) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	codecs := t.CodecParameters()
+	codec, ok := matchCodec(f.codec, codecs)
+	if !ok {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	binding := &forwardBinding{
+		id:          t.ID(),
+		ssrc:        t.SSRC(),
+		payloadType: codec.PayloadType,
+		writeStream: t.WriteStream(),
+	}
+	if rtxSSRC, ok := f.rtxSSRCs[t.SSRC()]; ok {
+		for _, rtx := range codecs {
+			if strings.EqualFold(rtx.MimeType, mimeTypeRTX) && rtx.SDPFmtpLine == fmt.Sprintf("apt=%d", codec.PayloadType) {
+				binding.rtxSSRC, binding.rtxPayloadType = rtxSSRC, rtx.PayloadType
+			}
+		}
+	}
+	f.bindings = append(f.bindings, binding)
+	return codec, nil
+}
+
+// Unbind - Forgets a peer connection that stopped receiving the track.
+func (f *forwardedTrack) Unbind(t webrtc.TrackLocalContext) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.rtxSSRCs, t.SSRC())
+	for i, binding := range f.bindings {
+		if binding.id == t.ID() {
+			f.bindings = append(f.bindings[:i], f.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return webrtc.ErrUnbindFailed
+}
+
+// ID - The track ID, the same as the published track's.
+func (f *forwardedTrack) ID() string { return f.id }
+
+// RID - Forwarded tracks are not simulcast.
+func (f *forwardedTrack) RID() string { return "" }
+
+// StreamID - The stream ID, the same as the published track's.
+func (f *forwardedTrack) StreamID() string { return f.streamID }
+
+// Kind - Audio or video, from the codec.
+func (f *forwardedTrack) Kind() webrtc.RTPCodecType {
+	return webrtc.NewRTPCodecType(strings.SplitN(f.codec.MimeType, "/", 2)[0])
+}
+
+// Function to pick the negotiated codec a track in codec is sent as: the
+// one with the same parameters, else one they are compatible with
+func matchCodec(codec webrtc.RTPCodecCapability, negotiated []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	var partial *webrtc.RTPCodecParameters
+	for i, c := range negotiated {
+		if !strings.EqualFold(c.MimeType, codec.MimeType) {
+			continue
+		}
+		if c.SDPFmtpLine == codec.SDPFmtpLine {
+			return c, true
+		}
+		if partial == nil && fmtpCompatible(codec.MimeType, c.SDPFmtpLine, codec.SDPFmtpLine) {
+			partial = &negotiated[i]
+		}
+	}
+	if partial == nil {
+		return webrtc.RTPCodecParameters{}, false
+	}
+	return *partial, true
+}
+
+// Function to note that a packet of the publisher's wasn't forwarded, as
+// the track is paused. The next one forwarded continues the sequence.
+func (f *forwardedTrack) skip() {
+	f.seqMu.Lock()
+	defer f.seqMu.Unlock()
+	f.resuming = f.started
+}
+
+// Function to rewrite a packet received from the publisher into the
+// sequence subscribers get. Packets after a pause continue where the last
+// one forwarded left off, their timestamps moved on by the time since.
+// Reports false for a late packet from before the pause, which must not
+// be forwarded.
+func (f *forwardedTrack) rewrite(packet *rtp.Packet, now time.Time) bool {
+	f.seqMu.Lock()
+	defer f.seqMu.Unlock()
+
+	switch {
+	case !f.started:
+		f.started = true
+		f.runStart = packet.SequenceNumber
+		f.lastSeq = packet.SequenceNumber - 1
+	case f.resuming:
+		f.resuming = false
+		ticks := uint32(now.Sub(f.lastSent).Seconds() * float64(f.codec.ClockRate))
+		f.seqOffset = packet.SequenceNumber - (f.lastSeq + 1)
+		f.tsOffset = packet.Timestamp - (f.lastTS + max(ticks, 1))
+		f.runStart = packet.SequenceNumber
+	case int16(packet.SequenceNumber-f.runStart) < 0:
+		return false
+	}
+
+	packet.SequenceNumber -= f.seqOffset
+	packet.Timestamp -= f.tsOffset
+	if int16(packet.SequenceNumber-f.lastSeq) > 0 {
+		f.lastSeq = packet.SequenceNumber
+		f.lastTS = packet.Timestamp
+		f.lastSent = now
+		if age := f.lastSeq + f.seqOffset - f.runStart; age > maxRunAge {
+			f.runStart = f.lastSeq + f.seqOffset - maxRunAge
+		}
+	}
+	return true
+}
+
+// Function to translate sequence numbers as subscribers got them back to
+// the publisher's, leaving out the ones forwarded before the latest pause,
+// which the publisher numbered differently
+func (f *forwardedTrack) publisherSequenceNumbers(sequenceNumbers []uint16) []uint16 {
+	f.seqMu.Lock()
+	defer f.seqMu.Unlock()
+
+	runStart := f.runStart - f.seqOffset
+	var translated []uint16
+	for _, sequenceNumber := range sequenceNumbers {
+		if int16(sequenceNumber-runStart) >= 0 && int16(f.lastSeq-sequenceNumber) >= 0 {
+			translated = append(translated, sequenceNumber+f.seqOffset)
+		}
+	}
+	return translated
+}
+
+// Function to keep a forwarded packet for retransmission, data being the
+// wire form it was received in. The packet must have been rewritten.
+func (f *forwardedTrack) remember(packet *rtp.Packet, data []byte) {
+	if f.cache != nil {
+		f.cache.store(packet.SequenceNumber, packet.Timestamp, data)
+	}
+}
+
+// Function to send a packet to every peer connection bound to the track.
+// The packet's SSRC and payload type are rewritten for each.
+func (f *forwardedTrack) writeRTP(packet *rtp.Packet) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var errs []error
+	for _, binding := range f.bindings {
+		packet.SSRC = uint32(binding.ssrc)
+		packet.PayloadType = uint8(binding.payloadType)
+		if _, err := binding.writeStream.WriteRTP(&packet.Header, packet.Payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("forward %s: %v", f.id, errs)
+	}
+	return nil
+}
+
+// Function to resend lost packets to the peer connection that receives the
+// track as ssrc, in RTX packets if it negotiated RTX. Returns the sequence
+// numbers no longer in the cache.
+func (f *forwardedTrack) retransmit(ssrc webrtc.SSRC, sequenceNumbers []uint16) []uint16 {
+	if f.cache == nil {
+		return nil
+	}
+
+	f.mu.RLock()
+	var binding *forwardBinding
+	for _, b := range f.bindings {
+		if b.ssrc == ssrc {
+			binding = b
+		}
+	}
+	f.mu.RUnlock()
+	if binding == nil {
+		return nil
+	}
+
+	var missing []uint16
+	for _, sequenceNumber := range sequenceNumbers {
+		packet, ok := f.cache.get(sequenceNumber)
+		if !ok {
+			missing = append(missing, sequenceNumber)
+			continue
+		}
+		_ = binding.resend(packet)
+	}
+	return missing
+}
+
+// Function to send a cached packet again. As RTX (RFC 4588) it goes out on
+// the RTX SSRC with its own sequence number, the original one leading the
+// payload.
+func (b *forwardBinding) resend(packet *rtp.Packet) error {
+	if b.rtxSSRC == 0 {
+		packet.SSRC = uint32(b.ssrc)
+		packet.PayloadType = uint8(b.payloadType)
+		_, err := b.writeStream.WriteRTP(&packet.Header, packet.Payload)
+		return err
+	}
+
+	payload := make([]byte, 2+len(packet.Payload))
+	binary.BigEndian.PutUint16(payload, packet.SequenceNumber)
+	copy(payload[2:], packet.Payload)
+
+	b.mu.Lock()
+	packet.SequenceNumber = b.rtxSequence
+	b.rtxSequence++
+	b.mu.Unlock()
+
+	packet.SSRC = uint32(b.rtxSSRC)
+	packet.PayloadType = uint8(b.rtxPayloadType)
+	packet.Padding = false
+	packet.PaddingSize = 0
+	_, err := b.writeStream.WriteRTP(&packet.Header, payload)
+	return err
+}
+
+// Function to return the SSRC RTX packets for the peer connection sending
+// the track as ssrc go out on, picking one the first time. pion doesn't
+// give senders an RTX SSRC, so addRTXSSRCs announces it in the offers the
+// peer gets.
+func (f *forwardedTrack) rtxSSRC(ssrc webrtc.SSRC) webrtc.SSRC {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rtxSSRC, ok := f.rtxSSRCs[ssrc]
+	if !ok {
+		rtxSSRC = webrtc.SSRC(rand.Uint32())
+		f.rtxSSRCs[ssrc] = rtxSSRC
+	}
+	return rtxSSRC
+}
+
+// Function to announce an RTX SSRC for each forwarded video track in an
+// offer, grouped with the track's own SSRC as RFC 4588 describes, so the
+// peer can take retransmissions when it accepts RTX
+func addRTXSSRCs(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	rtxSSRCs := map[webrtc.SSRC]webrtc.SSRC{}
+	for _, sender := range pc.GetSenders() {
+		track, ok := sender.Track().(*forwardedTrack)
+		if !ok || track.cache == nil {
+			continue
+		}
+		for _, encoding := range sender.GetParameters().Encodings {
+			rtxSSRCs[encoding.SSRC] = track.rtxSSRC(encoding.SSRC)
+		}
+	}
+	if len(rtxSSRCs) == 0 {
+		return offer, nil
+	}
+
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return offer, err
+	}
+	for _, media := range parsed.MediaDescriptions {
+		for _, attribute := range media.Attributes {
+			// pion announces a sender's SSRC as "<ssrc> msid:<stream ID> <track ID>"
+			var ssrc uint32
+			var streamID, trackID string
+			if attribute.Key != "ssrc" {
+				continue
+			}
+			if _, err := fmt.Sscanf(attribute.Value, "%d msid:%s %s", &ssrc, &streamID, &trackID); err != nil {
+				continue
+			}
+			rtxSSRC, ok := rtxSSRCs[webrtc.SSRC(ssrc)]
+			if !ok {
+				continue
+			}
+			media.WithValueAttribute("ssrc-group", fmt.Sprintf("FID %d %d", ssrc, rtxSSRC)).
+				WithMediaSource(uint32(rtxSSRC), streamID, streamID, trackID)
+			break
+		}
+	}
+
+	data, err := parsed.Marshal()
+	if err != nil {
+		return offer, err
+	}
+	offer.SDP = string(data)
+	return offer, nil
+}
+
+// Function to answer the feedback a subscriber sends about a forwarded
+// track until the sender stops: lost packets are resent from the cache,
+// and the ones it no longer holds are asked of the publisher if it sent
+// them since the track was last paused.
+func (s *SFU) readFeedback(sender *webrtc.RTPSender, track *forwardedTrack, published *publishedTrack) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			nack, ok := packet.(*rtcp.TransportLayerNack)
+			if !ok {
+				continue
+			}
+
+			var lost []uint16
+			for _, pair := range nack.Nacks {
+				lost = append(lost, pair.PacketList()...)
+			}
+			missing := track.retransmit(webrtc.SSRC(nack.MediaSSRC), lost)
+			if upstream := track.publisherSequenceNumbers(missing); len(upstream) > 0 {
+				published.requestRetransmission(upstream)
+			}
+		}
+	}
+}
+
+// Function to ask the publisher to resend packets of the track
+func (p *publishedTrack) requestRetransmission(sequenceNumbers []uint16) {
+	_ = p.publisher.WriteRTCP([]rtcp.Packet{
+		&rtcp.TransportLayerNack{
+			MediaSSRC: uint32(p.ssrc),
+			Nacks:     rtcp.NackPairsFromSequenceNumbers(sequenceNumbers),
+		},
+	})
+}
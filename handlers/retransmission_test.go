@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Struct to record what a forwarded track writes to a peer connection
+type recordingWriter struct {
+	packets []rtp.Packet
+}
+
+func (w *recordingWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	w.packets = append(w.packets, rtp.Packet{Header: header.Clone(), Payload: slices.Clone(payload)})
+	return len(payload), nil
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+// Function to create a VP8 track bound to a recording writer as ssrc
+func newTestTrack(ssrc, rtxSSRC webrtc.SSRC) (*forwardedTrack, *recordingWriter) {
+	track := newForwardedTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, "cam", "stream")
+	writer := &recordingWriter{}
+	binding := &forwardBinding{id: "binding", ssrc: ssrc, payloadType: 96, writeStream: writer}
+	if rtxSSRC != 0 {
+		binding.rtxSSRC, binding.rtxPayloadType = rtxSSRC, 97
+	}
+	track.bindings = append(track.bindings, binding)
+	return track, writer
+}
+
+// Function to send a publisher's packet through a track as the read loop
+// does, reporting whether it was forwarded
+func forward(t *testing.T, track *forwardedTrack, sequenceNumber uint16, timestamp uint32, now time.Time) bool {
+	t.Helper()
+	packet := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: sequenceNumber, Timestamp: timestamp, SSRC: 1234, PayloadType: 100},
+		Payload: []byte{byte(sequenceNumber), 0xaa},
+	}
+	data, err := packet.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !track.rewrite(packet, now) {
+		return false
+	}
+	track.remember(packet, data)
+	if err := track.writeRTP(packet); err != nil {
+		t.Fatal(err)
+	}
+	return true
+}
+
+func TestPacketCache(t *testing.T) {
+	cache := &packetCache{}
+	packet := rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 7, Timestamp: 700}, Payload: []byte{1, 2, 3}}
+	data, _ := packet.Marshal()
+
+	cache.store(10, 1000, data)
+	got, ok := cache.get(10)
+	if !ok {
+		t.Fatal("stored packet not found")
+	}
+	if got.SequenceNumber != 10 || got.Timestamp != 1000 || !slices.Equal(got.Payload, []byte{1, 2, 3}) {
+		t.Errorf("got %v, want sequence number 10, timestamp 1000 and the original payload", got)
+	}
+
+	got.Payload[0] = 9
+	if again, _ := cache.get(10); again.Payload[0] != 1 {
+		t.Error("changing a looked up packet changed the cache")
+	}
+
+	cache.store(10+packetCacheSize, 2000, data)
+	if _, ok := cache.get(10); ok {
+		t.Error("packet still found after its slot was reused")
+	}
+	if _, ok := cache.get(11); ok {
+		t.Error("found a packet that was never stored")
+	}
+}
+
+func TestRewriteClosesPauseGap(t *testing.T) {
+	track, writer := newTestTrack(5000, 0)
+	start := time.Now()
+
+	first := uint16(65534) // wraps around
+	for i := uint16(0); i < 5; i++ {
+		forward(t, track, first+i, 90000+uint32(i)*3000, start.Add(time.Duration(i)*33*time.Millisecond))
+	}
+	for i := uint16(5); i < 100; i++ {
+		track.skip()
+	}
+	resumed := start.Add(4*33*time.Millisecond + time.Second)
+	if !forward(t, track, first+100, 90000+100*3000, resumed) {
+		t.Fatal("first packet after the pause was not forwarded")
+	}
+	if forward(t, track, first+50, 90000+50*3000, resumed) {
+		t.Error("late packet from the pause was forwarded")
+	}
+
+	var sequenceNumbers []uint16
+	for _, packet := range writer.packets {
+		sequenceNumbers = append(sequenceNumbers, packet.SequenceNumber)
+		if packet.SSRC != 5000 || packet.PayloadType != 96 {
+			t.Errorf("packet sent as SSRC %d and payload type %d, want 5000 and 96", packet.SSRC, packet.PayloadType)
+		}
+	}
+	if want := []uint16{65534, 65535, 0, 1, 2, 3}; !slices.Equal(sequenceNumbers, want) {
+		t.Errorf("sent sequence numbers %v, want %v", sequenceNumbers, want)
+	}
+
+	last := writer.packets[len(writer.packets)-1].Timestamp - writer.packets[len(writer.packets)-2].Timestamp
+	if last < 89000 || last > 91000 {
+		t.Errorf("timestamp moved on %d over the one second pause, want about 90000", last)
+	}
+}
+
+func TestPublisherSequenceNumbers(t *testing.T) {
+	track, _ := newTestTrack(5000, 0)
+	now := time.Now()
+	for seq := uint16(100); seq < 105; seq++ {
+		forward(t, track, seq, uint32(seq)*3000, now)
+	}
+	track.skip()
+	for seq := uint16(200); seq < 205; seq++ {
+		forward(t, track, seq, uint32(seq)*3000, now)
+	}
+
+	// 100 to 104 kept their numbers, 200 to 204 were sent as 105 to 109
+	got := track.publisherSequenceNumbers([]uint16{102, 105, 107, 109, 110})
+	if want := []uint16{200, 202, 204}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRetransmitWithRTX(t *testing.T) {
+	track, writer := newTestTrack(5000, 6000)
+	now := time.Now()
+	for seq := uint16(100); seq < 110; seq++ {
+		forward(t, track, seq, uint32(seq)*3000, now)
+	}
+	writer.packets = nil
+
+	missing := track.retransmit(5000, []uint16{103, 105, 600})
+	if !slices.Equal(missing, []uint16{600}) {
+		t.Errorf("missing %v, want [600]", missing)
+	}
+	if len(writer.packets) != 2 {
+		t.Fatalf("resent %d packets, want 2", len(writer.packets))
+	}
+	for i, original := range []uint16{103, 105} {
+		packet := writer.packets[i]
+		if packet.SSRC != 6000 || packet.PayloadType != 97 {
+			t.Errorf("RTX packet sent as SSRC %d and payload type %d, want 6000 and 97", packet.SSRC, packet.PayloadType)
+		}
+		if packet.SequenceNumber != uint16(i) {
+			t.Errorf("RTX packet has sequence number %d, want %d", packet.SequenceNumber, i)
+		}
+		if osn := binary.BigEndian.Uint16(packet.Payload); osn != original {
+			t.Errorf("RTX packet carries sequence number %d, want %d", osn, original)
+		}
+		if !slices.Equal(packet.Payload[2:], []byte{byte(original), 0xaa}) {
+			t.Errorf("RTX packet carries payload %v", packet.Payload[2:])
+		}
+		if packet.Timestamp != uint32(original)*3000 {
+			t.Errorf("RTX packet has timestamp %d, want %d", packet.Timestamp, uint32(original)*3000)
+		}
+	}
+
+	if missing := track.retransmit(4000, []uint16{103}); missing != nil {
+		t.Errorf("retransmitted to an unknown SSRC, missing %v", missing)
+	}
+}
+
+func TestRetransmitWithoutRTX(t *testing.T) {
+	track, writer := newTestTrack(5000, 0)
+	now := time.Now()
+	for seq := uint16(100); seq < 103; seq++ {
+		forward(t, track, seq, uint32(seq)*3000, now)
+	}
+	writer.packets = nil
+
+	track.retransmit(5000, []uint16{101})
+	if len(writer.packets) != 1 {
+		t.Fatalf("resent %d packets, want 1", len(writer.packets))
+	}
+	if packet := writer.packets[0]; packet.SSRC != 5000 || packet.PayloadType != 96 || packet.SequenceNumber != 101 {
+		t.Errorf("resent as SSRC %d, payload type %d, sequence number %d, want 5000, 96 and 101", packet.SSRC, packet.PayloadType, packet.SequenceNumber)
+	}
+}
+
+func TestAudioIsNotCached(t *testing.T) {
+	track := newForwardedTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}, "mic", "stream")
+	if track.cache != nil {
+		t.Error("audio track keeps packets for retransmission")
+	}
+	if missing := track.retransmit(5000, []uint16{1}); missing != nil {
+		t.Errorf("audio retransmission reported missing %v", missing)
+	}
+}
+
+func TestNackIsAnsweredOverRTX(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, true)
+	host.publish(webrtc.RTPCodecTypeVideo)
+	host.answer()
+
+	bob := join(t, sfu, server, Participant{ID: "bob", Role: RolePresenter}, false)
+	type reception struct {
+		track          *webrtc.TrackRemote
+		sequenceNumber uint16
+	}
+	var mu sync.Mutex
+	counts := map[uint16]int{}
+	first := make(chan reception, 1)
+	bob.pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			packet, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			counts[packet.SequenceNumber]++
+			mu.Unlock()
+			select {
+			case first <- reception{track, packet.SequenceNumber}:
+			default:
+			}
+		}
+	})
+
+	var received reception
+	select {
+	case received = <-first:
+	case <-time.After(eventTimeout):
+		t.Fatal("bob got no video")
+	}
+	if !received.track.HasRTX() {
+		t.Error("the SFU didn't offer bob an RTX stream")
+	}
+
+	// Bob reports a packet lost that he in fact got, so the resent copy
+	// shows up as a second one
+	nack := &rtcp.TransportLayerNack{
+		MediaSSRC: uint32(received.track.SSRC()),
+		Nacks:     rtcp.NackPairsFromSequenceNumbers([]uint16{received.sequenceNumber}),
+	}
+	if err := bob.pc.WriteRTCP([]rtcp.Packet{nack}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the packet resent", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[received.sequenceNumber] == 2
+	})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Roles a participant can have in a room.
+const (
+	RoleHost      = "host"
+	RoleCoHost    = "co-host"
+	RolePresenter = "presenter"
+	RoleViewer    = "viewer"
+)
+
+// Permission - Something a role allows a participant to do in a room.
+type Permission string
+
+// Permissions granted by roles.
+const (
+	PermPublishAudio  Permission = "publish_audio"
+	PermPublishVideo  Permission = "publish_video"
+	PermPublishScreen Permission = "publish_screen"
+	PermSubscribe     Permission = "subscribe"
+	PermModerate      Permission = "moderate" // change roles and remove participants
+	PermRecord        Permission = "record"
+)
+
+// rolePermissions - What each role may do. A role that isn't listed may do
+// nothing.
+var rolePermissions = map[string][]Permission{
+	RoleHost:      {PermPublishAudio, PermPublishVideo, PermPublishScreen, PermSubscribe, PermModerate, PermRecord},
+	RoleCoHost:    {PermPublishAudio, PermPublishVideo, PermPublishScreen, PermSubscribe, PermModerate},
+	RolePresenter: {PermPublishAudio, PermPublishVideo, PermPublishScreen, PermSubscribe},
+	RoleViewer:    {PermSubscribe},
+}
+
+// Sources a published track can come from. Clients announce screen shares
+// with a track-source message; other tracks count as camera or microphone.
+const (
+	SourceAudio  = "audio"
+	SourceVideo  = "video"
+	SourceScreen = "screen"
+)
+
+// sourcePermissions - The permission needed to publish from each source.
+var sourcePermissions = map[string]Permission{
+	SourceAudio:  PermPublishAudio,
+	SourceVideo:  PermPublishVideo,
+	SourceScreen: PermPublishScreen,
+}
+
+// ValidRole - Reports whether role is one of the room roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can - Reports whether the participant's role grants perm.
+func (p Participant) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+// CanPublish - Reports whether the participant may publish from source.
+func (p Participant) CanPublish(source string) bool {
+	perm, ok := sourcePermissions[source]
+	return ok && p.Can(perm)
+}
+
+// MarshalJSON - Includes the role's permissions so clients can show only
+// the controls a participant may use.
+func (p Participant) MarshalJSON() ([]byte, error) {
+	type participant Participant
+	permissions := rolePermissions[p.Role]
+	if permissions == nil {
+		permissions = []Permission{}
+	}
+	return json.Marshal(struct {
+		participant
+		Permissions []Permission `json:"permissions"`
+	}{participant(p), permissions})
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"maps"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// silenceLevel - Audio levels, in -dBov, at or above this count as
+	// silence. Speech is usually well under 60.
+	silenceLevel = 70
+	// speakerSmoothing - Time constant of the running loudness average, so
+	// a cough or a single loud packet doesn't take the floor.
+	speakerSmoothing = 400 * time.Millisecond
+	// speakerHold - How long a speaker keeps the floor before someone else
+	// can take it.
+	speakerHold = time.Second
+	// speakerMargin - How much louder than the current speaker someone must
+	// be to take the floor.
+	speakerMargin = 1.25
+	// minSpeakerEnergy - The least average loudness that counts as
+	// speaking at all.
+	minSpeakerEnergy = 5
+)
+
+// Payload of an active-speaker event.
+type activeSpeakerMessage struct {
+	ParticipantID string `json:"participant_id"`
+}
+
+// Struct to track how loud each participant in a room has been lately and
+// who holds the floor
+type speakerDetector struct {
+	mu      sync.Mutex
+	levels  map[string]*voiceActivity // by participant ID
+	spoke   map[string]time.Time      // when each participant last took the floor
+	current string                    // participant ID of the active speaker
+	since   time.Time                 // when current took the floor
+}
+
+// Struct to hold a participant's running loudness average
+type voiceActivity struct {
+	energy  float64 // 0 for silence up to 127 for the loudest audio
+	updated time.Time
+}
+
+// Function to move the average towards loudness as of now
+func (v *voiceActivity) decay(loudness float64, now time.Time) {
+	weight := math.Exp(-float64(now.Sub(v.updated)) / float64(speakerSmoothing))
+	v.energy = v.energy*weight + loudness*(1-weight)
+	v.updated = now
+}
+
+// Function to record the audio level of a packet from a participant
+func (d *speakerDetector) observe(participantID string, level uint8, now time.Time) {
+	loudness := 0.0
+	if level < silenceLevel {
+		loudness = float64(silenceLevel - level)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	activity, ok := d.levels[participantID]
+	if !ok {
+		activity = &voiceActivity{updated: now}
+		d.levels[participantID] = activity
+	}
+	activity.decay(loudness, now)
+}
+
+// Function to pick the dominant speaker. Reports the new speaker and true
+// when the floor changed hands.
+func (d *speakerDetector) evaluate(now time.Time) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Participants who stopped sending packets fade out as silent
+	best, bestEnergy := "", 0.0
+	for participantID, activity := range d.levels {
+		activity.decay(0, now)
+		if activity.energy > bestEnergy {
+			best, bestEnergy = participantID, activity.energy
+		}
+	}
+
+	if best == "" || best == d.current || bestEnergy < minSpeakerEnergy {
+		return d.current, false
+	}
+	if current, ok := d.levels[d.current]; ok {
+		if now.Sub(d.since) < speakerHold || bestEnergy < current.energy*speakerMargin {
+			return d.current, false
+		}
+	}
+
+	d.current, d.since = best, now
+	d.spoke[best] = now
+	return best, true
+}
+
+// Function to return the participant ID of the active speaker, if any
+func (d *speakerDetector) speaker() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current
+}
+
+// Function to return when each participant last took the floor
+func (d *speakerDetector) recency() map[string]time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return maps.Clone(d.spoke)
+}
+
+// Function to stop tracking a participant who left. The floor goes to
+// whoever speaks next.
+func (d *speakerDetector) forget(participantID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.levels, participantID)
+	delete(d.spoke, participantID)
+	if d.current == participantID {
+		d.current = ""
+	}
+}
+
+// DetectSpeakers - Re-evaluates the active speaker of every room, telling
+// the room when it changes and sending the new speaker's video to the
+// peers that follow the speaker.
+func (s *SFU) DetectSpeakers() {
+	now := time.Now()
+
+	s.listLock.RLock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.listLock.RUnlock()
+
+	for _, room := range rooms {
+		speaker, changed := room.speakers.evaluate(now)
+		if !changed {
+			continue
+		}
+
+		room.log.Debug("active speaker changed", "participant_id", speaker)
+		s.broadcast(room, "active-speaker", activeSpeakerMessage{ParticipantID: speaker}, "")
+		s.updateLastN(room)
+
+		s.listLock.RLock()
+		for _, peer := range room.peerConnections {
+			if peer.subscriptions.followsSpeaker() {
+				peer.requestNegotiation()
+			}
+		}
+		s.listLock.RUnlock()
+	}
+}
+
+// Function to find the ID the peer connection negotiated for the RFC 6464
+// audio level header extension. Zero means it wasn't negotiated.
+func audioLevelExtensionID(receiver *webrtc.RTPReceiver) uint8 {
+	for _, extension := range receiver.GetParameters().HeaderExtensions {
+		if extension.URI == sdp.AudioLevelURI {
+			return uint8(extension.ID)
+		}
+	}
+	return 0
+}
+
+// Function to read the audio level extension of an RTP packet, if it was
+// negotiated
+func readAudioLevel(header *rtp.Header, extensionID uint8) (uint8, bool) {
+	if extensionID == 0 {
+		return 0, false
+	}
+	payload := header.GetExtension(extensionID)
+	if payload == nil {
+		return 0, false
+	}
+
+	var audioLevel rtp.AudioLevelExtension
+	if err := audioLevel.Unmarshal(payload); err != nil {
+		return 0, false
+	}
+	return audioLevel.Level, true
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Function to create an empty speaker detector as rooms have
+func newTestDetector() *speakerDetector {
+	return &speakerDetector{levels: make(map[string]*voiceActivity), spoke: make(map[string]time.Time)}
+}
+
+// Function to feed a participant's audio levels, one packet every 20ms
+// from start for duration, returning when the last packet arrived
+func speak(d *speakerDetector, participantID string, level uint8, start time.Time, duration time.Duration) time.Time {
+	now := start
+	for ; now.Before(start.Add(duration)); now = now.Add(20 * time.Millisecond) {
+		d.observe(participantID, level, now)
+	}
+	return now
+}
+
+func TestSpeakerNeedsSustainedSpeech(t *testing.T) {
+	d := newTestDetector()
+	start := time.Now()
+
+	// A cough is two loud packets
+	now := speak(d, "alice", 0, start, 40*time.Millisecond)
+	if speaker, changed := d.evaluate(now); changed || speaker != "" {
+		t.Fatalf("cough gave %q the floor", speaker)
+	}
+
+	// Background noise is too quiet to count
+	now = speak(d, "bob", silenceLevel, now, 2*time.Second)
+	if speaker, changed := d.evaluate(now); changed || speaker != "" {
+		t.Fatalf("silence gave %q the floor", speaker)
+	}
+
+	now = speak(d, "alice", 30, now, time.Second)
+	if speaker, changed := d.evaluate(now); !changed || speaker != "alice" {
+		t.Fatalf("evaluate = %q, %v, want alice taking the floor", speaker, changed)
+	}
+	if speaker, changed := d.evaluate(now); changed || speaker != "alice" {
+		t.Errorf("evaluate again = %q, %v, want alice unchanged", speaker, changed)
+	}
+	if d.speaker() != "alice" {
+		t.Errorf("speaker %q, want alice", d.speaker())
+	}
+	if _, ok := d.recency()["alice"]; !ok {
+		t.Error("alice's turn not remembered")
+	}
+}
+
+func TestSpeakerHoldsTheFloor(t *testing.T) {
+	d := newTestDetector()
+	took := speak(d, "alice", 30, time.Now(), time.Second)
+	if speaker, _ := d.evaluate(took); speaker != "alice" {
+		t.Fatalf("speaker %q, want alice", speaker)
+	}
+
+	// Bob is louder at once, but alice keeps the floor for speakerHold
+	now := speak(d, "bob", 10, took, speakerHold/2)
+	if speaker, changed := d.evaluate(now); changed || speaker != "alice" {
+		t.Fatalf("evaluate = %q, %v within the hold, want alice", speaker, changed)
+	}
+
+	now = speak(d, "bob", 10, now, speakerHold)
+	if speaker, changed := d.evaluate(now); !changed || speaker != "bob" {
+		t.Errorf("evaluate = %q, %v after the hold, want bob", speaker, changed)
+	}
+}
+
+func TestSpeakerNeedsMargin(t *testing.T) {
+	d := newTestDetector()
+	took := speak(d, "alice", 30, time.Now(), time.Second)
+	d.evaluate(took)
+
+	// Both talk, bob only slightly louder
+	var now time.Time
+	for now = took; now.Before(took.Add(3 * time.Second)); now = now.Add(20 * time.Millisecond) {
+		d.observe("alice", 30, now)
+		d.observe("bob", 28, now)
+	}
+	if speaker, changed := d.evaluate(now); changed || speaker != "alice" {
+		t.Errorf("evaluate = %q, %v, want alice keeping the floor", speaker, changed)
+	}
+}
+
+func TestSpeakerForget(t *testing.T) {
+	d := newTestDetector()
+	now := speak(d, "alice", 30, time.Now(), time.Second)
+	d.evaluate(now)
+
+	d.forget("alice")
+	if d.speaker() != "" {
+		t.Errorf("speaker %q after leaving", d.speaker())
+	}
+	if speaker, changed := d.evaluate(now); changed || speaker != "" {
+		t.Errorf("evaluate = %q, %v, want nobody", speaker, changed)
+	}
+}
+
+func TestReadAudioLevel(t *testing.T) {
+	payload, err := (&rtp.AudioLevelExtension{Level: 42, Voice: true}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &rtp.Header{}
+	if err := header.SetExtension(3, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if level, ok := readAudioLevel(header, 3); !ok || level != 42 {
+		t.Errorf("readAudioLevel = %d, %v, want 42", level, ok)
+	}
+	if _, ok := readAudioLevel(header, 0); ok {
+		t.Error("level read without the extension negotiated")
+	}
+	if _, ok := readAudioLevel(header, 4); ok {
+		t.Error("level read from a missing extension")
+	}
+}
+
+func TestDetectSpeakersAnnouncesSpeaker(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, false)
+	host.waitFor("roster", nil)
+
+	sfu.listLock.RLock()
+	room := sfu.rooms[testRoomID]
+	sfu.listLock.RUnlock()
+	speak(&room.speakers, "host", 30, time.Now().Add(-time.Second), time.Second)
+
+	sfu.DetectSpeakers()
+	message := activeSpeakerMessage{}
+	host.waitFor("active-speaker", &message)
+	if message.ParticipantID != "host" {
+		t.Errorf("active speaker %q, want host", message.ParticipantID)
+	}
+}
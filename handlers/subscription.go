@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"webrtc/audio"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Payload of subscribe and unsubscribe messages. All applies to every
+// track, including ones published later, and clears earlier choices.
+// Speaker follows the camera video of whoever is the active speaker, on
+// top of the other choices. MixedAudio replaces the audio tracks with one
+// track the server mixes from everyone else's audio. It is refused unless
+// the mixer can decode every audio codec the session lets publishers use,
+// so Opus, which needs a decoder to be registered, must be left out of the
+// session's codecs.
+type subscriptionMessage struct {
+	TrackIDs   []string `json:"track_ids"`
+	All        bool     `json:"all"`
+	Speaker    bool     `json:"speaker"`
+	MixedAudio bool     `json:"mixed_audio"`
+}
+
+// Payload describing a published track, sent in tracks, track-published,
+// track-unpublished and track-unsupported events so clients know what they
+// can subscribe to.
+type trackInfo struct {
+	TrackID       string `json:"track_id"`
+	StreamID      string `json:"stream_id"`
+	ParticipantID string `json:"participant_id"`
+	Kind          string `json:"kind"`
+	Codec         string `json:"codec"` // MIME type, e.g. video/VP8
+	Source        string `json:"source"`
+}
+
+// Struct to remember which tracks a peer chose to receive. A peer starts
+// out receiving everything; tracks it never chose either way follow all.
+type subscriptions struct {
+	mu      sync.Mutex
+	all     bool
+	speaker bool            // follows the active speaker's video
+	mixed   bool            // gets mixed audio instead of mixable audio tracks
+	choices map[string]bool // by track ID, true when subscribed
+}
+
+// Function to create subscriptions to every track
+func newSubscriptions() *subscriptions {
+	return &subscriptions{all: true, choices: make(map[string]bool)}
+}
+
+// Function to record a subscribe or unsubscribe request
+func (s *subscriptions) update(request subscriptionMessage, subscribe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if request.All {
+		s.all = subscribe
+		clear(s.choices)
+	}
+	if request.Speaker {
+		s.speaker = subscribe
+	}
+	if request.MixedAudio {
+		s.mixed = subscribe
+	}
+	for _, trackID := range request.TrackIDs {
+		s.choices[trackID] = subscribe
+	}
+}
+
+// Function to report whether the peer follows the active speaker
+func (s *subscriptions) followsSpeaker() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.speaker
+}
+
+// Function to report whether the peer gets mixed audio
+func (s *subscriptions) mixedAudio() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mixed
+}
+
+// Function to pick the tracks the peer wants out of those in the room,
+// given the participant ID of the active speaker. Choices for tracks no
+// longer in the room are forgotten.
+func (s *subscriptions) filter(available map[string]*publishedTrack, speakerID string) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for trackID := range s.choices {
+		if _, ok := available[trackID]; !ok {
+			delete(s.choices, trackID)
+		}
+	}
+
+	wanted := make(map[string]bool, len(available))
+	for trackID, published := range available {
+		if s.mixed && published.mixable() {
+			wanted[trackID] = false
+			continue
+		}
+		subscribed, chosen := s.choices[trackID]
+		speaking := s.speaker && published.participantID == speakerID && published.source == SourceVideo
+		wanted[trackID] = subscribed || (!chosen && s.all) || speaking
+	}
+	return wanted
+}
+
+// Function to report whether the track is audio the mixer can decode
+func (p *publishedTrack) mixable() bool {
+	if p.kind != webrtc.RTPCodecTypeAudio.String() {
+		return false
+	}
+	_, ok := audio.Lookup(p.codec)
+	return ok
+}
+
+// Function to report whether the mixer can decode every audio codec a
+// session's codec policy lets publishers use
+func (s *SFU) canMix(policy []string) bool {
+	codecs := s.codecPreferences(policy, webrtc.RTPCodecTypeAudio)
+	if codecs == nil {
+		codecs = s.codecs
+	}
+	for _, codec := range codecs {
+		if !strings.HasPrefix(strings.ToLower(codec.MimeType), webrtc.RTPCodecTypeAudio.String()+"/") {
+			continue
+		}
+		if _, ok := audio.Lookup(codec.MimeType); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Function to describe a published track
+func (p *publishedTrack) info(trackID string) trackInfo {
+	return trackInfo{
+		TrackID:       trackID,
+		StreamID:      p.streamID,
+		ParticipantID: p.participantID,
+		Kind:          p.kind,
+		Codec:         p.codec,
+		Source:        p.source,
+	}
+}
+
+// Function to list the room's published tracks, caller must hold listLock
+func (room *Room) trackCatalog() []trackInfo {
+	tracks := make([]trackInfo, 0, len(room.published))
+	for trackID, published := range room.published {
+		tracks = append(tracks, published.info(trackID))
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].TrackID < tracks[j].TrackID })
+	return tracks
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"webrtc/apierror"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestSubscriptionsFilter(t *testing.T) {
+	available := map[string]*publishedTrack{
+		"alice-audio":  {participantID: "alice", kind: "audio", codec: webrtc.MimeTypeOpus, source: SourceAudio},
+		"alice-video":  {participantID: "alice", kind: "video", codec: webrtc.MimeTypeVP8, source: SourceVideo},
+		"alice-screen": {participantID: "alice", kind: "video", codec: webrtc.MimeTypeVP8, source: SourceScreen},
+		"bob-audio":    {participantID: "bob", kind: "audio", codec: webrtc.MimeTypePCMU, source: SourceAudio},
+		"bob-video":    {participantID: "bob", kind: "video", codec: webrtc.MimeTypeVP8, source: SourceVideo},
+	}
+	wanted := func(s *subscriptions, speakerID string) []string {
+		trackIDs := []string{}
+		for trackID, want := range s.filter(available, speakerID) {
+			if want {
+				trackIDs = append(trackIDs, trackID)
+			}
+		}
+		slices.Sort(trackIDs)
+		return trackIDs
+	}
+
+	type step struct {
+		subscribe bool
+		request   subscriptionMessage
+	}
+	tests := []struct {
+		name    string
+		steps   []step
+		speaker string
+		want    []string
+	}{
+		{
+			name: "everything by default",
+			want: []string{"alice-audio", "alice-screen", "alice-video", "bob-audio", "bob-video"},
+		},
+		{
+			name:  "all but one",
+			steps: []step{{false, subscriptionMessage{TrackIDs: []string{"bob-video"}}}},
+			want:  []string{"alice-audio", "alice-screen", "alice-video", "bob-audio"},
+		},
+		{
+			name:  "only chosen",
+			steps: []step{{false, subscriptionMessage{All: true}}, {true, subscriptionMessage{TrackIDs: []string{"alice-screen", "bob-audio"}}}},
+			want:  []string{"alice-screen", "bob-audio"},
+		},
+		{
+			name:  "all again clears choices",
+			steps: []step{{false, subscriptionMessage{TrackIDs: []string{"bob-video"}}}, {true, subscriptionMessage{All: true}}},
+			want:  []string{"alice-audio", "alice-screen", "alice-video", "bob-audio", "bob-video"},
+		},
+		{
+			name:    "speaker's camera",
+			steps:   []step{{false, subscriptionMessage{All: true}}, {true, subscriptionMessage{Speaker: true}}},
+			speaker: "bob",
+			want:    []string{"bob-video"},
+		},
+		{
+			name:    "speaker on top of choices",
+			steps:   []step{{false, subscriptionMessage{All: true}}, {true, subscriptionMessage{Speaker: true, TrackIDs: []string{"alice-audio"}}}},
+			speaker: "alice",
+			want:    []string{"alice-audio", "alice-video"},
+		},
+		{
+			name:  "mixed audio replaces mixable tracks",
+			steps: []step{{true, subscriptionMessage{MixedAudio: true, TrackIDs: []string{"bob-audio"}}}},
+			want:  []string{"alice-audio", "alice-screen", "alice-video", "bob-video"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSubscriptions()
+			for _, step := range tt.steps {
+				s.update(step.request, step.subscribe)
+			}
+			if got := wanted(s, tt.speaker); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wanted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionsForgetGoneTracks(t *testing.T) {
+	s := newSubscriptions()
+	s.update(subscriptionMessage{All: true}, false)
+	s.update(subscriptionMessage{TrackIDs: []string{"gone"}}, true)
+
+	s.filter(map[string]*publishedTrack{}, "")
+	if len(s.choices) != 0 {
+		t.Errorf("choices %v kept for tracks no longer in the room", s.choices)
+	}
+}
+
+func TestSubscribeChangesForwardedTracks(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	host := join(t, sfu, server, Participant{ID: "host", Role: RoleHost}, true)
+	host.publish(webrtc.RTPCodecTypeAudio)
+	host.publish(webrtc.RTPCodecTypeVideo)
+	host.answer()
+	eventually(t, "the host's tracks", func() bool { return len(publishedBy(sfu, "host")) == 2 })
+
+	bob := join(t, sfu, server, Participant{ID: "bob", Role: RolePresenter}, false)
+	eventually(t, "bob receiving everything", func() bool {
+		return reflect.DeepEqual(sentTo(sfu, "bob"), []string{"host-audio", "host-video"})
+	})
+
+	bob.send("unsubscribe", subscriptionMessage{TrackIDs: []string{"host-video"}})
+	eventually(t, "bob dropping the video", func() bool {
+		return reflect.DeepEqual(sentTo(sfu, "bob"), []string{"host-audio"})
+	})
+
+	bob.send("unsubscribe", subscriptionMessage{All: true})
+	eventually(t, "bob dropping everything", func() bool { return len(sentTo(sfu, "bob")) == 0 })
+
+	bob.send("subscribe", subscriptionMessage{TrackIDs: []string{"host-video"}})
+	eventually(t, "bob taking the video", func() bool {
+		return reflect.DeepEqual(sentTo(sfu, "bob"), []string{"host-video"})
+	})
+
+	bob.send("subscribe", subscriptionMessage{})
+	if code := bob.waitForError(); code != apierror.CodeValidationFailed {
+		t.Errorf("empty subscribe: %s, want %s", code, apierror.CodeValidationFailed)
+	}
+}
@@ -1,14 +1,24 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+	"webrtc/apierror"
+	"webrtc/audio"
+	"webrtc/config"
+	"webrtc/logging"
 
 	"github.com/gorilla/websocket"
-	"github.com/pion/rtcp"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -17,10 +27,76 @@ var (
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
-	listLock sync.RWMutex // RWMutex to synchronize access to rooms
-	rooms    = make(map[string]*Room) // Map to store rooms
 )
 
+// SFU - Forwards media between the peers of each room.
+type SFU struct {
+	cfg      config.WebRTC
+	api      *webrtc.API
+	codecs   []webrtc.RTPCodecParameters // registered, most preferred first
+	mixCodec audio.Codec
+	log      *slog.Logger
+	auth     *Auth
+	admit    AdmissionFunc
+	redeemed redeemedTokens
+	listLock sync.RWMutex     // RWMutex to synchronize access to rooms
+	rooms    map[string]*Room // Map to store rooms
+}
+
+// NewSFU - Creates an SFU using the given settings and base logger. Joins
+// must present a join token issued by auth.
+func NewSFU(cfg config.WebRTC, auth *Auth, log *slog.Logger) (*SFU, error) {
+	api, codecs, err := newAPI(cfg.Codecs)
+	if err != nil {
+		return nil, err
+	}
+	mixCodec, ok := audio.Lookup(cfg.MixCodec)
+	if !ok {
+		return nil, fmt.Errorf("no audio codec %q for mixing", cfg.MixCodec)
+	}
+
+	return &SFU{
+		cfg:      cfg,
+		api:      api,
+		codecs:   codecs,
+		mixCodec: mixCodec,
+		log:      log,
+		auth:     auth,
+		redeemed: redeemedTokens{ids: make(map[string]time.Time)},
+		rooms:    make(map[string]*Room),
+	}, nil
+}
+
+// Function to create the WebRTC API peer connections are made with: the
+// configured codecs, the audio level header extension used to find the
+// active speaker and pion's default interceptors, except the NACK
+// responder; subscribers' NACKs are answered from the forwarded tracks'
+// packet caches instead. Also returns the codecs registered.
+func newAPI(cfg config.Codecs) (*webrtc.API, []webrtc.RTPCodecParameters, error) {
+	m := &webrtc.MediaEngine{}
+	codecs, err := registerCodecs(m, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, nil, err
+	}
+
+	i := &interceptor.Registry{}
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return nil, nil, err
+	}
+	i.Add(generator)
+	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+		return nil, nil, err
+	}
+	if err := webrtc.ConfigureTWCCSender(m, i); err != nil {
+		return nil, nil, err
+	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)), codecs, nil
+}
+
 // Struct to define the format of WebSocket messages
 type websocketMessage struct {
 	Event  string `json:"event"`
@@ -32,179 +108,300 @@ type websocketMessage struct {
 type peerConnectionState struct {
 	peerConnection *webrtc.PeerConnection
 	websocket      *threadSafeWriter
+	participant    Participant // guarded by listLock, the role can change
+	subscriptions  *subscriptions
+	codecs         *codecSupport
+	negotiate      chan struct{} // holds at most one pending request
+	renegotiate    atomic.Bool   // tracks changed while an offer was unanswered
+	keyFrames      atomic.Bool   // the last offer added tracks
+
+	publishLock  sync.Mutex
+	publishSlots map[webrtc.RTPCodecType]int // transceivers added to publish on, see addPublishTransceivers
+}
+
+// Function to report whether the peer connection is still open
+func (p *peerConnectionState) open() bool {
+	return p.peerConnection.ConnectionState() != webrtc.PeerConnectionStateClosed
 }
 
 // Struct to define a room
 type Room struct {
-	peerConnections []peerConnectionState
-	trackLocals     map[string]*webrtc.TrackLocalStaticRTP
+	peerConnections []*peerConnectionState
+	trackLocals     map[string]*forwardedTrack
+	published       map[string]*publishedTrack // by track ID, like trackLocals
+	trackChange     chan struct{}              // closed when tracks are added or removed
+	log             *slog.Logger
+	closesAt        time.Time // zero when the room has no scheduled end
+	webinar         bool      // viewers only hear about the people on stage
+	speakers        speakerDetector
+	mixer           *audioMixer
+	lastN           int      // camera videos forwarded at once, 0 for all
+	lastNForwarded  []string // participant IDs whose camera video is forwarded
+	codecs          []string // the session's codec policy, empty for all
+	recordedBy      string   // participant ID of whoever records the room, see setRecording
+
+	keyFrameLock   sync.Mutex
+	keyFrameQueued bool
+	lastKeyFrame   time.Time
+}
+
+// Struct to record who publishes a forwarded track
+type publishedTrack struct {
+	participantID string
+	source        string
+	kind          string
+	codec         string // MIME type
+	fmtp          string // format parameters of the codec, as in SDP
+	streamID      string
+	since         time.Time
+	publisher     *webrtc.PeerConnection
+	ssrc          webrtc.SSRC
+	paused        atomic.Bool   // not forwarded for now, see applyLastN
+	stop          chan struct{} // closed to stop forwarding
+	stopOnce      sync.Once
+}
+
+// Function to stop forwarding the track, safe to call more than once
+func (p *publishedTrack) halt() {
+	p.stopOnce.Do(func() { close(p.stop) })
 }
 
 // Function to get a room, create one if it doesn't exist
-func getRoom(roomId string) *Room {
-	listLock.Lock()
-	defer listLock.Unlock()
-	if room, ok := rooms[roomId]; ok {
+func (s *SFU) getRoom(roomId string) *Room {
+	s.listLock.Lock()
+	defer s.listLock.Unlock()
+	return s.getRoomLocked(roomId)
+}
+
+// Function to get or create a room while holding listLock
+func (s *SFU) getRoomLocked(roomId string) *Room {
+	if room, ok := s.rooms[roomId]; ok {
 		return room
 	}
 	room := &Room{
-		peerConnections: []peerConnectionState{},
-		trackLocals:     make(map[string]*webrtc.TrackLocalStaticRTP),
+		peerConnections: []*peerConnectionState{},
+		trackLocals:     make(map[string]*forwardedTrack),
+		published:       make(map[string]*publishedTrack),
+		trackChange:     make(chan struct{}),
+		speakers:        speakerDetector{levels: make(map[string]*voiceActivity), spoke: make(map[string]time.Time)},
+		log:             s.log.With("room_id", roomId),
 	}
-	rooms[roomId] = room
+	room.mixer = newAudioMixer(s.mixCodec, room.log)
+	s.rooms[roomId] = room
+	room.log.Debug("room created")
 	return room
 }
 
-// Function to add a track to a room
-func addTrack(room *Room, t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		signalPeerConnections(room)
-	}()
+// Function to return the codec a remote track is sent with. pion leaves it
+// unset for payload type 0, which is PCMU, so it is then looked up in the
+// negotiated parameters.
+func trackCodec(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) webrtc.RTPCodecParameters {
+	if t.Codec().MimeType != "" {
+		return t.Codec()
+	}
+	for _, codec := range receiver.GetParameters().Codecs {
+		if codec.PayloadType == t.PayloadType() {
+			return codec
+		}
+	}
+	return t.Codec()
+}
+
+// Function to add a track published by a participant to a room
+func (s *SFU) addTrack(room *Room, t *webrtc.TrackRemote, codec webrtc.RTPCodecParameters, publisher *peerConnectionState, source string) (*forwardedTrack, *publishedTrack) {
+	s.listLock.Lock()
 
 	// Create a local track to send RTP
-	trackLocal, err := webrtc.NewTrackLocalStaticRTP(t.Codec().RTPCodecCapability, t.ID(), t.StreamID())
-	if err != nil {
-		panic(err)
+	trackLocal := newForwardedTrack(codec.RTPCodecCapability, t.ID(), t.StreamID())
+
+	published := &publishedTrack{
+		participantID: publisher.participant.ID,
+		source:        source,
+		kind:          t.Kind().String(),
+		codec:         codec.MimeType,
+		fmtp:          codec.SDPFmtpLine,
+		streamID:      t.StreamID(),
+		since:         time.Now(),
+		publisher:     publisher.peerConnection,
+		ssrc:          t.SSRC(),
+		stop:          make(chan struct{}),
 	}
-
 	room.trackLocals[t.ID()] = trackLocal
-	return trackLocal
+	room.published[t.ID()] = published
+	room.tracksChanged()
+	room.log.Debug("track added", "track_id", t.ID(), "kind", t.Kind().String(), "source", source, "codec", codec.MimeType)
+	s.listLock.Unlock()
+
+	s.broadcast(room, "track-published", published.info(t.ID()), published.participantID)
+	s.updateLastN(room)
+	return trackLocal, published
 }
 
 // Function to remove a track from a room
-func removeTrack(room *Room, t *webrtc.TrackLocalStaticRTP) {
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		signalPeerConnections(room)
-	}()
+func (s *SFU) removeTrack(room *Room, t *forwardedTrack) {
+	s.listLock.Lock()
 
+	if room.trackLocals[t.ID()] != t {
+		// Already removed, e.g. when the publisher lost the permission.
+		s.listLock.Unlock()
+		return
+	}
+	published := room.published[t.ID()]
 	delete(room.trackLocals, t.ID())
+	delete(room.published, t.ID())
+	room.tracksChanged()
+	room.log.Debug("track removed", "track_id", t.ID())
+	s.listLock.Unlock()
+
+	s.broadcast(room, "track-unpublished", published.info(t.ID()), published.participantID)
+	s.updateLastN(room)
 }
 
-// Function to signal all peer connections in a room
-func signalPeerConnections(room *Room) {
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		DispatchKeyFrame()
-	}()
+// Function to dispatch key frames to all peer connections
+func (s *SFU) DispatchKeyFrame() {
+	s.listLock.RLock()
+	defer s.listLock.RUnlock()
 
-	// Attempt to sync all peer connections
-	attemptSync := func() (tryAgain bool) {
-		for i := range room.peerConnections {
-			if room.peerConnections[i].peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
-				room.peerConnections = append(room.peerConnections[:i], room.peerConnections[i+1:]...)
-				return true
-			}
+	for _, room := range s.rooms {
+		room.sendKeyFrameRequests()
+	}
+}
 
-			existingSenders := map[string]bool{}
+// CloseRoom - Disconnects every participant of a room and forgets the room.
+func (s *SFU) CloseRoom(roomId string) {
+	s.listLock.Lock()
+	room, ok := s.rooms[roomId]
+	delete(s.rooms, roomId)
+	var peers []*peerConnectionState
+	if ok {
+		peers = append(peers, room.peerConnections...)
+	}
+	s.listLock.Unlock()
 
-			// Remove tracks that are no longer available
-			for _, sender := range room.peerConnections[i].peerConnection.GetSenders() {
-				if sender.Track() == nil {
-					continue
-				}
+	if !ok {
+		return
+	}
 
-				existingSenders[sender.Track().ID()] = true
+	room.log.Info("closing room", "participants", len(peers))
+	for _, peer := range peers {
+		_ = peer.websocket.WriteJSON(&websocketMessage{Event: "room-closed", RoomID: roomId})
+		if err := peer.peerConnection.Close(); err != nil {
+			room.log.Warn("close peer connection", "participant_id", peer.participant.ID, "err", err)
+		}
+		peer.websocket.Close()
+	}
+}
 
-				if _, ok := room.trackLocals[sender.Track().ID()]; !ok {
-					if err := room.peerConnections[i].peerConnection.RemoveTrack(sender); err != nil {
-						return true
-					}
-				}
-			}
+// CloseExpiredRooms - Closes rooms whose scheduled end, including the grace
+// period, has passed.
+func (s *SFU) CloseExpiredRooms() {
+	now := time.Now()
 
-			// Add new tracks to peer connections
-			for _, receiver := range room.peerConnections[i].peerConnection.GetReceivers() {
-				if receiver.Track() == nil {
-					continue
-				}
+	s.listLock.RLock()
+	var expired []string
+	for roomId, room := range s.rooms {
+		if !room.closesAt.IsZero() && now.After(room.closesAt) {
+			expired = append(expired, roomId)
+		}
+	}
+	s.listLock.RUnlock()
 
-				existingSenders[receiver.Track().ID()] = true
-			}
+	for _, roomId := range expired {
+		s.CloseRoom(roomId)
+	}
+}
 
-			for trackID := range room.trackLocals {
-				if _, ok := existingSenders[trackID]; !ok {
-					if _, err := room.peerConnections[i].peerConnection.AddTrack(room.trackLocals[trackID]); err != nil {
-						return true
-					}
-				}
-			}
+// ParticipantCount - Returns the number of connected participants in a room.
+func (s *SFU) ParticipantCount(roomId string) int {
+	s.listLock.RLock()
+	defer s.listLock.RUnlock()
 
-			// Create and send new offer
-			offer, err := room.peerConnections[i].peerConnection.CreateOffer(nil)
-			if err != nil {
-				return true
-			}
+	room, ok := s.rooms[roomId]
+	if !ok {
+		return 0
+	}
+	return room.participantCount()
+}
 
-			if err = room.peerConnections[i].peerConnection.SetLocalDescription(offer); err != nil {
-				return true
-			}
+// Function to count open peer connections, caller must hold listLock
+func (room *Room) participantCount() int {
+	count := 0
+	for _, peer := range room.peerConnections {
+		if peer.open() {
+			count++
+		}
+	}
+	return count
+}
 
-			offerString, err := json.Marshal(offer)
-			if err != nil {
-				return true
-			}
+// Function to build the peer connection configuration from the settings
+func (s *SFU) peerConnectionConfig() webrtc.Configuration {
+	cfg := webrtc.Configuration{}
+	if len(s.cfg.ICEServers) > 0 {
+		cfg.ICEServers = []webrtc.ICEServer{{URLs: s.cfg.ICEServers}}
+	}
+	return cfg
+}
 
-			if err = room.peerConnections[i].websocket.WriteJSON(&websocketMessage{
-				Event: "offer",
-				Data:  string(offerString),
-			}); err != nil {
-				return true
-			}
-		}
+// Function to run the admission check and return the room to join. The
+// host is let in even when the room is full.
+func (s *SFU) admitJoin(ctx context.Context, roomId string, participant Participant) (*Room, error) {
+	if s.admit == nil {
+		return s.getRoom(roomId), nil
+	}
 
-		return
+	admission, err := s.admit(ctx, roomId)
+	if err != nil {
+		return nil, err
 	}
 
-	// Retry syncing peer connections up to 25 times
-	for syncAttempt := 0; ; syncAttempt++ {
-		if syncAttempt == 25 {
-			go func() {
-				time.Sleep(time.Second * 3)
-				signalPeerConnections(room)
-			}()
-			return
-		}
+	s.listLock.Lock()
+	defer s.listLock.Unlock()
 
-		if !attemptSync() {
-			break
-		}
+	room := s.getRoomLocked(roomId)
+	full := admission.MaxParticipants > 0 && room.participantCount() >= admission.MaxParticipants
+	if full && participant.Role != RoleHost {
+		return nil, ErrRoomFull
 	}
+	room.closesAt = admission.ClosesAt
+	room.webinar = admission.Webinar
+	room.lastN = admission.LastN
+	room.codecs = admission.Codecs
+	return room, nil
 }
 
-// Function to dispatch key frames to all peer connections
-func DispatchKeyFrame() {
-	listLock.Lock()
-	defer listLock.Unlock()
-
-	for _, room := range rooms {
-		for i := range room.peerConnections {
-			for _, receiver := range room.peerConnections[i].peerConnection.GetReceivers() {
-				if receiver.Track() == nil {
-					continue
-				}
+// WebSocket handler to manage new WebSocket connections. The join token is
+// passed in the token query parameter.
+func (s *SFU) WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
+	log := logging.FromContext(r.Context()).With("room_id", roomId)
 
-				_ = room.peerConnections[i].peerConnection.WriteRTCP([]rtcp.Packet{
-					&rtcp.PictureLossIndication{
-						MediaSSRC: uint32(receiver.Track().SSRC()),
-					},
-				})
-			}
-		}
+	participant, expiresAt, err := s.auth.ValidateJoinToken(r.URL.Query().Get("token"), roomId)
+	if err != nil {
+		log.Info("join refused", "err", err)
+		refuseJoin(w, ErrJoinToken)
+		return
 	}
-}
+	log = log.With("participant_id", participant.ID)
 
-// WebSocket handler to manage new WebSocket connections
-func WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
-	room := getRoom(roomId)
+	room, err := s.admitJoin(r.Context(), roomId, participant)
+	if err != nil {
+		log.Info("join refused", "err", err)
+		refuseJoin(w, err)
+		return
+	}
+
+	// The policy may have changed since the last join
+	s.updateLastN(room)
+
+	if !s.redeemed.redeem(participant.ID, expiresAt) {
+		log.Warn("join refused, token already used")
+		refuseJoin(w, ErrJoinToken)
+		return
+	}
 
 	unsafeConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Print("upgrade:", err)
+		log.Warn("websocket upgrade failed", "err", err)
 		return
 	}
 
@@ -212,28 +409,74 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
 
 	defer c.Close()
 
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	peerConnection, err := s.api.NewPeerConnection(s.peerConnectionConfig())
 	if err != nil {
-		log.Print(err)
+		log.Error("create peer connection", "err", err)
 		return
 	}
 
+	log.Info("participant joined", "role", participant.Role, "guest", participant.Guest)
+	defer func() {
+		log.Info("participant left")
+		room.speakers.forget(participant.ID)
+		room.mixer.removeOutput(participant.ID)
+		s.stopRecording(room, participant.ID)
+		s.announce(room, "participant-left", participant)
+	}()
+
 	defer peerConnection.Close()
 
-	// Add transceivers for audio and video
-	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
-		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionRecvonly,
-		}); err != nil {
-			log.Print(err)
-			return
-		}
+	sources := &trackSources{sources: make(map[string]string)}
+
+	peer := &peerConnectionState{
+		peerConnection: peerConnection,
+		websocket:      c,
+		participant:    participant,
+		subscriptions:  newSubscriptions(),
+		codecs:         newCodecSupport(),
+		negotiate:      make(chan struct{}, 1),
+		publishSlots:   make(map[webrtc.RTPCodecType]int),
 	}
 
+	// Add transceivers for what the role may publish; viewers get none and
+	// so can't send media at all
+	s.listLock.RLock()
+	policy := room.codecs
+	s.listLock.RUnlock()
+	if err := s.addPublishTransceivers(peer, participant, policy); err != nil {
+		log.Error("add transceivers", "err", err)
+		return
+	}
+	defer s.removePeer(room, peer)
+
 	// Add peer connection to room
-	listLock.Lock()
-	room.peerConnections = append(room.peerConnections, peerConnectionState{peerConnection, c})
-	listLock.Unlock()
+	s.listLock.Lock()
+	room.peerConnections = append(room.peerConnections, peer)
+	roster := room.rosterFor(participant)
+	catalog := room.trackCatalog()
+	recordedBy := room.recordedBy
+	s.listLock.Unlock()
+
+	// The newcomer learns who is here and what they publish, everyone else
+	// that they arrived.
+	if rosterString, err := json.Marshal(roster); err == nil {
+		if err := c.WriteJSON(&websocketMessage{Event: "roster", Data: string(rosterString), RoomID: roomId}); err != nil {
+			log.Warn("send roster", "err", err)
+		}
+	}
+	if catalogString, err := json.Marshal(catalog); err == nil {
+		if err := c.WriteJSON(&websocketMessage{Event: "tracks", Data: string(catalogString), RoomID: roomId}); err != nil {
+			log.Warn("send tracks", "err", err)
+		}
+	}
+	if recordedBy != "" {
+		if recordingString, err := json.Marshal(recordingMessage{Active: true, ParticipantID: recordedBy}); err == nil {
+			if err := c.WriteJSON(&websocketMessage{Event: "recording", Data: string(recordingString), RoomID: roomId}); err != nil {
+				log.Warn("send recording", "err", err)
+			}
+		}
+	}
+	s.announce(room, "participant-joined", participant)
 
 	// Handle ICE candidates
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
@@ -243,7 +486,7 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
 
 		candidateString, err := json.Marshal(i.ToJSON())
 		if err != nil {
-			log.Println(err)
+			log.Error("marshal ICE candidate", "err", err)
 			return
 		}
 
@@ -252,27 +495,46 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
 			Data:   string(candidateString),
 			RoomID: roomId,
 		}); writeErr != nil {
-			log.Println(writeErr)
+			log.Warn("send ICE candidate", "err", writeErr)
 		}
 	})
 
 	// Handle connection state changes
 	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		log.Debug("connection state changed", "state", p.String())
 		switch p {
 		case webrtc.PeerConnectionStateFailed:
 			if err := peerConnection.Close(); err != nil {
-				log.Print(err)
+				log.Warn("close failed peer connection", "err", err)
 			}
 		case webrtc.PeerConnectionStateClosed:
-			signalPeerConnections(room)
+			s.removePeer(room, peer)
 		default:
 		}
 	})
 
-	// Handle incoming tracks
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		trackLocal := addTrack(room, t)
-		defer removeTrack(room, trackLocal)
+	// Handle incoming tracks, forwarding those the role allows
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		source := sources.get(t)
+		current, ok := s.currentParticipant(room, participant.ID)
+		if !ok || !current.CanPublish(source) {
+			log.Warn("refused track", "track_id", t.ID(), "source", source, "role", current.Role)
+			_ = sendError(c, &apierror.Error{Code: apierror.CodePermissionDenied, Message: "Your role may not publish " + source + "."})
+			return
+		}
+
+		log.Info("publishing track", "track_id", t.ID(), "kind", t.Kind().String(), "source", source)
+		codec := trackCodec(t, receiver)
+		trackLocal, published := s.addTrack(room, t, codec, peer, source)
+		defer s.removeTrack(room, trackLocal)
+
+		// Audio feeds the room's active speaker detection and mixer
+		isAudio := t.Kind() == webrtc.RTPCodecTypeAudio
+		audioLevelID := uint8(0)
+		if isAudio {
+			audioLevelID = audioLevelExtensionID(receiver)
+			defer room.mixer.removeInput(t.ID())
+		}
 
 		buf := make([]byte, 1500)
 		for {
@@ -281,22 +543,57 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
 				return
 			}
 
-			if _, err = trackLocal.Write(buf[:i]); err != nil {
+			select {
+			case <-published.stop:
+				log.Info("stopped forwarding track", "track_id", t.ID(), "source", source)
+				return
+			default:
+			}
+
+			packet := &rtp.Packet{}
+			if err := packet.Unmarshal(buf[:i]); err != nil {
+				continue
+			}
+
+			if isAudio {
+				if level, ok := readAudioLevel(&packet.Header, audioLevelID); ok {
+					room.speakers.observe(participant.ID, level, time.Now())
+				}
+				room.mixer.push(t.ID(), participant.ID, codec.MimeType, packet.Payload)
+			}
+
+			if published.paused.Load() {
+				// Not cached either, so nothing sent before a pause is
+				// ever resent after it
+				trackLocal.skip()
+				continue
+			}
+			if !trackLocal.rewrite(packet, time.Now()) {
+				continue
+			}
+
+			trackLocal.remember(packet, buf[:i])
+			if err = trackLocal.writeRTP(packet); err != nil {
 				return
 			}
 		}
 	})
 
-	signalPeerConnections(room)
+	// Only the newcomer needs an offer now; the others hear about its
+	// tracks when it publishes them
+	done := make(chan struct{})
+	defer close(done)
+	go s.negotiator(room, peer, done)
+	peer.requestNegotiation()
 
 	message := &websocketMessage{}
 	for {
 		_, raw, err := c.ReadMessage()
 		if err != nil {
-			log.Println(err)
+			log.Debug("websocket read ended", "err", err)
 			return
 		} else if err := json.Unmarshal(raw, &message); err != nil {
-			log.Println(err)
+			log.Warn("invalid websocket message", "err", err)
 			return
 		}
 
@@ -304,25 +601,82 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request, roomId string) {
 		case "candidate":
 			candidate := webrtc.ICECandidateInit{}
 			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-				log.Println(err)
+				log.Warn("invalid ICE candidate", "err", err)
 				return
 			}
 
 			if err := peerConnection.AddICECandidate(candidate); err != nil {
-				log.Println(err)
+				log.Warn("add ICE candidate", "err", err)
 				return
 			}
 		case "answer":
 			answer := webrtc.SessionDescription{}
 			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
-				log.Println(err)
+				log.Warn("invalid answer", "err", err)
+				return
+			}
+
+			if err := s.answered(room, peer, answer); err != nil {
+				log.Warn("set remote description", "err", err)
+				return
+			}
+		case "track-source":
+			announced := trackSourceMessage{}
+			if err := json.Unmarshal([]byte(message.Data), &announced); err != nil {
+				log.Warn("invalid track source", "err", err)
+				return
+			}
+
+			if _, ok := sourcePermissions[announced.Source]; !ok || announced.TrackID == "" {
+				_ = sendError(c, &apierror.Error{Code: apierror.CodeValidationFailed, Message: "Source must be audio, video or screen."})
+				continue
+			}
+			sources.set(announced.TrackID, announced.Source)
+		case "subscribe", "unsubscribe":
+			request := subscriptionMessage{}
+			if err := json.Unmarshal([]byte(message.Data), &request); err != nil {
+				log.Warn("invalid subscription", "event", message.Event, "err", err)
 				return
 			}
 
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
-				log.Println(err)
+			if !request.All && !request.Speaker && !request.MixedAudio && len(request.TrackIDs) == 0 {
+				_ = sendError(c, &apierror.Error{Code: apierror.CodeValidationFailed, Message: "Give track_ids or set all, speaker or mixed_audio."})
+				continue
+			}
+			if request.MixedAudio && message.Event == "subscribe" {
+				s.listLock.RLock()
+				sessionCodecs := room.codecs
+				s.listLock.RUnlock()
+				if !s.canMix(sessionCodecs) {
+					log.Info("mixed audio refused, the session allows audio codecs the mixer can't decode", "codecs", sessionCodecs)
+					_ = sendError(c, &apierror.Error{Code: apierror.CodeValidationFailed, Message: "Mixed audio needs a session whose audio codecs can all be mixed, such as pcmu; leave out opus."})
+					continue
+				}
+			}
+			peer.subscriptions.update(request, message.Event == "subscribe")
+			peer.requestNegotiation()
+		case "set-role", "remove-participant":
+			request := moderationMessage{}
+			if err := json.Unmarshal([]byte(message.Data), &request); err != nil {
+				log.Warn("invalid moderation request", "event", message.Event, "err", err)
 				return
 			}
+
+			var refusal *apierror.Error
+			if message.Event == "set-role" {
+				refusal = s.setRole(room, participant.ID, request)
+			} else {
+				refusal = s.removeParticipant(room, participant.ID, request)
+			}
+			if refusal != nil {
+				log.Info("moderation refused", "event", message.Event, "code", refusal.Code)
+				_ = sendError(c, refusal)
+			}
+		case "start-recording", "stop-recording":
+			if refusal := s.setRecording(room, participant.ID, message.Event == "start-recording"); refusal != nil {
+				log.Info("recording refused", "event", message.Event, "code", refusal.Code)
+				_ = sendError(c, refusal)
+			}
 		}
 	}
 }
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"webrtc/apierror"
+	"webrtc/config"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// testRoomID - The room the test clients join.
+const testRoomID = "abc-defg-hij"
+
+// eventTimeout - How long a test client waits for an event.
+const eventTimeout = 10 * time.Second
+
+// Function to create an SFU with the default settings that admits every
+// join to testRoomID as admission says, and a server for its WebSocket
+func newTestSFU(t *testing.T, admission Admission) (*SFU, *httptest.Server) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test-secret"
+
+	sfu, err := NewSFU(cfg.WebRTC, NewAuth(cfg.Auth), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfu.SetAdmission(func(context.Context, string) (Admission, error) { return admission, nil })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sfu.WebsocketHandler(w, r, testRoomID)
+	}))
+	t.Cleanup(server.Close)
+	return sfu, server
+}
+
+// Function to issue a join token for testRoomID
+func joinToken(t *testing.T, sfu *SFU, p Participant) string {
+	t.Helper()
+	token, err := sfu.auth.GenerateJoinToken(testRoomID, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Function to open the WebSocket with a join token
+func dial(server *httptest.Server, token string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?token=" + token
+	return websocket.DefaultDialer.Dial(url, nil)
+}
+
+// Function to list the IDs of the tracks the SFU forwards for a participant
+func publishedBy(sfu *SFU, participantID string) []string {
+	sfu.listLock.RLock()
+	defer sfu.listLock.RUnlock()
+
+	trackIDs := []string{}
+	room, ok := sfu.rooms[testRoomID]
+	if !ok {
+		return trackIDs
+	}
+	for trackID, track := range room.published {
+		if track.participantID == participantID {
+			trackIDs = append(trackIDs, trackID)
+		}
+	}
+	return trackIDs
+}
+
+// Function to list the IDs of the tracks the SFU sends a participant
+func sentTo(sfu *SFU, participantID string) []string {
+	sfu.listLock.RLock()
+	peer, ok := sfu.rooms[testRoomID].findPeer(participantID)
+	sfu.listLock.RUnlock()
+
+	trackIDs := []string{}
+	if !ok {
+		return trackIDs
+	}
+	for _, sender := range peer.peerConnection.GetSenders() {
+		if track := sender.Track(); track != nil {
+			trackIDs = append(trackIDs, track.ID())
+		}
+	}
+	sort.Strings(trackIDs)
+	return trackIDs
+}
+
+// Function to wait until done reports true
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(eventTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Struct to play a participant's browser: it answers the SFU's offers,
+// trades ICE candidates and collects the other events
+type testClient struct {
+	t       *testing.T
+	id      string // participant ID
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	pc      *webrtc.PeerConnection
+	events  chan websocketMessage
+	hold    atomic.Bool // leave offers unanswered until answer is called
+	offered chan struct{}
+
+	candidateMu sync.Mutex
+	answered    bool                      // the first answer was sent
+	candidates  []webrtc.ICECandidateInit // gathered before it
+}
+
+// Function to join testRoomID as the participant. With hold, the client
+// leaves offers unanswered until the test calls answer.
+func join(t *testing.T, sfu *SFU, server *httptest.Server, p Participant, hold bool) *testClient {
+	t.Helper()
+	return joinWith(t, webrtc.NewPeerConnection, sfu, server, p, hold)
+}
+
+// Function to join testRoomID with a client whose peer connection is made
+// by newPeerConnection, e.g. an API's to control the codecs it supports
+func joinWith(t *testing.T, newPeerConnection func(webrtc.Configuration) (*webrtc.PeerConnection, error), sfu *SFU, server *httptest.Server, p Participant, hold bool) *testClient {
+	t.Helper()
+	ws, _, err := dial(server, joinToken(t, sfu, p))
+	if err != nil {
+		t.Fatalf("join as %s: %v", p.ID, err)
+	}
+	pc, err := newPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, id: p.ID, ws: ws, pc: pc, events: make(chan websocketMessage, 256), offered: make(chan struct{}, 1)}
+	c.hold.Store(hold)
+	t.Cleanup(func() {
+		_ = ws.Close()
+		_ = pc.Close()
+	})
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		c.candidateMu.Lock()
+		defer c.candidateMu.Unlock()
+		if c.answered {
+			c.send("candidate", candidate.ToJSON())
+		} else {
+			c.candidates = append(c.candidates, candidate.ToJSON())
+		}
+	})
+	go c.read()
+	return c
+}
+
+// Function to handle the SFU's messages until the WebSocket closes
+func (c *testClient) read() {
+	defer close(c.events)
+	for {
+		message := websocketMessage{}
+		if err := c.ws.ReadJSON(&message); err != nil {
+			return
+		}
+
+		switch message.Event {
+		case "offer":
+			offer := webrtc.SessionDescription{}
+			if err := json.Unmarshal([]byte(message.Data), &offer); err != nil {
+				return
+			}
+			if err := c.pc.SetRemoteDescription(offer); err != nil {
+				return
+			}
+			if c.hold.Load() {
+				c.offered <- struct{}{}
+			} else {
+				c.respond()
+			}
+		case "candidate":
+			candidate := webrtc.ICECandidateInit{}
+			if err := json.Unmarshal([]byte(message.Data), &candidate); err == nil {
+				_ = c.pc.AddICECandidate(candidate)
+			}
+		}
+
+		select {
+		case c.events <- message:
+		default:
+		}
+	}
+}
+
+// Function to send the SFU an event with a JSON payload
+func (c *testClient) send(event string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Error(err)
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.ws.WriteJSON(&websocketMessage{Event: event, Data: string(data)})
+}
+
+// Function to answer the offer the SFU last sent, then send the ICE
+// candidates gathered meanwhile, which the SFU would refuse before it
+func (c *testClient) respond() {
+	answer, err := c.pc.CreateAnswer(nil)
+	if err != nil {
+		return
+	}
+	if err := c.pc.SetLocalDescription(answer); err != nil {
+		return
+	}
+	c.send("answer", answer)
+
+	c.candidateMu.Lock()
+	defer c.candidateMu.Unlock()
+	c.answered = true
+	for _, candidate := range c.candidates {
+		c.send("candidate", candidate)
+	}
+	c.candidates = nil
+}
+
+// Function to answer the offer a held client is waiting on, and every
+// later one as it comes
+func (c *testClient) answer() {
+	c.t.Helper()
+	select {
+	case <-c.offered:
+	case <-time.After(eventTimeout):
+		c.t.Fatal("no offer to answer")
+	}
+	c.hold.Store(false)
+	c.respond()
+}
+
+// Function to wait for an event, skipping others, and decode its payload
+// into out if given
+func (c *testClient) waitFor(event string, out any) websocketMessage {
+	c.t.Helper()
+	timeout := time.After(eventTimeout)
+	for {
+		select {
+		case message, ok := <-c.events:
+			if !ok {
+				c.t.Fatalf("connection closed waiting for %s", event)
+			}
+			if message.Event != event {
+				continue
+			}
+			if out != nil {
+				if err := json.Unmarshal([]byte(message.Data), out); err != nil {
+					c.t.Fatalf("decode %s: %v", event, err)
+				}
+			}
+			return message
+		case <-timeout:
+			c.t.Fatalf("no %s event", event)
+		}
+	}
+}
+
+// Function to wait for an error event and return its code
+func (c *testClient) waitForError() string {
+	c.t.Helper()
+	refusal := apierror.Error{}
+	c.waitFor("error", &refusal)
+	return refusal.Code
+}
+
+func TestJoinTokenAdmitsOnce(t *testing.T) {
+	sfu, server := newTestSFU(t, Admission{})
+	token := joinToken(t, sfu, Participant{ID: "guest-1", DisplayName: "Guest", Role: RolePresenter, Guest: true})
+
+	first, _, err := dial(server, token)
+	if err != nil {
+		t.Fatalf("first join: %v", err)
+	}
+	defer first.Close()
+
+	for name, token := range map[string]string{
+		"reused":  token,
+		"missing": "",
+		"garbage": "not-a-token",
+	} {
+		_, resp, err := dial(server, token)
+		if err == nil {
+			t.Errorf("%s token admitted", name)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s token: response %v, want 401", name, resp)
+		}
+	}
+
+	other, err := sfu.auth.GenerateJoinToken("zzz-zzzz-zzz", Participant{ID: "guest-2", Role: RolePresenter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp, err := dial(server, other); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Error("token for another room admitted")
+	}
+}
+
+func TestRedeemedTokensForgetExpired(t *testing.T) {
+	r := redeemedTokens{ids: map[string]time.Time{}}
+	if !r.redeem("a", time.Now().Add(-time.Second)) {
+		t.Fatal("first redemption refused")
+	}
+	if !r.redeem("a", time.Now().Add(time.Minute)) {
+		t.Fatal("expired token still remembered")
+	}
+	if r.redeem("a", time.Now().Add(time.Minute)) {
+		t.Fatal("token redeemed twice")
+	}
+}
@@ -0,0 +1,143 @@
+// Package ical renders RFC 5545 iCalendar files for meeting invitations.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Methods for the METHOD property.
+const (
+	MethodPublish = "PUBLISH" // calendar export
+	MethodRequest = "REQUEST" // meeting invitation
+	MethodCancel  = "CANCEL"  // meeting cancellation
+)
+
+const (
+	utcLayout   = "20060102T150405Z"
+	localLayout = "20060102T150405"
+	prodID      = "-//MeetKobi//Sessions//EN"
+)
+
+// Person - An organizer or attendee.
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Event - A single VEVENT, optionally recurring.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	URL         string
+	Start       time.Time
+	End         time.Time
+	Location    *time.Location // zone DTSTART/DTEND are written in; nil means UTC
+	RRule       string         // RRULE value without the "RRULE:" prefix
+	Organizer   Person
+	Attendees   []Person
+	Sequence    int
+	Stamp       time.Time
+}
+
+// Render - Renders a VCALENDAR holding the given event.
+func Render(method string, event Event) []byte {
+	w := &writer{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + prodID)
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:" + method)
+
+	zoned := event.Location != nil && event.Location != time.UTC
+	if zoned {
+		writeTimezone(w, event.Location, event.Start)
+	}
+
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + escape(event.UID))
+	w.line("DTSTAMP:" + event.Stamp.UTC().Format(utcLayout))
+	if zoned {
+		tzid := event.Location.String()
+		w.line("DTSTART;TZID=" + tzid + ":" + event.Start.In(event.Location).Format(localLayout))
+		w.line("DTEND;TZID=" + tzid + ":" + event.End.In(event.Location).Format(localLayout))
+	} else {
+		w.line("DTSTART:" + event.Start.UTC().Format(utcLayout))
+		w.line("DTEND:" + event.End.UTC().Format(utcLayout))
+	}
+	if event.RRule != "" {
+		w.line("RRULE:" + event.RRule)
+	}
+	w.line("SUMMARY:" + escape(event.Summary))
+	if event.Description != "" {
+		w.line("DESCRIPTION:" + escape(event.Description))
+	}
+	if event.URL != "" {
+		w.line("URL:" + event.URL)
+		w.line("LOCATION:" + escape(event.URL))
+	}
+	if event.Organizer.Email != "" {
+		w.line("ORGANIZER" + cn(event.Organizer.Name) + ":mailto:" + event.Organizer.Email)
+	}
+	for _, attendee := range event.Attendees {
+		w.line("ATTENDEE" + cn(attendee.Name) + ";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:" + attendee.Email)
+	}
+	w.line(fmt.Sprintf("SEQUENCE:%d", event.Sequence))
+	if method == MethodCancel {
+		w.line("STATUS:CANCELLED")
+	} else {
+		w.line("STATUS:CONFIRMED")
+	}
+	w.line("END:VEVENT")
+	w.line("END:VCALENDAR")
+	return w.buf.Bytes()
+}
+
+func cn(name string) string {
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + strings.NewReplacer(`"`, "'", "\r", "", "\n", " ").Replace(name) + `"`
+}
+
+// escape - Escapes a TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	).Replace(s)
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+// line - Writes a content line, folding it at 75 octets without splitting
+// UTF-8 sequences.
+func (w *writer) line(s string) {
+	const limit = 75
+	width := limit
+	for len(s) > width {
+		cut := width
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+		width = limit - 1 // continuation lines start with a space
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,123 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// unfold - Joins folded content lines back together.
+func unfold(s string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+func hasLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
+
+func testEvent() Event {
+	return Event{
+		UID:       "abc@example.com",
+		Summary:   "Standup; daily, team",
+		Start:     time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+		End:       time.Date(2026, 3, 2, 9, 15, 0, 0, time.UTC),
+		Organizer: Person{Name: "Host", Email: "host@example.com"},
+		Attendees: []Person{{Name: `Ann "A"`, Email: "ann@example.com"}},
+		Sequence:  3,
+		Stamp:     time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestRenderRequest(t *testing.T) {
+	out := string(Render(MethodRequest, testEvent()))
+	lines := unfold(out)
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR",
+		"METHOD:REQUEST",
+		"UID:abc@example.com",
+		"DTSTART:20260302T090000Z",
+		"DTEND:20260302T091500Z",
+		`SUMMARY:Standup\; daily\, team`,
+		`ORGANIZER;CN="Host":mailto:host@example.com`,
+		`ATTENDEE;CN="Ann 'A'";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:ann@example.com`,
+		"SEQUENCE:3",
+		"STATUS:CONFIRMED",
+		"END:VCALENDAR",
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing line %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "VTIMEZONE") {
+		t.Error("UTC event should not carry a VTIMEZONE")
+	}
+}
+
+func TestRenderCancel(t *testing.T) {
+	lines := unfold(string(Render(MethodCancel, testEvent())))
+	if !hasLine(lines, "METHOD:CANCEL") || !hasLine(lines, "STATUS:CANCELLED") {
+		t.Errorf("cancellation lacks METHOD:CANCEL or STATUS:CANCELLED: %q", lines)
+	}
+}
+
+func TestRenderZoned(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("zone database unavailable:", err)
+	}
+	event := testEvent()
+	event.Location = loc
+	event.RRule = "FREQ=WEEKLY;BYDAY=MO"
+	lines := unfold(string(Render(MethodPublish, event)))
+
+	for _, want := range []string{
+		"TZID:Europe/Berlin",
+		"DTSTART;TZID=Europe/Berlin:20260302T100000",
+		"DTEND;TZID=Europe/Berlin:20260302T101500",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO",
+		// Summer time starts on the last Sunday of March at 02:00 CET
+		"DTSTART:20260329T020000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+	} {
+		if !hasLine(lines, want) {
+			t.Errorf("missing line %q", want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	got := escape("a\\b;c,d\r\ne\nf\rg")
+	if want := `a\\b\;c\,d\ne\nfg`; got != want {
+		t.Errorf("escape = %q, want %q", got, want)
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	w := &writer{}
+	long := "DESCRIPTION:" + strings.Repeat("é", 100)
+	w.line(long)
+
+	out := w.buf.String()
+	for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets long", i, len(line))
+		}
+		if i > 0 && !strings.HasPrefix(line, " ") {
+			t.Errorf("continuation line %d does not start with a space", i)
+		}
+		if !utf8.ValidString(strings.TrimPrefix(line, " ")) {
+			t.Errorf("line %d splits a UTF-8 sequence", i)
+		}
+	}
+	if got := unfold(out); len(got) != 1 || got[0] != long {
+		t.Errorf("unfolded line = %q, want %q", got, long)
+	}
+}
@@ -0,0 +1,106 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// timezoneYears - How many years of offset transitions VTIMEZONE covers.
+const timezoneYears = 6
+
+type transition struct {
+	at         time.Time // instant the new offset takes effect
+	fromOffset int
+	toOffset   int
+	name       string
+	dst        bool
+}
+
+// writeTimezone - Writes a VTIMEZONE for loc describing the offsets in
+// effect around start and the following years, as derived from the Go zone
+// database. Scanning starts a year early so the observance covering start
+// itself is included.
+func writeTimezone(w *writer, loc *time.Location, start time.Time) {
+	from := time.Date(start.In(loc).Year()-1, 1, 1, 0, 0, 0, 0, loc)
+	transitions := findTransitions(loc, from, from.AddDate(timezoneYears, 0, 0))
+
+	w.line("BEGIN:VTIMEZONE")
+	w.line("TZID:" + loc.String())
+
+	if len(transitions) == 0 {
+		name, offset := from.Zone()
+		w.line("BEGIN:STANDARD")
+		w.line("DTSTART:19700101T000000")
+		w.line("TZOFFSETFROM:" + formatOffset(offset))
+		w.line("TZOFFSETTO:" + formatOffset(offset))
+		w.line("TZNAME:" + name)
+		w.line("END:STANDARD")
+	}
+
+	for _, t := range transitions {
+		kind := "STANDARD"
+		if t.dst {
+			kind = "DAYLIGHT"
+		}
+		// Observance onsets are local times in the offset being left.
+		onset := t.at.In(time.FixedZone("", t.fromOffset))
+		w.line("BEGIN:" + kind)
+		w.line("DTSTART:" + onset.Format(localLayout))
+		w.line("TZOFFSETFROM:" + formatOffset(t.fromOffset))
+		w.line("TZOFFSETTO:" + formatOffset(t.toOffset))
+		w.line("TZNAME:" + t.name)
+		w.line("END:" + kind)
+	}
+
+	w.line("END:VTIMEZONE")
+}
+
+// findTransitions - Finds the instants in [from, to) at which loc's UTC
+// offset changes.
+func findTransitions(loc *time.Location, from, to time.Time) []transition {
+	var transitions []transition
+
+	prev := from
+	_, prevOffset := prev.Zone()
+	for t := from.Add(24 * time.Hour); t.Before(to); t = t.Add(24 * time.Hour) {
+		_, offset := t.Zone()
+		if offset == prevOffset {
+			prev = t
+			continue
+		}
+
+		// Binary search for the first second with the new offset.
+		lo, hi := prev.Unix(), t.Unix()
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if _, o := time.Unix(mid, 0).In(loc).Zone(); o == prevOffset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+
+		at := time.Unix(hi, 0).In(loc)
+		name, _ := at.Zone()
+		transitions = append(transitions, transition{
+			at:         at,
+			fromOffset: prevOffset,
+			toOffset:   offset,
+			name:       name,
+			dst:        at.IsDST(),
+		})
+
+		prev, prevOffset = t, offset
+	}
+
+	return transitions
+}
+
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, seconds/3600, (seconds%3600)/60)
+}
@@ -0,0 +1,13 @@
+package interfaces
+
+import "time"
+
+// Attempts - Failed attempts recorded against a rate limit key such as a
+// client IP or an account.
+type Attempts struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"lastfailure"`
+	// ExpiresAt is when the record can be forgotten.
+	ExpiresAt time.Time `bson:"expiresat"`
+}
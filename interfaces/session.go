@@ -1,14 +1,64 @@
 package interfaces
 
+import (
+	"time"
+	"webrtc/schedule"
+)
+
 // Session interface
-type Sessionget struct {
-	ID string `bson:"_id"`
-	Host     string
-	Title    string
-	Password string
-}
 type Session struct {
-	Host     string
-	Title    string
-	Password string
-}
\ No newline at end of file
+	ID       string           `bson:"_id,omitempty" json:"-"`
+	Host     string           `json:"-"` // ID of the user hosting the session
+	Title    string           `json:"title" binding:"required,notblank,max=100"`
+	Password string           `json:"password" binding:"required,max=72"`
+	Settings SessionSettings  `json:"settings"`
+	Schedule *SessionSchedule `json:"schedule,omitempty" bson:",omitempty"`
+	// Sequence is the iCalendar SEQUENCE of the session's event, bumped
+	// whenever a change outdates the invitations sent before it.
+	Sequence int `json:"-"`
+	// Invitees are the addresses invited by email, as "Name <address>",
+	// who are told if the session is deleted.
+	Invitees []string `json:"-" bson:",omitempty"`
+}
+
+// SessionSettings - Host controlled options for a session.
+type SessionSettings struct {
+	MaxParticipants int  `json:"max_participants" binding:"min=0,max=1000"` // 0 means unlimited
+	Locked          bool `json:"locked"`                                    // rejects new participants
+	// GuestRole is the room role of participants joining with the
+	// password: presenter (the default) or viewer.
+	GuestRole string `json:"guest_role,omitempty" binding:"omitempty,oneof=presenter viewer"`
+	// Mode is meeting (the default) or webinar. In a webinar guests join
+	// as viewers whatever GuestRole says, and the host promotes the
+	// presenters.
+	Mode string `json:"mode,omitempty" binding:"omitempty,oneof=meeting webinar"`
+	// LastN limits forwarded camera video to the N most recent speakers;
+	// the others are paused while their audio continues. 0 forwards all.
+	LastN int `json:"last_n,omitempty" binding:"min=0,max=100"`
+	// Codecs limits what publishers may send to these codecs, most
+	// preferred first, so every subscriber can decode it. A kind with no
+	// codec listed is left to the server's configuration. A change reaches
+	// a room in progress when someone next joins, and applies to tracks
+	// published after that; tracks already published keep their codec.
+	Codecs []string `json:"codecs,omitempty" binding:"omitempty,max=8,unique,dive,oneof=opus pcmu pcma g722 vp8 vp9 h264 av1"`
+}
+
+// Session modes.
+const (
+	SessionModeMeeting = "meeting"
+	SessionModeWebinar = "webinar"
+)
+
+// SessionSchedule - When a session takes place. Without a schedule a
+// session is always open.
+type SessionSchedule struct {
+	StartsAt   time.Time `json:"starts_at" binding:"required"`
+	EndsAt     time.Time `json:"ends_at" binding:"required"`
+	Recurrence string    `json:"recurrence,omitempty" binding:"max=500"` // RFC 5545 RRULE, e.g. FREQ=WEEKLY;BYDAY=MO,WE,FR
+	TimeZone   string    `json:"time_zone,omitempty" binding:"max=64"`   // IANA zone the recurrence repeats in
+}
+
+// Build - Validates the schedule and returns it in expandable form.
+func (s *SessionSchedule) Build() (*schedule.Schedule, error) {
+	return schedule.New(s.StartsAt, s.EndsAt, s.Recurrence, s.TimeZone)
+}
@@ -0,0 +1,46 @@
+package interfaces
+
+import "time"
+
+// RefreshToken - A stored refresh token. Only the hash of the token is kept.
+// Tokens issued from the same login share a Family so reuse of a rotated
+// token can revoke the whole chain.
+type RefreshToken struct {
+	ID        string     `bson:"_id,omitempty"`
+	TokenHash string     `bson:"tokenhash"`
+	UserID    string     `bson:"userid"`
+	Family    string     `bson:"family"`
+	CreatedAt time.Time  `bson:"createdat"`
+	ExpiresAt time.Time  `bson:"expiresat"`
+	RevokedAt *time.Time `bson:"revokedat,omitempty"`
+	AccessID  string     `bson:"accessid,omitempty"` // ID of the access token issued with it
+}
+
+// RevokedToken - An access token revoked before it expires, by token ID.
+// It is kept until the token would have expired anyway.
+type RevokedToken struct {
+	ID        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expiresat"`
+}
+
+// Purposes of an ActionToken.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	// PurposeSSOLogin tokens are handed to the frontend after a single
+	// sign-on and exchanged for a login.
+	PurposeSSOLogin = "sso_login"
+)
+
+// ActionToken - A single-use token mailed to a user, such as an email
+// verification or password reset link, or handed over after single sign-on. Only the hash of the token is kept.
+type ActionToken struct {
+	ID        string     `bson:"_id,omitempty"`
+	TokenHash string     `bson:"tokenhash"`
+	UserID    string     `bson:"userid"`
+	Purpose   string     `bson:"purpose"`
+	Email     string     `bson:"email"` // address the token was sent to
+	CreatedAt time.Time  `bson:"createdat"`
+	ExpiresAt time.Time  `bson:"expiresat"`
+	UsedAt    *time.Time `bson:"usedat,omitempty"`
+}
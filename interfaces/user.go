@@ -2,12 +2,25 @@ package interfaces
 
 // Session interface
 type User struct {
-	UserName string
-	Email    string
-	Password string
+	ID       string `bson:"_id,omitempty" json:"-"`
+	UserName string `json:"username" binding:"required,notblank,min=3,max=32"`
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Password string `json:"password" binding:"required,password"`
+	// EmailVerified is set once the user follows the link mailed to Email.
+	EmailVerified bool `json:"-"`
+	// Identities are the single sign-on accounts linked to this user.
+	// Password is empty for users who have only ever signed in that way.
+	Identities []Identity `bson:"identities,omitempty" json:"-"`
+}
+
+// Identity - An account at an OpenID Connect provider, identified by the
+// provider's subject claim.
+type Identity struct {
+	Provider string `bson:"provider"`
+	Subject  string `bson:"subject"`
 }
 
 type Login struct {
-	Email    string
-	Password string
-}
\ No newline at end of file
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,max=72"`
+}
@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Memory - Keeps sent messages in memory. Meant for tests.
+type Memory struct {
+	from     string
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemory - Creates an in-memory mailer.
+func NewMemory(from string) *Memory {
+	return &Memory{from: from}
+}
+
+// Send - Records msg.
+func (m *Memory) Send(_ context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = m.from
+	}
+	if _, err := Build(msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages - Returns the messages sent so far.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// File - Writes each message as an .eml file to a directory, for local
+// development without a mail server.
+type File struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	seq  int
+}
+
+// NewFile - Creates a file mailer writing to dir, creating it if needed.
+func NewFile(dir, from string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir, from: from}, nil
+}
+
+// Send - Writes msg to a new file.
+func (f *File) Send(_ context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = f.from
+	}
+	data, err := Build(msg)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.seq++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().UTC().Format("20060102T150405"), f.seq)
+	f.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(f.dir, name), data, 0o600)
+}
@@ -0,0 +1,44 @@
+// Package mailer sends transactional email through a pluggable transport.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"webrtc/config"
+)
+
+// Message - An email to send.
+type Message struct {
+	From        string // filled in from the configured sender when empty
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Attachment - A file attached to a message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer - Delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New - Creates the mailer selected by the configuration.
+func New(cfg config.Mail) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTP(cfg.SMTP, cfg.From), nil
+	case "file":
+		return NewFile(cfg.Dir, cfg.From)
+	case "memory":
+		return NewMemory(cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
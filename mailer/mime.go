@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Build - Renders msg as an RFC 5322 message with MIME parts.
+func Build(msg Message) ([]byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender: %w", err)
+	}
+	to := make([]string, 0, len(msg.To))
+	for _, rcpt := range msg.To {
+		addr, err := mail.ParseAddress(rcpt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", rcpt, err)
+		}
+		to = append(to, addr.String())
+	}
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+
+	header("From", from.String())
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+
+	mixed := multipart.NewWriter(&buf)
+	header("Content-Type", `multipart/mixed; boundary="`+mixed.Boundary()+`"`)
+	buf.WriteString("\r\n")
+
+	if err := writeBody(mixed, msg); err != nil {
+		return nil, err
+	}
+
+	for _, att := range msg.Attachments {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", att.ContentType)
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+		part, err := mixed.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, att.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBody - Writes the text and, when present, HTML alternatives.
+func writeBody(mixed *multipart.Writer, msg Message) error {
+	var body bytes.Buffer
+	alt := multipart.NewWriter(&body)
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", `multipart/alternative; boundary="`+alt.Boundary()+`"`)
+	part, err := mixed.CreatePart(h)
+	if err != nil {
+		return err
+	}
+
+	if err := writeQuotedPrintable(alt, "text/plain; charset=utf-8", msg.Text); err != nil {
+		return err
+	}
+	if msg.HTML != "" {
+		if err := writeQuotedPrintable(alt, "text/html; charset=utf-8", msg.HTML); err != nil {
+			return err
+		}
+	}
+	if err := alt.Close(); err != nil {
+		return err
+	}
+
+	_, err = part.Write(body.Bytes())
+	return err
+}
+
+func writeQuotedPrintable(w *multipart.Writer, contentType, content string) error {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64(w interface{ Write([]byte) (int, error) }, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := w.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+func messageID(sender string) string {
+	domain := "localhost"
+	if _, d, ok := strings.Cut(sender, "@"); ok {
+		domain = d
+	}
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
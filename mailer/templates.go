@@ -0,0 +1,124 @@
+package mailer
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// VerificationMessage - Asks the user to confirm their email address.
+func VerificationMessage(to, name, link string, ttl time.Duration) Message {
+	return Message{
+		To:      []string{to},
+		Subject: "Confirm your email address",
+		Text: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\n"+
+			"The link expires in %s. If you did not create an account, you can ignore this email.\n",
+			name, link, formatTTL(ttl)),
+		HTML: fmt.Sprintf("<p>Hi %s,</p><p>Please confirm your email address:</p>"+
+			`<p><a href="%s">Confirm email</a></p>`+
+			"<p>The link expires in %s. If you did not create an account, you can ignore this email.</p>",
+			html.EscapeString(name), html.EscapeString(link), formatTTL(ttl)),
+	}
+}
+
+// PasswordResetMessage - Sends a password reset link.
+func PasswordResetMessage(to, name, link string, ttl time.Duration) Message {
+	return Message{
+		To:      []string{to},
+		Subject: "Reset your password",
+		Text: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"To choose a new password, open this link:\n\n%s\n\n"+
+			"The link expires in %s. If you did not ask for this, you can ignore this email.\n",
+			name, link, formatTTL(ttl)),
+		HTML: fmt.Sprintf("<p>Hi %s,</p><p>Someone asked to reset the password of your account.</p>"+
+			`<p><a href="%s">Choose a new password</a></p>`+
+			"<p>The link expires in %s. If you did not ask for this, you can ignore this email.</p>",
+			html.EscapeString(name), html.EscapeString(link), formatTTL(ttl)),
+	}
+}
+
+// Invitation - What an invitation email tells the recipient.
+type Invitation struct {
+	To       string
+	HostName string
+	Title    string
+	JoinURL  string
+	Note     string    // optional message from the host
+	StartsAt time.Time // zero for unscheduled sessions
+	Calendar []byte    // optional .ics invitation
+}
+
+// InvitationMessage - Invites someone to a session.
+func InvitationMessage(inv Invitation) Message {
+	var text, body strings.Builder
+
+	fmt.Fprintf(&text, "%s invited you to %q.\n\n", inv.HostName, inv.Title)
+	fmt.Fprintf(&body, "<p>%s invited you to <strong>%s</strong>.</p>", html.EscapeString(inv.HostName), html.EscapeString(inv.Title))
+	if !inv.StartsAt.IsZero() {
+		when := inv.StartsAt.Format("Monday, 2 January 2006 15:04 MST")
+		fmt.Fprintf(&text, "When: %s\n\n", when)
+		fmt.Fprintf(&body, "<p>When: %s</p>", html.EscapeString(when))
+	}
+	if inv.Note != "" {
+		fmt.Fprintf(&text, "%s\n\n", inv.Note)
+		fmt.Fprintf(&body, "<p>%s</p>", strings.ReplaceAll(html.EscapeString(inv.Note), "\n", "<br>"))
+	}
+	fmt.Fprintf(&text, "Join the meeting: %s\n", inv.JoinURL)
+	fmt.Fprintf(&body, `<p><a href="%s">Join the meeting</a></p>`, html.EscapeString(inv.JoinURL))
+
+	msg := Message{
+		To:      []string{inv.To},
+		Subject: "Invitation: " + inv.Title,
+		Text:    text.String(),
+		HTML:    body.String(),
+	}
+	if len(inv.Calendar) > 0 {
+		msg.Attachments = []Attachment{{
+			Filename:    "invite.ics",
+			ContentType: "text/calendar; charset=utf-8; method=REQUEST",
+			Data:        inv.Calendar,
+		}}
+	}
+	return msg
+}
+
+// CancellationMessage - Tells an invitee that a session was cancelled. Its
+// calendar, if any, must use METHOD:CANCEL to remove the event.
+func CancellationMessage(inv Invitation) Message {
+	var text, body strings.Builder
+
+	fmt.Fprintf(&text, "%s cancelled %q.\n", inv.HostName, inv.Title)
+	fmt.Fprintf(&body, "<p>%s cancelled <strong>%s</strong>.</p>", html.EscapeString(inv.HostName), html.EscapeString(inv.Title))
+	if !inv.StartsAt.IsZero() {
+		when := inv.StartsAt.Format("Monday, 2 January 2006 15:04 MST")
+		fmt.Fprintf(&text, "\nIt was to take place %s.\n", when)
+		fmt.Fprintf(&body, "<p>It was to take place %s.</p>", html.EscapeString(when))
+	}
+
+	msg := Message{
+		To:      []string{inv.To},
+		Subject: "Cancelled: " + inv.Title,
+		Text:    text.String(),
+		HTML:    body.String(),
+	}
+	if len(inv.Calendar) > 0 {
+		msg.Attachments = []Attachment{{
+			Filename:    "cancel.ics",
+			ContentType: "text/calendar; charset=utf-8; method=CANCEL",
+			Data:        inv.Calendar,
+		}}
+	}
+	return msg
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		hours := int(ttl / time.Hour)
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return ttl.String()
+}
@@ -2,18 +2,27 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"log/slog"
 	"text/template"
 	"time"
 
 	"os"
+	"webrtc/apierror"
+	"webrtc/config"
 	"webrtc/controllers"
 	"webrtc/handlers"
+	"webrtc/logging"
+	"webrtc/mailer"
+	"webrtc/middleware"
+	"webrtc/repository"
+	"webrtc/sso"
+	"webrtc/utils"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -23,87 +32,176 @@ var (
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	configFile := flag.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		log.Fatal("Set your 'MONGODB_URI' environment variable. " +
-			"See: " +
-			"www.mongodb.com/docs/drivers/go/current/usage-examples/#environment-variable")
+
+	logger, logCloser, err := logging.New(logging.Options{
+		Level:      cfg.Log.Level,
+		Format:     cfg.Log.Format,
+		File:       cfg.Log.File,
+		MaxSizeMB:  cfg.Log.MaxSizeMB,
+		MaxBackups: cfg.Log.MaxBackups,
+		MaxAgeDays: cfg.Log.MaxAgeDays,
+		Compress:   cfg.Log.Compress,
+	})
+	if err != nil {
+		log.Fatalf("Error configuring logger: %v", err)
+	}
+	defer logCloser.Close()
+	slog.SetDefault(logger)
+
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(middleware.RequestLogger(logger), gin.CustomRecovery(apierror.Recovery))
+	router.NoRoute(apierror.NotFound)
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
 	}
-	
-	router := gin.Default()
 
-	config := cors.Config{
-		AllowOrigins:     []string{getenv("HOST_URL", "http://localhost")},
+	corsConfig := cors.Config{
+		AllowOrigins:     []string{cfg.Server.HostURL},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}
 
-	router.Use(cors.New(config))
+	router.Use(cors.New(corsConfig))
 
 	indexHTML, err := os.ReadFile("templates/index.html")
 	if err != nil {
-		log.Fatalf("Error reading index.html: %v", err)
+		logger.Error("reading index.html", "err", err)
+		os.Exit(1)
 	}
 	indexTemplate = template.Must(template.New("").Parse(string(indexHTML)))
 
+	var stores *repository.Stores
+	switch cfg.Store {
+	case "memory":
+		logger.Warn("using in-memory store, data is lost on restart")
+		stores = repository.NewMemory()
+	default:
+		client, err := mongo.Connect(context.TODO(), options.Client().
+			ApplyURI(cfg.Mongo.URI))
+		if err != nil {
+			panic(err)
+		}
 
-	client, err := mongo.Connect(context.TODO(), options.Client().
-		ApplyURI(uri))
-	if err != nil {
-		panic(err)
-	}
+		defer func() {
+			if err = client.Disconnect(context.TODO()); err != nil {
+				logger.Error("disconnecting from MongoDB", "err", err)
+			}
+		}()
 
-	defer func() {
-		if err = client.Disconnect(context.TODO()); err != nil {
-			log.Fatalf("Error disconnecting from MongoDB: %v", err)
+		db := client.Database(cfg.Mongo.Database)
+		if err := repository.EnsureIndexes(context.TODO(), db); err != nil {
+			logger.Error("bootstrapping MongoDB indexes", "err", err)
+			os.Exit(1)
 		}
-	}()
+		stores = repository.NewMongo(db)
+	}
 
+	if cfg.RateLimit.Store == "memory" {
+		stores.Attempts = repository.NewMemoryAttemptStore()
+	}
 
+	roomCodes, err := utils.NewRoomCodeGenerator(cfg.RoomCode.Alphabet, cfg.RoomCode.Pattern)
+	if err != nil {
+		logger.Error("configuring room codes", "err", err)
+		os.Exit(1)
+	}
 
-	router.Use(func(c *gin.Context) {
-		c.Set("db", client)
-		c.Next()
-	})
+	passwords, err := utils.NewPasswordHasher(cfg.Password)
+	if err != nil {
+		logger.Error("configuring password hashing", "err", err)
+		os.Exit(1)
+	}
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		logger.Error("configuring mailer", "err", err)
+		os.Exit(1)
+	}
+	if cfg.Mail.Driver != "smtp" {
+		logger.Warn("mail is not delivered", "driver", cfg.Mail.Driver)
+	}
 
-	router.POST("/createuser", controllers.CreateUser)
-	router.POST("/login", controllers.Login)
-	router.POST("/session", controllers.CreateSession)
-	router.POST("/sessionbyhost", controllers.GetSessionbyHost)
-	router.GET("/connect", controllers.GetSession)
-	router.POST("/connect/:url", controllers.ConnectSession)
+	auth := handlers.NewAuth(cfg.Auth)
+	sfu, err := handlers.NewSFU(cfg.WebRTC, auth, logger)
+	if err != nil {
+		logger.Error("configuring webrtc", "err", err)
+		os.Exit(1)
+	}
+	ctl := controllers.New(cfg, auth, sfu, stores, roomCodes, passwords, mail, sso.New(cfg.OIDC))
+	sfu.SetAdmission(ctl.AdmitRoom)
+
+	router.POST("/createuser", ctl.CreateUser)
+	router.POST("/login", ctl.Login)
+	router.POST("/refresh", ctl.Refresh)
+	router.POST("/logout", ctl.Logout)
+	router.POST("/verify-email", ctl.VerifyEmail)
+	router.POST("/password/forgot", ctl.ForgotPassword)
+	router.POST("/password/reset", ctl.ResetPassword)
+	router.GET("/auth/providers", ctl.ListSSOProviders)
+	router.GET("/auth/oidc/:provider/login", ctl.StartSSO)
+	router.GET("/auth/oidc/:provider/callback", ctl.FinishSSO)
+	router.POST("/auth/oidc/exchange", ctl.ExchangeSSOCode)
+	router.GET("/connect", ctl.GetSession)
+	router.POST("/connect/:url", ctl.ConnectSession)
+
+	authed := router.Group("/", middleware.RequireAuth(auth, stores.RevokedTokens))
+	authed.GET("/me", ctl.GetMe)
+	authed.PATCH("/me", ctl.UpdateMe)
+	authed.DELETE("/me", ctl.DeleteMe)
+	authed.POST("/me/password", ctl.ChangePassword)
+	authed.GET("/users/:id", ctl.GetUser)
+	authed.POST("/verify-email/resend", ctl.ResendVerification)
+	authed.POST("/session", ctl.CreateSession)
+	authed.POST("/sessionbyhost", ctl.GetSessionbyHost)
+	authed.GET("/sessions", ctl.ListSessions)
+	authed.GET("/sessions/:id", ctl.GetSessionByID)
+	authed.PATCH("/sessions/:id", ctl.UpdateSession)
+	authed.DELETE("/sessions/:id", ctl.DeleteSession)
+	authed.GET("/sessions/:id/ics", ctl.ExportSessionCalendar)
+	authed.POST("/sessions/:id/invite", ctl.InviteToSession)
+	authed.POST("/sessions/:id/join", ctl.JoinSession)
+	authed.GET("/sessions/:id/participants", ctl.ListParticipants)
 
 	router.GET("/", func(c *gin.Context) {
 		err := indexTemplate.Execute(c.Writer, nil)
 		if err != nil {
-			log.Fatalf("Error executing template: %v", err)
+			logging.FromContext(c).Error("executing template", "err", err)
 		}
 	})
 
 	router.GET("/websocket/:roomId", func(c *gin.Context) {
 		roomId := c.Param("roomId")
-		handlers.WebsocketHandler(c.Writer, c.Request, roomId)
+		sfu.WebsocketHandler(c.Writer, c.Request, roomId)
 	})
 
 	go func() {
-		for range time.NewTicker(time.Second * 3).C {
-			handlers.DispatchKeyFrame()
+		for range time.NewTicker(cfg.WebRTC.KeyframeInterval.Duration).C {
+			sfu.DispatchKeyFrame()
 		}
 	}()
 
-	if err := router.Run("0.0.0.0:" + getenv("PORT", "9000")); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
+	go func() {
+		for range time.NewTicker(cfg.WebRTC.SpeakerInterval.Duration).C {
+			sfu.DetectSpeakers()
+		}
+	}()
+
+	go func() {
+		for range time.NewTicker(30 * time.Second).C {
+			sfu.CloseExpiredRooms()
+		}
+	}()
 
-func getenv(key, fallback string) string {
-	value := os.Getenv(key)
-	if len(value) == 0 {
-		return fallback
+	if err := router.Run("0.0.0.0:" + cfg.Server.Port); err != nil {
+		logger.Error("server stopped", "err", err)
 	}
-	return value
 }
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"webrtc/apierror"
+	"webrtc/handlers"
+	"webrtc/logging"
+	"webrtc/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	userIDKey = "user_id"
+	claimsKey = "claims"
+)
+
+// RequireAuth - Rejects requests without a valid bearer access token, or
+// with one revoked by signing out, and stores the token claims on the
+// context.
+func RequireAuth(auth *handlers.Auth, revoked repository.RevokedTokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		encoded, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || encoded == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "Missing bearer token.")
+			return
+		}
+
+		claims, err := auth.ValidateToken(encoded)
+		if err != nil {
+			logging.FromContext(c).Info("rejected access token", "err", err)
+			c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid or expired token.")
+			return
+		}
+		denied, err := revoked.IsRevoked(c, claims.Id)
+		if err != nil {
+			logging.FromContext(c).Error("check revoked tokens", "err", err)
+			apierror.Internal(c)
+			c.Abort()
+			return
+		}
+		if denied {
+			logging.FromContext(c).Info("rejected revoked access token", "user_id", claims.UserID)
+			c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid or expired token.")
+			return
+		}
+
+		c.Set(userIDKey, claims.UserID)
+		c.Set(claimsKey, claims)
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(),
+			logging.FromContext(c).With("user_id", claims.UserID)))
+		c.Next()
+	}
+}
+
+// UserID - Returns the authenticated user's ID, or "" outside RequireAuth.
+func UserID(c *gin.Context) string {
+	return c.GetString(userIDKey)
+}
+
+// Claims - Returns the authenticated token claims, or nil outside RequireAuth.
+func Claims(c *gin.Context) *handlers.Claims {
+	claims, _ := c.Get(claimsKey)
+	cl, _ := claims.(*handlers.Claims)
+	return cl
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+	"webrtc/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader - Header used to propagate request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLogger - Assigns every request an ID, stores a request scoped
+// logger in the request context and logs the outcome of the request.
+func RequestLogger(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		logger := base.With("request_id", requestID)
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), logger))
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		logger.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// validRequestID - Accepts client supplied IDs only when they are short and
+// printable, so they can't be used to forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+// Package ratelimit slows down password guessing. Each failed attempt is
+// counted against one or more keys, such as the client IP and the account;
+// once a key runs out of free attempts it is locked for a delay that
+// doubles with every further failure.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+	"webrtc/config"
+	"webrtc/interfaces"
+	"webrtc/repository"
+)
+
+// Key - A rate limited identity and the policy that applies to it.
+type Key struct {
+	ID     string
+	Policy config.LimitPolicy
+}
+
+// Limiter - Applies policies to keys, counting in a shared store.
+type Limiter struct {
+	store repository.AttemptStore
+}
+
+// New - Creates a Limiter.
+func New(store repository.AttemptStore) *Limiter {
+	return &Limiter{store: store}
+}
+
+// RetryAfter - How long until every key may be tried again; zero if none is
+// locked.
+func (l *Limiter) RetryAfter(ctx context.Context, keys ...Key) (time.Duration, error) {
+	now := time.Now()
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempts, err := l.store.Get(ctx, key.ID)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, lockedFor(attempts, key.Policy, now))
+	}
+	return wait, nil
+}
+
+// Fail - Counts a failed attempt against every key and returns how long
+// until they may be tried again.
+func (l *Limiter) Fail(ctx context.Context, keys ...Key) (time.Duration, error) {
+	return l.FailN(ctx, 1, keys...)
+}
+
+// FailN - Like Fail, counting n attempts at once, such as emails sent
+// together.
+func (l *Limiter) FailN(ctx context.Context, n int, keys ...Key) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		p := key.Policy
+		expiresAt := now.Add(max(p.Window.Duration, p.MaxDelay.Duration))
+		attempts, err := l.store.Fail(ctx, key.ID, n, now, p.Window.Duration, expiresAt)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, lockedFor(attempts, p, now))
+	}
+	return wait, nil
+}
+
+// Remaining - How many free attempts are left before any of the keys is
+// locked.
+func (l *Limiter) Remaining(ctx context.Context, keys ...Key) (int, error) {
+	now := time.Now()
+	remaining := math.MaxInt
+	for _, key := range keys {
+		attempts, err := l.store.Get(ctx, key.ID)
+		if err != nil {
+			return 0, err
+		}
+		failures := attempts.Failures
+		if !attempts.LastFailure.After(now.Add(-key.Policy.Window.Duration)) {
+			failures = 0
+		}
+		remaining = min(remaining, max(key.Policy.FreeAttempts-failures, 0))
+	}
+	return remaining, nil
+}
+
+// Reset - Forgets the failures of every key, after a successful attempt.
+func (l *Limiter) Reset(ctx context.Context, keys ...Key) error {
+	for _, key := range keys {
+		if err := l.store.Reset(ctx, key.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockedFor - Remaining lockout of a key at now.
+func lockedFor(attempts interfaces.Attempts, p config.LimitPolicy, now time.Time) time.Duration {
+	excess := attempts.Failures - p.FreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay.Duration
+	for i := 1; i < excess && delay < p.MaxDelay.Duration; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay.Duration)
+
+	return max(attempts.LastFailure.Add(delay).Sub(now), 0)
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+	"webrtc/config"
+	"webrtc/interfaces"
+	"webrtc/repository"
+)
+
+var testPolicy = config.LimitPolicy{
+	FreeAttempts: 3,
+	BaseDelay:    config.Duration{Duration: time.Second},
+	MaxDelay:     config.Duration{Duration: 10 * time.Second},
+	Window:       config.Duration{Duration: time.Minute},
+}
+
+func TestLockedFor(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		failures int
+		ago      time.Duration
+		want     time.Duration
+	}{
+		{0, 0, 0},
+		{3, 0, 0}, // the free attempts
+		{4, 0, time.Second},
+		{5, 0, 2 * time.Second},
+		{6, 0, 4 * time.Second},
+		{7, 0, 8 * time.Second},
+		{8, 0, 10 * time.Second}, // capped
+		{100, 0, 10 * time.Second},
+		{5, 500 * time.Millisecond, 1500 * time.Millisecond}, // partly waited out
+		{5, 3 * time.Second, 0},                              // fully waited out
+	} {
+		attempts := interfaces.Attempts{Failures: tc.failures, LastFailure: now.Add(-tc.ago)}
+		if got := lockedFor(attempts, testPolicy, now); got != tc.want {
+			t.Errorf("lockedFor(%d failures, %s ago) = %s, want %s", tc.failures, tc.ago, got, tc.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := New(repository.NewMemoryAttemptStore())
+	ip := Key{ID: "ip", Policy: testPolicy}
+	account := Key{ID: "account", Policy: config.LimitPolicy{
+		FreeAttempts: 1,
+		BaseDelay:    config.Duration{Duration: time.Minute},
+		MaxDelay:     config.Duration{Duration: time.Hour},
+		Window:       config.Duration{Duration: time.Hour},
+	}}
+
+	if wait, err := l.Fail(ctx, ip, account); err != nil || wait != 0 {
+		t.Fatalf("first failure: wait %s, err %v", wait, err)
+	}
+
+	// The stricter key decides
+	wait, err := l.Fail(ctx, ip, account)
+	if err != nil || wait < 59*time.Second || wait > time.Minute {
+		t.Fatalf("second failure: wait %s, err %v; want about a minute", wait, err)
+	}
+	if wait, _ := l.RetryAfter(ctx, ip); wait != 0 {
+		t.Errorf("ip alone locked for %s after 2 of 3 free failures", wait)
+	}
+	if wait, _ := l.RetryAfter(ctx, ip, account); wait < 59*time.Second {
+		t.Errorf("RetryAfter = %s, want about a minute", wait)
+	}
+
+	// Success clears only the keys it is given
+	if err := l.Reset(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.RetryAfter(ctx, account); wait != 0 {
+		t.Errorf("account still locked for %s after reset", wait)
+	}
+	if wait, _ := l.Fail(ctx, ip); wait != 0 {
+		t.Errorf("third ip failure locked for %s, want free", wait)
+	}
+	if wait, _ := l.Fail(ctx, ip); wait < 900*time.Millisecond || wait > time.Second {
+		t.Errorf("fourth ip failure locked for %s, want about a second", wait)
+	}
+}
+
+func TestFailuresOutsideWindowAreForgotten(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryAttemptStore()
+
+	now := time.Now()
+	window := time.Minute
+	for i := 0; i < 5; i++ {
+		if _, err := store.Fail(ctx, "key", 1, now.Add(-2*window), window, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attempts, err := store.Fail(ctx, "key", 1, now, window, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 1 {
+		t.Errorf("failures = %d after the window passed, want 1", attempts.Failures)
+	}
+}
+
+func TestFailNAndRemaining(t *testing.T) {
+	ctx := context.Background()
+	l := New(repository.NewMemoryAttemptStore())
+	key := Key{ID: "mail", Policy: testPolicy}
+
+	if remaining, err := l.Remaining(ctx, key); err != nil || remaining != 3 {
+		t.Fatalf("Remaining = %d, %v; want 3", remaining, err)
+	}
+	if wait, err := l.FailN(ctx, 2, key); err != nil || wait != 0 {
+		t.Fatalf("FailN(2): wait %s, err %v", wait, err)
+	}
+	if remaining, _ := l.Remaining(ctx, key); remaining != 1 {
+		t.Errorf("Remaining = %d after 2 of 3, want 1", remaining)
+	}
+	if wait, _ := l.FailN(ctx, 3, key); wait < 1900*time.Millisecond || wait > 2*time.Second {
+		t.Errorf("FailN(3) past the free attempts locked for %s, want about 2s", wait)
+	}
+	if remaining, _ := l.Remaining(ctx, key); remaining != 0 {
+		t.Errorf("Remaining = %d when used up, want 0", remaining)
+	}
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"webrtc/interfaces"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMemoryAttemptStore - Creates an attempt store local to this process,
+// for deployments that keep rate limit counters per instance.
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{attempts: make(map[string]interfaces.Attempts)}
+}
+
+type mongoAttemptStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoAttemptStore) Get(ctx context.Context, key string) (interfaces.Attempts, error) {
+	var attempts interfaces.Attempts
+	err := findOne(ctx, s.collection, bson.M{"_id": key}, &attempts)
+	if errors.Is(err, ErrNotFound) {
+		return interfaces.Attempts{Key: key}, nil
+	}
+	return attempts, err
+}
+
+func (s *mongoAttemptStore) Fail(ctx context.Context, key string, n int, now time.Time, window time.Duration, expiresAt time.Time) (interfaces.Attempts, error) {
+	// A pipeline update so the window check and the increment happen in one
+	// atomic step, even with several instances counting the same key.
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "failures", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$gt", Value: bson.A{"$lastfailure", now.Add(-window)}}},
+				bson.D{{Key: "$add", Value: bson.A{"$failures", n}}},
+				n,
+			}}}},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "lastfailure", Value: now},
+			{Key: "expiresat", Value: expiresAt},
+		}}},
+	}
+
+	var attempts interfaces.Attempts
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	return attempts, err
+}
+
+func (s *mongoAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// memorySweepInterval - How often expired records are dropped.
+const memorySweepInterval = time.Minute
+
+type memoryAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]interfaces.Attempts
+	lastSweep time.Time
+}
+
+func (s *memoryAttemptStore) Get(_ context.Context, key string) (interfaces.Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok || !time.Now().Before(attempts.ExpiresAt) {
+		return interfaces.Attempts{Key: key}, nil
+	}
+	return attempts, nil
+}
+
+func (s *memoryAttemptStore) Fail(_ context.Context, key string, n int, now time.Time, window time.Duration, expiresAt time.Time) (interfaces.Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked(now)
+
+	attempts := s.attempts[key]
+	if !attempts.LastFailure.After(now.Add(-window)) {
+		attempts = interfaces.Attempts{Key: key}
+	}
+	attempts.Failures += n
+	attempts.LastFailure = now
+	attempts.ExpiresAt = expiresAt
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *memoryAttemptStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// sweepLocked - Drops expired records so keys from one-off clients don't
+// accumulate.
+func (s *memoryAttemptStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, attempts := range s.attempts {
+		if !now.Before(attempts.ExpiresAt) {
+			delete(s.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index names, also used to report which field a duplicate key hit.
+const (
+	indexUserEmail       = "users_email_unique"
+	indexUserName        = "users_username_unique"
+	indexUserIdentity    = "users_identity_unique"
+	indexSocketHashedURL = "sockets_hashedurl_unique"
+	indexSessionHost     = "sessions_host"
+)
+
+// duplicateFields - Maps unique index names to the field they protect.
+var duplicateFields = map[string]string{
+	indexUserEmail:       "email",
+	indexUserName:        "username",
+	indexUserIdentity:    "identity",
+	indexSocketHashedURL: "hashedurl",
+}
+
+// EnsureIndexes - Creates the indexes the stores rely on. Creating an index
+// that already exists is a no-op, so this is safe to run on every start.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		"users": {
+			{
+				// Emails are stored lower-cased, see NormalizeEmail, so
+				// addresses differing in case collide here too.
+				Keys:    bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetName(indexUserEmail).SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "username", Value: 1}},
+				Options: options.Index().SetName(indexUserName).SetUnique(true),
+			},
+			{
+				// Users without linked identities are left out, or they
+				// would all collide on a null key.
+				Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+				Options: options.Index().SetName(indexUserIdentity).SetUnique(true).
+					SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+			},
+		},
+		"sockets": {
+			{
+				Keys:    bson.D{{Key: "hashedurl", Value: 1}},
+				Options: options.Index().SetName(indexSocketHashedURL).SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "sessionid", Value: 1}},
+			},
+			{
+				Keys: bson.D{{Key: "socketurl", Value: 1}},
+			},
+		},
+		"refresh_tokens": {
+			{
+				Keys:    bson.D{{Key: "tokenhash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "family", Value: 1}},
+			},
+			{
+				Keys: bson.D{{Key: "userid", Value: 1}},
+			},
+			{
+				// Let MongoDB purge expired tokens.
+				Keys:    bson.D{{Key: "expiresat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"revoked_tokens": {
+			{
+				Keys:    bson.D{{Key: "expiresat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"action_tokens": {
+			{
+				Keys:    bson.D{{Key: "tokenhash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "userid", Value: 1}, {Key: "purpose", Value: 1}},
+			},
+			{
+				Keys:    bson.D{{Key: "expiresat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"login_attempts": {
+			{
+				Keys:    bson.D{{Key: "expiresat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"sessions": {
+			{
+				Keys:    bson.D{{Key: "host", Value: 1}, {Key: "_id", Value: -1}},
+				Options: options.Index().SetName(indexSessionHost),
+			},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes on %s: %w", collection, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,445 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"webrtc/interfaces"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemory - Creates stores that keep everything in process memory. Useful
+// for tests and local development without MongoDB.
+func NewMemory() *Stores {
+	return &Stores{
+		Users:    &memoryUserStore{users: make(map[string]interfaces.User)},
+		Sessions: &memorySessionStore{sessions: make(map[string]interfaces.Session)},
+		Sockets:  &memorySocketStore{},
+
+		RefreshTokens: &memoryRefreshTokenStore{tokens: make(map[string]interfaces.RefreshToken)},
+		RevokedTokens: &memoryRevokedTokenStore{tokens: make(map[string]time.Time)},
+		ActionTokens:  &memoryActionTokenStore{tokens: make(map[string]interfaces.ActionToken)},
+		Attempts:      NewMemoryAttemptStore(),
+	}
+}
+
+type memoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]interfaces.User
+}
+
+func (s *memoryUserStore) Create(_ context.Context, user *interfaces.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.Email = NormalizeEmail(user.Email)
+	for _, existing := range s.users {
+		switch {
+		case existing.Email == user.Email:
+			return &DuplicateError{Field: "email"}
+		case existing.UserName == user.UserName:
+			return &DuplicateError{Field: "username"}
+		case sharesIdentity(existing, *user):
+			return &DuplicateError{Field: "identity"}
+		}
+	}
+
+	user.ID = primitive.NewObjectID().Hex()
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *memoryUserStore) FindByID(_ context.Context, id string) (interfaces.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryUserStore) FindByEmail(_ context.Context, email string) (interfaces.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	email = NormalizeEmail(email)
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return interfaces.User{}, ErrNotFound
+}
+
+func (s *memoryUserStore) FindByIdentity(_ context.Context, provider, subject string) (interfaces.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	want := interfaces.Identity{Provider: provider, Subject: subject}
+	for _, user := range s.users {
+		if slices.Contains(user.Identities, want) {
+			return user, nil
+		}
+	}
+	return interfaces.User{}, ErrNotFound
+}
+
+func (s *memoryUserStore) Update(_ context.Context, user interfaces.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+	user.Email = NormalizeEmail(user.Email)
+	for id, existing := range s.users {
+		switch {
+		case id == user.ID:
+		case existing.Email == user.Email:
+			return &DuplicateError{Field: "email"}
+		case existing.UserName == user.UserName:
+			return &DuplicateError{Field: "username"}
+		case sharesIdentity(existing, user):
+			return &DuplicateError{Field: "identity"}
+		}
+	}
+
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *memoryUserStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// sharesIdentity - Reports whether a provider account is linked to both
+// users.
+func sharesIdentity(a, b interfaces.User) bool {
+	for _, identity := range a.Identities {
+		if slices.Contains(b.Identities, identity) {
+			return true
+		}
+	}
+	return false
+}
+
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]interfaces.Session
+}
+
+func (s *memorySessionStore) Create(_ context.Context, session *interfaces.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.ID = primitive.NewObjectID().Hex()
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *memorySessionStore) FindByID(_ context.Context, id string) (interfaces.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return session, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *memorySessionStore) List(_ context.Context, opts SessionListOptions) ([]interfaces.Session, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	needle := strings.ToLower(opts.TitleContains)
+	var matches []interfaces.Session
+	for _, session := range s.sessions {
+		if session.Host == opts.Host && strings.Contains(strings.ToLower(session.Title), needle) {
+			matches = append(matches, session)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if opts.Descending {
+			a, b = b, a
+		}
+		if opts.SortBy == SortTitle && a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.ID < b.ID
+	})
+
+	total := int64(len(matches))
+	start := min(opts.Skip, total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+	return matches[start:end], total, nil
+}
+
+func (s *memorySessionStore) Update(_ context.Context, session interfaces.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[session.ID]; !ok {
+		return ErrNotFound
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *memorySessionStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memorySessionStore) FindByHost(_ context.Context, host string) ([]interfaces.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []interfaces.Session
+	for _, session := range s.sessions {
+		if session.Host == host {
+			sessions = append(sessions, session)
+		}
+	}
+
+	// ObjectID hex strings sort by creation time.
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID > sessions[j].ID })
+	return sessions, nil
+}
+
+type memorySocketStore struct {
+	mu      sync.RWMutex
+	sockets []interfaces.Socket
+}
+
+func (s *memorySocketStore) Create(_ context.Context, socket *interfaces.Socket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.sockets {
+		if existing.HashedURL == socket.HashedURL {
+			return &DuplicateError{Field: "hashedurl"}
+		}
+	}
+
+	s.sockets = append(s.sockets, *socket)
+	return nil
+}
+
+func (s *memorySocketStore) FindByHashedURL(_ context.Context, hashedURL string) (interfaces.Socket, error) {
+	return s.find(func(socket interfaces.Socket) bool { return socket.HashedURL == hashedURL })
+}
+
+func (s *memorySocketStore) FindBySessionID(_ context.Context, sessionID string) (interfaces.Socket, error) {
+	return s.find(func(socket interfaces.Socket) bool { return socket.SessionID == sessionID })
+}
+
+func (s *memorySocketStore) FindBySocketURL(_ context.Context, socketURL string) (interfaces.Socket, error) {
+	return s.find(func(socket interfaces.Socket) bool { return socket.SocketURL == socketURL })
+}
+
+func (s *memorySocketStore) DeleteBySessionID(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.sockets[:0]
+	for _, socket := range s.sockets {
+		if socket.SessionID != sessionID {
+			kept = append(kept, socket)
+		}
+	}
+	s.sockets = kept
+	return nil
+}
+
+func (s *memorySocketStore) find(match func(interfaces.Socket) bool) (interfaces.Socket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, socket := range s.sockets {
+		if match(socket) {
+			return socket, nil
+		}
+	}
+	return interfaces.Socket{}, ErrNotFound
+}
+
+type memoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]interfaces.RefreshToken // keyed by hash
+}
+
+func (s *memoryRefreshTokenStore) Create(_ context.Context, token *interfaces.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.TokenHash]; ok {
+		return &DuplicateError{Field: "tokenhash"}
+	}
+	token.ID = primitive.NewObjectID().Hex()
+	s.tokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) FindByHash(_ context.Context, tokenHash string) (interfaces.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok {
+		return token, ErrNotFound
+	}
+	return token, nil
+}
+
+func (s *memoryRefreshTokenStore) Revoke(_ context.Context, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok || token.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	s.tokens[tokenHash] = token
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeFamily(_ context.Context, family string) error {
+	s.revokeWhere(func(token interfaces.RefreshToken) bool { return token.Family == family })
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeUser(_ context.Context, userID string) error {
+	s.revokeWhere(func(token interfaces.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) FindByFamily(_ context.Context, family string) ([]interfaces.RefreshToken, error) {
+	return s.findWhere(func(token interfaces.RefreshToken) bool { return token.Family == family }), nil
+}
+
+func (s *memoryRefreshTokenStore) FindByUser(_ context.Context, userID string) ([]interfaces.RefreshToken, error) {
+	return s.findWhere(func(token interfaces.RefreshToken) bool { return token.UserID == userID }), nil
+}
+
+func (s *memoryRefreshTokenStore) findWhere(match func(interfaces.RefreshToken) bool) []interfaces.RefreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []interfaces.RefreshToken
+	for _, token := range s.tokens {
+		if match(token) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func (s *memoryRefreshTokenStore) revokeWhere(match func(interfaces.RefreshToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.tokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			s.tokens[hash] = token
+		}
+	}
+}
+
+type memoryRevokedTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time // expiry by token ID
+}
+
+func (s *memoryRevokedTokenStore) Revoke(_ context.Context, tokenID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, expiry := range s.tokens {
+		if !now.Before(expiry) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[tokenID] = expiresAt
+	return nil
+}
+
+func (s *memoryRevokedTokenStore) IsRevoked(_ context.Context, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.tokens[tokenID]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+type memoryActionTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]interfaces.ActionToken // keyed by hash
+}
+
+func (s *memoryActionTokenStore) Create(_ context.Context, token *interfaces.ActionToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.TokenHash]; ok {
+		return &DuplicateError{Field: "tokenhash"}
+	}
+	token.ID = primitive.NewObjectID().Hex()
+	s.tokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *memoryActionTokenStore) Consume(_ context.Context, tokenHash, purpose string) (interfaces.ActionToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	token, ok := s.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return interfaces.ActionToken{}, ErrNotFound
+	}
+	token.UsedAt = &now
+	s.tokens[tokenHash] = token
+	return token, nil
+}
+
+func (s *memoryActionTokenStore) DeleteUser(_ context.Context, userID, purpose string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.tokens {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
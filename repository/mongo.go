@@ -0,0 +1,399 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"webrtc/interfaces"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongo - Creates stores backed by the given MongoDB database.
+func NewMongo(db *mongo.Database) *Stores {
+	return &Stores{
+		Users:    &mongoUserStore{db.Collection("users")},
+		Sessions: &mongoSessionStore{db.Collection("sessions")},
+		Sockets:  &mongoSocketStore{db.Collection("sockets")},
+
+		RefreshTokens: &mongoRefreshTokenStore{db.Collection("refresh_tokens")},
+		RevokedTokens: &mongoRevokedTokenStore{db.Collection("revoked_tokens")},
+		ActionTokens:  &mongoActionTokenStore{db.Collection("action_tokens")},
+		Attempts:      &mongoAttemptStore{db.Collection("login_attempts")},
+	}
+}
+
+type mongoUserStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoUserStore) Create(ctx context.Context, user *interfaces.User) error {
+	user.Email = NormalizeEmail(user.Email)
+	id, err := insert(ctx, s.collection, user)
+	if err != nil {
+		return err
+	}
+	user.ID = id
+	return nil
+}
+
+func (s *mongoUserStore) FindByID(ctx context.Context, id string) (interfaces.User, error) {
+	var user interfaces.User
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return user, ErrNotFound
+	}
+	err = findOne(ctx, s.collection, bson.M{"_id": objectID}, &user)
+	return user, err
+}
+
+func (s *mongoUserStore) FindByEmail(ctx context.Context, email string) (interfaces.User, error) {
+	var user interfaces.User
+	err := findOne(ctx, s.collection, bson.M{"email": NormalizeEmail(email)}, &user)
+	return user, err
+}
+
+func (s *mongoUserStore) FindByIdentity(ctx context.Context, provider, subject string) (interfaces.User, error) {
+	var user interfaces.User
+	err := findOne(ctx, s.collection, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}, &user)
+	return user, err
+}
+
+func (s *mongoUserStore) Update(ctx context.Context, user interfaces.User) error {
+	id := user.ID
+	user.ID = ""
+	user.Email = NormalizeEmail(user.Email)
+	return replaceByID(ctx, s.collection, id, user)
+}
+
+func (s *mongoUserStore) Delete(ctx context.Context, id string) error {
+	return deleteByID(ctx, s.collection, id)
+}
+
+type mongoSessionStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoSessionStore) Create(ctx context.Context, session *interfaces.Session) error {
+	id, err := insert(ctx, s.collection, session)
+	if err != nil {
+		return err
+	}
+	session.ID = id
+	return nil
+}
+
+func (s *mongoSessionStore) FindByID(ctx context.Context, id string) (interfaces.Session, error) {
+	var session interfaces.Session
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return session, ErrNotFound
+	}
+	err = findOne(ctx, s.collection, bson.M{"_id": objectID}, &session)
+	return session, err
+}
+
+func (s *mongoSessionStore) List(ctx context.Context, opts SessionListOptions) ([]interfaces.Session, int64, error) {
+	filter := bson.M{"host": opts.Host}
+	if opts.TitleContains != "" {
+		filter["title"] = primitive.Regex{Pattern: regexp.QuoteMeta(opts.TitleContains), Options: "i"}
+	}
+
+	total, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := 1
+	if opts.Descending {
+		order = -1
+	}
+	sort := bson.D{{Key: "_id", Value: order}}
+	if opts.SortBy == SortTitle {
+		sort = bson.D{{Key: "title", Value: order}, {Key: "_id", Value: order}}
+	}
+
+	findOptions := options.Find().SetSort(sort).SetSkip(opts.Skip).SetLimit(opts.Limit)
+	cursor, err := s.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []interfaces.Session
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, 0, err
+	}
+	return sessions, total, nil
+}
+
+func (s *mongoSessionStore) Update(ctx context.Context, session interfaces.Session) error {
+	id := session.ID
+	session.ID = ""
+	return replaceByID(ctx, s.collection, id, session)
+}
+
+func (s *mongoSessionStore) Delete(ctx context.Context, id string) error {
+	return deleteByID(ctx, s.collection, id)
+}
+
+func (s *mongoSessionStore) FindByHost(ctx context.Context, host string) ([]interfaces.Session, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+
+	cursor, err := s.collection.Find(ctx, bson.M{"host": host}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []interfaces.Session
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+type mongoSocketStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoSocketStore) Create(ctx context.Context, socket *interfaces.Socket) error {
+	_, err := s.collection.InsertOne(ctx, socket)
+	return mapWriteError(err)
+}
+
+func (s *mongoSocketStore) FindByHashedURL(ctx context.Context, hashedURL string) (interfaces.Socket, error) {
+	var socket interfaces.Socket
+	err := findOne(ctx, s.collection, bson.M{"hashedurl": hashedURL}, &socket)
+	return socket, err
+}
+
+func (s *mongoSocketStore) FindBySessionID(ctx context.Context, sessionID string) (interfaces.Socket, error) {
+	var socket interfaces.Socket
+	err := findOne(ctx, s.collection, bson.M{"sessionid": sessionID}, &socket)
+	return socket, err
+}
+
+func (s *mongoSocketStore) FindBySocketURL(ctx context.Context, socketURL string) (interfaces.Socket, error) {
+	var socket interfaces.Socket
+	err := findOne(ctx, s.collection, bson.M{"socketurl": socketURL}, &socket)
+	return socket, err
+}
+
+func (s *mongoSocketStore) DeleteBySessionID(ctx context.Context, sessionID string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"sessionid": sessionID})
+	return err
+}
+
+type mongoRefreshTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRefreshTokenStore) Create(ctx context.Context, token *interfaces.RefreshToken) error {
+	id, err := insert(ctx, s.collection, token)
+	if err != nil {
+		return err
+	}
+	token.ID = id
+	return nil
+}
+
+func (s *mongoRefreshTokenStore) FindByHash(ctx context.Context, tokenHash string) (interfaces.RefreshToken, error) {
+	var token interfaces.RefreshToken
+	err := findOne(ctx, s.collection, bson.M{"tokenhash": tokenHash}, &token)
+	return token, err
+}
+
+func (s *mongoRefreshTokenStore) Revoke(ctx context.Context, tokenHash string) error {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"tokenhash": tokenHash, "revokedat": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedat": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoRefreshTokenStore) RevokeFamily(ctx context.Context, family string) error {
+	return s.revokeMany(ctx, bson.M{"family": family})
+}
+
+func (s *mongoRefreshTokenStore) RevokeUser(ctx context.Context, userID string) error {
+	return s.revokeMany(ctx, bson.M{"userid": userID})
+}
+
+func (s *mongoRefreshTokenStore) FindByFamily(ctx context.Context, family string) ([]interfaces.RefreshToken, error) {
+	return s.findMany(ctx, bson.M{"family": family})
+}
+
+func (s *mongoRefreshTokenStore) FindByUser(ctx context.Context, userID string) ([]interfaces.RefreshToken, error) {
+	return s.findMany(ctx, bson.M{"userid": userID})
+}
+
+func (s *mongoRefreshTokenStore) findMany(ctx context.Context, filter bson.M) ([]interfaces.RefreshToken, error) {
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []interfaces.RefreshToken
+	if err = cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *mongoRefreshTokenStore) revokeMany(ctx context.Context, filter bson.M) error {
+	filter["revokedat"] = bson.M{"$exists": false}
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedat": time.Now()}})
+	return err
+}
+
+type mongoRevokedTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRevokedTokenStore) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": tokenID},
+		bson.M{"$set": bson.M{"expiresat": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *mongoRevokedTokenStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	// Checks the expiry too, as MongoDB purges expired documents lazily
+	var token interfaces.RevokedToken
+	err := findOne(ctx, s.collection, bson.M{"_id": tokenID, "expiresat": bson.M{"$gt": time.Now()}}, &token)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type mongoActionTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoActionTokenStore) Create(ctx context.Context, token *interfaces.ActionToken) error {
+	id, err := insert(ctx, s.collection, token)
+	if err != nil {
+		return err
+	}
+	token.ID = id
+	return nil
+}
+
+func (s *mongoActionTokenStore) Consume(ctx context.Context, tokenHash, purpose string) (interfaces.ActionToken, error) {
+	now := time.Now()
+	filter := bson.M{
+		"tokenhash": tokenHash,
+		"purpose":   purpose,
+		"usedat":    bson.M{"$exists": false},
+		"expiresat": bson.M{"$gt": now},
+	}
+
+	var token interfaces.ActionToken
+	err := s.collection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"usedat": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return token, ErrNotFound
+	}
+	return token, err
+}
+
+func (s *mongoActionTokenStore) DeleteUser(ctx context.Context, userID, purpose string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"userid": userID, "purpose": purpose})
+	return err
+}
+
+// insert - Inserts a document and returns its generated ID as a hex string.
+func insert(ctx context.Context, collection *mongo.Collection, doc interface{}) (string, error) {
+	result, err := collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", mapWriteError(err)
+	}
+
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("unexpected insertion result")
+	}
+	return objectID.Hex(), nil
+}
+
+// replaceByID - Replaces the document with the given hex ID. The document
+// must not carry its own ID.
+func replaceByID(ctx context.Context, collection *mongo.Collection, id string, doc interface{}) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": objectID}, doc)
+	if err != nil {
+		return mapWriteError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deleteByID - Deletes the document with the given hex ID.
+func deleteByID(ctx context.Context, collection *mongo.Collection, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// findOne - Decodes the first matching document, mapping a miss to ErrNotFound.
+func findOne(ctx context.Context, collection *mongo.Collection, filter interface{}, out interface{}) error {
+	err := collection.FindOne(ctx, filter).Decode(out)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// mapWriteError - Converts duplicate key violations into a DuplicateError.
+func mapWriteError(err error) error {
+	if err == nil || !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, we := range writeErr.WriteErrors {
+			for index, field := range duplicateFields {
+				if strings.Contains(we.Message, "index: "+index+" ") {
+					return &DuplicateError{Field: field}
+				}
+			}
+		}
+	}
+	return &DuplicateError{}
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"webrtc/interfaces"
+)
+
+var (
+	// ErrNotFound - Returned when no document matches a lookup.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate - Returned when an insert violates a uniqueness constraint.
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+// DuplicateError - Reports which unique field an insert collided on.
+// It matches ErrDuplicate with errors.Is.
+type DuplicateError struct {
+	Field string
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Field == "" {
+		return ErrDuplicate.Error()
+	}
+	return "duplicate " + e.Field
+}
+
+// Is - Lets errors.Is(err, ErrDuplicate) match.
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// NormalizeEmail - The form emails are stored and looked up in. Addresses
+// differing only in case belong to the same account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UserStore - Persists user accounts. Emails are normalized with
+// NormalizeEmail on create, update and lookup.
+type UserStore interface {
+	// Create stores the user and sets its ID.
+	Create(ctx context.Context, user *interfaces.User) error
+	FindByID(ctx context.Context, id string) (interfaces.User, error)
+	FindByEmail(ctx context.Context, email string) (interfaces.User, error)
+	// FindByIdentity finds the user a provider account is linked to.
+	FindByIdentity(ctx context.Context, provider, subject string) (interfaces.User, error)
+	// Update replaces the stored user with the same ID.
+	Update(ctx context.Context, user interfaces.User) error
+	Delete(ctx context.Context, id string) error
+}
+
+// SessionStore - Persists meeting sessions.
+type SessionStore interface {
+	// Create stores the session and sets its ID.
+	Create(ctx context.Context, session *interfaces.Session) error
+	FindByID(ctx context.Context, id string) (interfaces.Session, error)
+	// FindByHost returns the host's sessions, newest first.
+	FindByHost(ctx context.Context, host string) ([]interfaces.Session, error)
+	// List returns one page of sessions matching opts and the total number
+	// of matches.
+	List(ctx context.Context, opts SessionListOptions) ([]interfaces.Session, int64, error)
+	// Update replaces the stored session with the same ID.
+	Update(ctx context.Context, session interfaces.Session) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Sort fields accepted by SessionStore.List.
+const (
+	SortCreated = "created"
+	SortTitle   = "title"
+)
+
+// SessionListOptions - Filter, sort and paging for SessionStore.List.
+type SessionListOptions struct {
+	Host          string
+	TitleContains string // case-insensitive substring match
+	SortBy        string // SortCreated or SortTitle
+	Descending    bool
+	Skip          int64
+	Limit         int64
+}
+
+// SocketStore - Persists the socket documents linking room codes to sessions.
+type SocketStore interface {
+	Create(ctx context.Context, socket *interfaces.Socket) error
+	FindByHashedURL(ctx context.Context, hashedURL string) (interfaces.Socket, error)
+	FindBySessionID(ctx context.Context, sessionID string) (interfaces.Socket, error)
+	FindBySocketURL(ctx context.Context, socketURL string) (interfaces.Socket, error)
+	DeleteBySessionID(ctx context.Context, sessionID string) error
+}
+
+// RefreshTokenStore - Persists refresh tokens by hash.
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token *interfaces.RefreshToken) error
+	FindByHash(ctx context.Context, tokenHash string) (interfaces.RefreshToken, error)
+	// Revoke marks the token revoked. It returns ErrNotFound if the token
+	// does not exist or was already revoked, so concurrent rotations of the
+	// same token can't both succeed.
+	Revoke(ctx context.Context, tokenHash string) error
+	RevokeFamily(ctx context.Context, family string) error
+	RevokeUser(ctx context.Context, userID string) error
+	FindByFamily(ctx context.Context, family string) ([]interfaces.RefreshToken, error)
+	FindByUser(ctx context.Context, userID string) ([]interfaces.RefreshToken, error)
+}
+
+// RevokedTokenStore - Denies access tokens revoked before they expire.
+type RevokedTokenStore interface {
+	// Revoke denies the token ID until expiresAt. Revoking a token twice
+	// is not an error.
+	Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// ActionTokenStore - Persists single-use mailed tokens by hash.
+type ActionTokenStore interface {
+	Create(ctx context.Context, token *interfaces.ActionToken) error
+	// Consume marks the token used and returns it. It returns ErrNotFound if
+	// the token does not exist, has another purpose, expired or was already
+	// used.
+	Consume(ctx context.Context, tokenHash, purpose string) (interfaces.ActionToken, error)
+	// DeleteUser removes the user's outstanding tokens for purpose.
+	DeleteUser(ctx context.Context, userID, purpose string) error
+}
+
+// AttemptStore - Counts failed attempts per key for rate limiting.
+type AttemptStore interface {
+	// Get returns the key's record, or a zero record if it has none.
+	Get(ctx context.Context, key string) (interfaces.Attempts, error)
+	// Fail adds n failures at now and returns the updated record. Failures
+	// older than window are forgotten first. The record is kept until
+	// expiresAt.
+	Fail(ctx context.Context, key string, n int, now time.Time, window time.Duration, expiresAt time.Time) (interfaces.Attempts, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// Stores - The set of stores used by the controllers.
+type Stores struct {
+	Users         UserStore
+	Sessions      SessionStore
+	Sockets       SocketStore
+	RefreshTokens RefreshTokenStore
+	RevokedTokens RevokedTokenStore
+	ActionTokens  ActionTokenStore
+	Attempts      AttemptStore
+}
@@ -0,0 +1,233 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrTooEarly - The next occurrence has not opened for joining yet.
+	ErrTooEarly = errors.New("session has not started yet")
+	// ErrEnded - No occurrence is current or upcoming.
+	ErrEnded = errors.New("session has ended")
+)
+
+// maxIterations bounds how many candidate occurrences are generated while
+// searching, so a malformed rule can't spin forever.
+const maxIterations = 100000
+
+// Occurrence - A single meeting slot.
+type Occurrence struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Schedule - A meeting time, optionally repeating.
+type Schedule struct {
+	Start    time.Time
+	End      time.Time
+	Rule     *Rule // nil for a one-off meeting
+	Location *time.Location
+}
+
+// New - Builds a schedule. rrule and tz may be empty; tz is the IANA zone
+// recurrences are expanded in so they keep their wall clock time across
+// daylight saving changes.
+func New(start, end time.Time, rrule, tz string) (*Schedule, error) {
+	if !end.After(start) {
+		return nil, errors.New("end must be after start")
+	}
+
+	loc := time.UTC
+	if tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q", tz)
+		}
+		loc = l
+	}
+
+	s := &Schedule{Start: start, End: end, Location: loc}
+	if rrule != "" {
+		rule, err := ParseRule(rrule)
+		if err != nil {
+			return nil, err
+		}
+		s.Rule = rule
+	}
+	return s, nil
+}
+
+// NextOccurrence - Returns the first occurrence that ends after t.
+func (s *Schedule) NextOccurrence(t time.Time) (Occurrence, bool) {
+	var found Occurrence
+	ok := false
+	s.each(t, func(o Occurrence) bool {
+		found, ok = o, true
+		return false
+	})
+	return found, ok
+}
+
+// Check - Decides whether a participant may join at now. Joining opens
+// early before an occurrence starts and stays open for grace after it ends.
+// On success the returned occurrence is the one being joined.
+func (s *Schedule) Check(now time.Time, early, grace time.Duration) (Occurrence, error) {
+	o, ok := s.NextOccurrence(now.Add(-grace))
+	if !ok {
+		return Occurrence{}, ErrEnded
+	}
+	if now.Before(o.Start.Add(-early)) {
+		return o, ErrTooEarly
+	}
+	return o, nil
+}
+
+// each - Calls fn for every occurrence that ends after from, in order,
+// until fn returns false. Repetitions that ended well before from are
+// skipped without being generated, so rules that started long ago cost no
+// more than new ones.
+func (s *Schedule) each(from time.Time, fn func(Occurrence) bool) {
+	duration := s.End.Sub(s.Start)
+	start := s.Start.In(s.Location)
+	r := s.Rule
+
+	first := 0
+	emitted := 0
+	if r != nil {
+		first = s.firstPeriod(start, from.Add(-duration))
+		if r.Count > 0 {
+			emitted = s.countBefore(start, first)
+		}
+	}
+	emit := func(start time.Time) bool {
+		if r != nil {
+			if r.Count > 0 && emitted >= r.Count {
+				return false
+			}
+			if !r.Until.IsZero() && start.After(r.Until) {
+				return false
+			}
+		}
+		emitted++
+		o := Occurrence{Start: start, End: start.Add(duration)}
+		if !o.End.After(from) {
+			return true
+		}
+		return fn(o)
+	}
+
+	if (first == 0 && !emit(start)) || r == nil {
+		return
+	}
+
+	// Period i is the i-th repetition after start; for weekly rules it is
+	// the rest of start's week when i is 1 and the next weeks after that.
+	for i := max(first, 1); i < first+maxIterations; i++ {
+		switch r.Freq {
+		case Daily:
+			if !emit(start.AddDate(0, 0, i*r.Interval)) {
+				return
+			}
+		case Monthly:
+			next := start.AddDate(0, i*r.Interval, 0)
+			if next.Day() != start.Day() {
+				// e.g. the 31st in a 30 day month; RFC 5545 skips these.
+				continue
+			}
+			if !emit(next) {
+				return
+			}
+		case Weekly:
+			if !s.emitWeek(start, i-1, emit) {
+				return
+			}
+		}
+	}
+}
+
+// firstPeriod - A period of the rule, as numbered in each, no later than
+// the first one with an occurrence starting after t. It errs a period or
+// two early so daylight saving changes can't make it skip one.
+func (s *Schedule) firstPeriod(start, t time.Time) int {
+	if !t.After(start) {
+		return 0
+	}
+
+	r := s.Rule
+	day := 24 * time.Hour
+	var period int
+	switch r.Freq {
+	case Daily:
+		period = int(t.Sub(start)/(time.Duration(r.Interval)*day)) - 2
+	case Weekly:
+		period = int(t.Sub(start)/(time.Duration(r.Interval)*7*day)) - 1
+	case Monthly:
+		t = t.In(s.Location)
+		months := (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+		period = months/r.Interval - 1
+	}
+	return max(period, 0)
+}
+
+// countBefore - How many occurrences the periods before period produce,
+// for COUNT to carry on from there.
+func (s *Schedule) countBefore(start time.Time, period int) int {
+	if period == 0 {
+		return 0
+	}
+
+	r := s.Rule
+	switch r.Freq {
+	case Monthly:
+		n := 1
+		for i := 1; i < period; i++ {
+			if start.AddDate(0, i*r.Interval, 0).Day() == start.Day() {
+				n++
+			}
+		}
+		return n
+	case Weekly:
+		if period == 1 {
+			return 1
+		}
+		if len(r.ByDay) == 0 {
+			return period - 1
+		}
+		// start, the rest of its week, then whole weeks
+		rest := 0
+		for _, day := range r.ByDay {
+			if mondayOffset(day) > mondayOffset(start.Weekday()) {
+				rest++
+			}
+		}
+		return 1 + rest + (period-2)*len(r.ByDay)
+	default:
+		return period
+	}
+}
+
+// emitWeek - Emits the BYDAY occurrences of the week-th repetition of the
+// week containing start, skipping start itself which is always emitted first.
+func (s *Schedule) emitWeek(start time.Time, week int, emit func(time.Time) bool) bool {
+	days := s.Rule.ByDay
+	if len(days) == 0 {
+		if week == 0 {
+			return true
+		}
+		return emit(start.AddDate(0, 0, 7*week*s.Rule.Interval))
+	}
+
+	weekStart := start.AddDate(0, 0, -mondayOffset(start.Weekday())+7*week*s.Rule.Interval)
+	for _, day := range days {
+		candidate := weekStart.AddDate(0, 0, mondayOffset(day))
+		if !candidate.After(start) {
+			continue
+		}
+		if !emit(candidate) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+package schedule
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// starts - The start times of the first n occurrences, formatted in the
+// schedule's zone.
+func starts(s *Schedule, n int) []string {
+	var out []string
+	s.each(time.Time{}, func(o Occurrence) bool {
+		out = append(out, o.Start.In(s.Location).Format("Mon 2006-01-02 15:04"))
+		return len(out) < n
+	})
+	return out
+}
+
+func mustNew(t *testing.T, start time.Time, rrule, tz string) *Schedule {
+	t.Helper()
+	s, err := New(start, start.Add(time.Hour), rrule, tz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestExpand(t *testing.T) {
+	// Monday
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name  string
+		start time.Time
+		rrule string
+		n     int
+		want  []string
+	}{
+		{"one-off", start, "", 5, []string{"Mon 2026-03-02 09:00"}},
+		{"daily interval", start, "FREQ=DAILY;INTERVAL=2;COUNT=3", 10, []string{
+			"Mon 2026-03-02 09:00", "Wed 2026-03-04 09:00", "Fri 2026-03-06 09:00",
+		}},
+		{"weekly", start, "FREQ=WEEKLY", 3, []string{
+			"Mon 2026-03-02 09:00", "Mon 2026-03-09 09:00", "Mon 2026-03-16 09:00",
+		}},
+		{"weekly byday", start, "FREQ=WEEKLY;BYDAY=FR,MO,WE", 5, []string{
+			"Mon 2026-03-02 09:00", "Wed 2026-03-04 09:00", "Fri 2026-03-06 09:00",
+			"Mon 2026-03-09 09:00", "Wed 2026-03-11 09:00",
+		}},
+		{"weekly byday from midweek", start.AddDate(0, 0, 2), "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4", 10, []string{
+			"Wed 2026-03-04 09:00", "Mon 2026-03-09 09:00", "Wed 2026-03-11 09:00", "Mon 2026-03-16 09:00",
+		}},
+		{"repeated byday", start, "FREQ=WEEKLY;BYDAY=MO,MO", 2, []string{
+			"Mon 2026-03-02 09:00", "Mon 2026-03-09 09:00",
+		}},
+		{"fortnightly byday", start, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", 4, []string{
+			"Mon 2026-03-02 09:00", "Thu 2026-03-05 09:00", "Mon 2026-03-16 09:00", "Thu 2026-03-19 09:00",
+		}},
+		{"monthly skips short months", time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC), "FREQ=MONTHLY", 3, []string{
+			"Sat 2026-01-31 09:00", "Tue 2026-03-31 09:00", "Sun 2026-05-31 09:00",
+		}},
+		{"until date is inclusive", start, "FREQ=DAILY;UNTIL=20260304", 10, []string{
+			"Mon 2026-03-02 09:00", "Tue 2026-03-03 09:00", "Wed 2026-03-04 09:00",
+		}},
+		{"until time", start, "FREQ=DAILY;UNTIL=20260303T085959Z", 10, []string{
+			"Mon 2026-03-02 09:00",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := starts(mustNew(t, tc.start, tc.rrule, ""), tc.n)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got  %q\nwant %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExpandKeepsWallClockAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("zone database unavailable:", err)
+	}
+
+	// Summer time starts on Sunday 2026-03-29
+	s := mustNew(t, time.Date(2026, 3, 23, 9, 0, 0, 0, loc), "FREQ=WEEKLY", "Europe/Berlin")
+	var utc []string
+	s.each(time.Time{}, func(o Occurrence) bool {
+		utc = append(utc, o.Start.UTC().Format("2006-01-02 15:04"))
+		return len(utc) < 2
+	})
+	if want := []string{"2026-03-23 08:00", "2026-03-30 07:00"}; !slices.Equal(utc, want) {
+		t.Errorf("UTC starts = %q, want %q", utc, want)
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	s := mustNew(t, start, "FREQ=DAILY;COUNT=3", "")
+
+	for _, tc := range []struct {
+		at    time.Time
+		want  time.Time
+		found bool
+	}{
+		{start.Add(-time.Hour), start, true},
+		{start.Add(30 * time.Minute), start, true}, // still running
+		{start.Add(time.Hour), start.AddDate(0, 0, 1), true},
+		{start.AddDate(0, 0, 2).Add(59 * time.Minute), start.AddDate(0, 0, 2), true},
+		{start.AddDate(0, 0, 2).Add(time.Hour), time.Time{}, false},
+	} {
+		o, ok := s.NextOccurrence(tc.at)
+		if ok != tc.found || !o.Start.Equal(tc.want) {
+			t.Errorf("NextOccurrence(%s) = %s, %v; want %s, %v", tc.at, o.Start, ok, tc.want, tc.found)
+		}
+	}
+}
+
+func TestNextOccurrenceSkipsAhead(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("zone database unavailable:", err)
+	}
+	// Wednesday
+	start := time.Date(1990, 1, 31, 9, 0, 0, 0, loc)
+	end := time.Date(2027, 1, 1, 0, 0, 0, 0, loc)
+
+	for _, rrule := range []string{
+		"FREQ=DAILY",
+		"FREQ=DAILY;INTERVAL=3;COUNT=4000",
+		"FREQ=WEEKLY;COUNT=1500",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE,SA;COUNT=2000",
+		"FREQ=WEEKLY;BYDAY=TU,FR;UNTIL=20251231",
+		"FREQ=MONTHLY;COUNT=300",
+		"FREQ=MONTHLY;INTERVAL=5",
+	} {
+		t.Run(rrule, func(t *testing.T) {
+			s := mustNew(t, start, rrule, "Europe/Berlin")
+			var all []Occurrence
+			s.each(time.Time{}, func(o Occurrence) bool {
+				all = append(all, o)
+				return o.Start.Before(end)
+			})
+
+			// Walking every occurrence from the start must agree with
+			// skipping ahead, including where COUNT runs out
+			for at := start.Add(-time.Hour); at.Before(end); at = at.Add(53*time.Hour + 17*time.Minute) {
+				want, found := Occurrence{}, false
+				for _, o := range all {
+					if o.End.After(at) {
+						want, found = o, true
+						break
+					}
+				}
+				got, ok := s.NextOccurrence(at)
+				if ok != found || !got.Start.Equal(want.Start) {
+					t.Fatalf("NextOccurrence(%s) = %s, %v; want %s, %v", at, got.Start, ok, want.Start, found)
+				}
+			}
+		})
+	}
+
+	// Further back than maxIterations days
+	s := mustNew(t, time.Date(1700, 1, 1, 9, 0, 0, 0, time.UTC), "FREQ=DAILY", "")
+	o, ok := s.NextOccurrence(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC); !ok || !o.Start.Equal(want) {
+		t.Errorf("NextOccurrence = %s, %v; want %s", o.Start, ok, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	s := mustNew(t, start, "", "")
+	early, grace := 10*time.Minute, 15*time.Minute
+
+	for _, tc := range []struct {
+		at   time.Time
+		want error
+	}{
+		{start.Add(-11 * time.Minute), ErrTooEarly},
+		{start.Add(-10 * time.Minute), nil},
+		{start.Add(time.Hour + 14*time.Minute), nil},
+		{start.Add(time.Hour + 16*time.Minute), ErrEnded},
+	} {
+		o, err := s.Check(tc.at, early, grace)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("Check(%s) = %v, want %v", tc.at.Format(time.Kitchen), err, tc.want)
+		}
+		if err == nil && !o.Start.Equal(start) {
+			t.Errorf("Check(%s) joined %s", tc.at.Format(time.Kitchen), o.Start)
+		}
+	}
+}
+
+func TestNewRejects(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	if _, err := New(start, start, "", ""); err == nil {
+		t.Error("accepted an empty slot")
+	}
+	if _, err := New(start, start.Add(time.Hour), "", "Mars/Olympus"); err == nil {
+		t.Error("accepted an unknown zone")
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"FREQ=WEEKLY;BYDAY=FR,MO", "FREQ=WEEKLY;BYDAY=MO,FR"},
+		{"RRULE:freq=daily;interval=3;count=5", "FREQ=DAILY;INTERVAL=3;COUNT=5"},
+		{"FREQ=MONTHLY;INTERVAL=1;UNTIL=20261231T000000Z", "FREQ=MONTHLY;UNTIL=20261231T000000Z"},
+		{"FREQ=WEEKLY;WKST=MO;BYDAY=SU", "FREQ=WEEKLY;BYDAY=SU"},
+		{"FREQ=WEEKLY;BYDAY=WE,MO,WE", "FREQ=WEEKLY;BYDAY=MO,WE"},
+	} {
+		rule, err := ParseRule(tc.in)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", tc.in, err)
+			continue
+		}
+		if got := rule.String(); got != tc.want {
+			t.Errorf("ParseRule(%q).String() = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20260101",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;WKST=SU",
+		"FREQ=WEEKLY;BYMONTH=1",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ",
+	} {
+		if _, err := ParseRule(in); err == nil {
+			t.Errorf("ParseRule(%q) accepted", in)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package sso signs users in through OpenID Connect providers using the
+// authorization code flow with PKCE. It only establishes who the provider
+// says the user is; linking that identity to an account is up to the
+// caller.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"webrtc/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// httpTimeout - How long a request to a provider may take.
+const httpTimeout = 10 * time.Second
+
+// ErrNoEmail - The provider did not share the user's email address.
+var ErrNoEmail = errors.New("provider returned no email")
+
+// Identity - The claims of a verified ID token that accounts are built from.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool // verified by the provider, or the provider is trusted
+	Name          string
+	Username      string // preferred_username, if any
+}
+
+// Login - The secrets of one sign-in attempt. They are kept by the browser
+// between the redirect to the provider and the callback.
+type Login struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+}
+
+// NewLogin - Generates the secrets for a sign-in attempt.
+func NewLogin() Login {
+	return Login{
+		State:    oauth2.GenerateVerifier(),
+		Nonce:    oauth2.GenerateVerifier(),
+		Verifier: oauth2.GenerateVerifier(),
+	}
+}
+
+// Registry - The configured providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+// New - Creates a Registry. Providers are discovered on first use, so an
+// unreachable provider doesn't stop the server from starting.
+func New(cfg config.OIDC) *Registry {
+	client := &http.Client{Timeout: httpTimeout}
+
+	r := &Registry{providers: make(map[string]*Provider)}
+	for _, p := range cfg.Providers {
+		r.providers[p.Name] = &Provider{cfg: p, client: client}
+	}
+	return r
+}
+
+// Provider - Looks up a provider by name.
+func (r *Registry) Provider(name string) (*Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Providers - Lists the providers ordered by name.
+func (r *Registry) Providers() []*Provider {
+	list := make([]*Provider, 0, len(r.providers))
+	for _, p := range r.providers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].cfg.Name < list[j].cfg.Name })
+	return list
+}
+
+// Provider - One OpenID Connect provider.
+type Provider struct {
+	cfg    config.OIDCProvider
+	client *http.Client
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Name - The provider's configured name.
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// DisplayName - The name to show on sign-in buttons.
+func (p *Provider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+// AuthCodeURL - The provider URL to send the browser to.
+func (p *Provider) AuthCodeURL(ctx context.Context, login Login) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(login.State,
+		oidc.Nonce(login.Nonce),
+		oauth2.S256ChallengeOption(login.Verifier),
+	), nil
+}
+
+// Exchange - Redeems the authorization code from the callback and verifies
+// the ID token it returns. The caller must already have checked the state.
+func (p *Provider) Exchange(ctx context.Context, code string, login Login) (Identity, error) {
+	oauth, verifier, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchange code: %w", err)
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return Identity{}, fmt.Errorf("verify id token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return Identity{}, errors.New("id token nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("decode id token claims: %w", err)
+	}
+	if claims.Email == "" {
+		return Identity{}, ErrNoEmail
+	}
+
+	return Identity{
+		Provider:      p.cfg.Name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: p.cfg.TrustEmail || (claims.EmailVerified != nil && *claims.EmailVerified),
+		Name:          claims.Name,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+// discover - Fetches the provider's metadata once. A failed discovery is
+// retried on the next call.
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover %s: %w", p.cfg.Name, err)
+	}
+
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range p.cfg.Scopes {
+		if scope != oidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth, p.verifier, nil
+}
@@ -1,29 +1,173 @@
 package utils
 
 import (
-	"log"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"webrtc/config"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword - Hash password.
-func HashPassword(password string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrUnknownHash - The stored hash is in no format the hasher understands.
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher - Hashes and verifies passwords under the configured
+// policy. Verify accepts bcrypt and argon2id hashes regardless of the
+// policy so hashes can be migrated on sign in.
+type PasswordHasher struct {
+	cfg config.Password
+	// dummy is verified against when there is no real hash to check, so
+	// that takes as long as a real mismatch.
+	dummy string
+}
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// NewPasswordHasher - Creates a hasher for the policy.
+func NewPasswordHasher(cfg config.Password) (*PasswordHasher, error) {
+	h := &PasswordHasher{cfg: cfg}
+
+	dummy, err := h.Hash("dummy password for timing")
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+	h.dummy = dummy
+	return h, nil
+}
+
+// Hash - Hashes a password under the current policy.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	switch h.cfg.Algorithm {
+	case "argon2id":
+		return h.hashArgon2(password)
+	case "bcrypt":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("hash password: %w", err)
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("unknown password hash algorithm %q", h.cfg.Algorithm)
 	}
+}
 
-	return string(hash)
+// Verify - Reports whether password matches hash. A mismatch is not an
+// error; a malformed hash is.
+func (h *PasswordHasher) Verify(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2(hash, password)
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("verify password: %w", err)
+		}
+		return true, nil
+	default:
+		return false, ErrUnknownHash
+	}
 }
 
-// ComparePasswords - Checks if two password match.
-func ComparePasswords(hashedPwd string, plainPwd []byte) bool {
-	byteHash := []byte(hashedPwd)
-	err := bcrypt.CompareHashAndPassword(byteHash, plainPwd)
-	if err != nil {
-		log.Println(err)
+// VerifyDummy - Spends the time of a failed Verify without a stored hash,
+// so requests for unknown accounts can't be told apart by timing.
+func (h *PasswordHasher) VerifyDummy(password string) {
+	_, _ = h.Verify(h.dummy, password)
+}
+
+// NeedsRehash - Reports whether hash was made under a different policy and
+// should be replaced the next time the password is known.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	switch h.cfg.Algorithm {
+	case "bcrypt":
+		if !isBcrypt(hash) {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.cfg.BcryptCost
+	case "argon2id":
+		params, _, _, err := decodeArgon2(hash)
+		return err != nil || params != h.argon2Params()
+	default:
 		return false
 	}
+}
+
+func (h *PasswordHasher) argon2Params() argon2Params {
+	return argon2Params{
+		time:    uint32(h.cfg.Argon2.Time),
+		memory:  uint32(h.cfg.Argon2.MemoryKB),
+		threads: uint8(h.cfg.Argon2.Threads),
+	}
+}
+
+// hashArgon2 - Encodes in the PHC string format used by the reference
+// implementation: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func (h *PasswordHasher) hashArgon2(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+
+	p := h.argon2Params()
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func verifyArgon2(hash, password string) (bool, error) {
+	p, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func decodeArgon2(hash string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2 parameters: %w", err)
+	}
 
-	return true
-} 
\ No newline at end of file
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2 salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2 key: %w", err)
+	}
+	return p, salt, key, nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
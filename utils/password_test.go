@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"webrtc/config"
+)
+
+func bcryptPolicy(cost int) config.Password {
+	return config.Password{Algorithm: "bcrypt", BcryptCost: cost}
+}
+
+func argon2Policy(time, memoryKB, threads int) config.Password {
+	return config.Password{
+		Algorithm: "argon2id",
+		Argon2:    config.Argon2{Time: time, MemoryKB: memoryKB, Threads: threads},
+	}
+}
+
+func newHasher(t *testing.T, cfg config.Password) *PasswordHasher {
+	t.Helper()
+	h, err := NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func mustHash(t *testing.T, h *PasswordHasher, password string) string {
+	t.Helper()
+	hash, err := h.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for name, cfg := range map[string]config.Password{
+		"bcrypt":   bcryptPolicy(4),
+		"argon2id": argon2Policy(1, 64, 1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newHasher(t, cfg)
+			hash := mustHash(t, h, "secret123")
+			if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+				t.Errorf("hash = %q", hash)
+			}
+			if other := mustHash(t, h, "secret123"); other == hash {
+				t.Error("hashes are not salted")
+			}
+
+			if ok, err := h.Verify(hash, "secret123"); !ok || err != nil {
+				t.Errorf("Verify(right) = %v, %v", ok, err)
+			}
+			if ok, err := h.Verify(hash, "secret124"); ok || err != nil {
+				t.Errorf("Verify(wrong) = %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestVerifyAcceptsEitherFormat(t *testing.T) {
+	bcryptHash := mustHash(t, newHasher(t, bcryptPolicy(4)), "secret123")
+	argon2Hash := mustHash(t, newHasher(t, argon2Policy(1, 64, 1)), "secret123")
+
+	h := newHasher(t, argon2Policy(2, 128, 1))
+	for _, hash := range []string{bcryptHash, argon2Hash} {
+		if ok, err := h.Verify(hash, "secret123"); !ok || err != nil {
+			t.Errorf("Verify(%.10s...) = %v, %v", hash, ok, err)
+		}
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	h := newHasher(t, bcryptPolicy(4))
+
+	if _, err := h.Verify("plaintext", "plaintext"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("plain text hash: err = %v, want ErrUnknownHash", err)
+	}
+	for _, hash := range []string{
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$2a$04$short",
+	} {
+		if ok, err := h.Verify(hash, "secret123"); ok || err == nil {
+			t.Errorf("Verify(%q) = %v, %v; want an error", hash, ok, err)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcrypt4 := mustHash(t, newHasher(t, bcryptPolicy(4)), "secret123")
+	bcrypt5 := mustHash(t, newHasher(t, bcryptPolicy(5)), "secret123")
+	argon2Small := mustHash(t, newHasher(t, argon2Policy(1, 64, 1)), "secret123")
+	argon2Large := mustHash(t, newHasher(t, argon2Policy(1, 128, 1)), "secret123")
+
+	for _, tc := range []struct {
+		name   string
+		policy config.Password
+		hash   string
+		want   bool
+	}{
+		{"bcrypt same cost", bcryptPolicy(4), bcrypt4, false},
+		{"bcrypt higher cost", bcryptPolicy(4), bcrypt5, false},
+		{"bcrypt lower cost", bcryptPolicy(5), bcrypt4, true},
+		{"argon2 to bcrypt", bcryptPolicy(4), argon2Small, true},
+		{"bcrypt to argon2", argon2Policy(1, 64, 1), bcrypt4, true},
+		{"argon2 same params", argon2Policy(1, 64, 1), argon2Small, false},
+		{"argon2 more memory", argon2Policy(1, 128, 1), argon2Small, true},
+		{"argon2 less memory", argon2Policy(1, 64, 1), argon2Large, true},
+		{"argon2 more time", argon2Policy(2, 64, 1), argon2Small, true},
+		{"argon2 more threads", argon2Policy(1, 64, 2), argon2Small, true},
+		{"malformed", bcryptPolicy(4), "$2a$xx", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newHasher(t, tc.policy)
+			if got := h.NeedsRehash(tc.hash); got != tc.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	if _, err := NewPasswordHasher(config.Password{Algorithm: "md5"}); err == nil {
+		t.Error("accepted an unknown algorithm")
+	}
+}
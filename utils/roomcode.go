@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// RoomCodeGenerator - Generates random, human friendly room codes such as
+// "abc-defg-hij".
+type RoomCodeGenerator struct {
+	alphabet []rune
+	groups   []int
+}
+
+// NewRoomCodeGenerator - Creates a generator drawing characters from alphabet.
+// pattern lists the group lengths separated by dashes, e.g. "3-4-3"; a
+// single number produces codes without separators.
+func NewRoomCodeGenerator(alphabet, pattern string) (*RoomCodeGenerator, error) {
+	seen := map[rune]bool{}
+	var runes []rune
+	for _, r := range alphabet {
+		if r == '-' {
+			return nil, errors.New("room code alphabet must not contain '-'")
+		}
+		if !seen[r] {
+			seen[r] = true
+			runes = append(runes, r)
+		}
+	}
+	if len(runes) < 2 {
+		return nil, errors.New("room code alphabet needs at least two distinct characters")
+	}
+
+	var groups []int
+	for _, part := range strings.Split(pattern, "-") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid room code pattern %q", pattern)
+		}
+		groups = append(groups, n)
+	}
+
+	return &RoomCodeGenerator{alphabet: runes, groups: groups}, nil
+}
+
+// Generate - Returns a new random room code.
+func (g *RoomCodeGenerator) Generate() (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+
+	var b strings.Builder
+	for i, n := range g.groups {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		for j := 0; j < n; j++ {
+			idx, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(g.alphabet[idx.Int64()])
+		}
+	}
+
+	return b.String(), nil
+}
+
+// RandomToken - Returns n random bytes encoded as hex.
+func RandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRoomCodeGenerate(t *testing.T) {
+	for _, tc := range []struct {
+		alphabet, pattern string
+		want              *regexp.Regexp
+	}{
+		{"abcdefghijklmnopqrstuvwxyz", "3-4-3", regexp.MustCompile(`^[a-z]{3}-[a-z]{4}-[a-z]{3}$`)},
+		{"0123456789", "6", regexp.MustCompile(`^[0-9]{6}$`)},
+		{"äöü", " 2 - 2 ", regexp.MustCompile(`^[äöü]{2}-[äöü]{2}$`)},
+	} {
+		g, err := NewRoomCodeGenerator(tc.alphabet, tc.pattern)
+		if err != nil {
+			t.Fatalf("NewRoomCodeGenerator(%q, %q): %v", tc.alphabet, tc.pattern, err)
+		}
+
+		seen := map[string]bool{}
+		for i := 0; i < 50; i++ {
+			code, err := g.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.want.MatchString(code) {
+				t.Errorf("code %q does not match %s", code, tc.want)
+			}
+			seen[code] = true
+		}
+		if len(seen) < 10 {
+			t.Errorf("%q/%q: only %d distinct codes in 50", tc.alphabet, tc.pattern, len(seen))
+		}
+	}
+}
+
+func TestRoomCodeUsesWholeAlphabet(t *testing.T) {
+	g, err := NewRoomCodeGenerator("aabc", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]int{}
+	for i := 0; i < 300; i++ {
+		code, _ := g.Generate()
+		seen[code]++
+	}
+	if len(seen) != 3 {
+		t.Errorf("codes drawn = %v, want each of a, b and c", seen)
+	}
+	// Duplicates in the alphabet don't weigh the draw
+	for code, n := range seen {
+		if n < 50 {
+			t.Errorf("%q drawn %d times in 300", code, n)
+		}
+	}
+}
+
+func TestNewRoomCodeGeneratorRejects(t *testing.T) {
+	for _, tc := range []struct{ alphabet, pattern string }{
+		{"a", "3"},
+		{"aaaa", "3"},
+		{"ab-", "3"},
+		{"ab", ""},
+		{"ab", "3-"},
+		{"ab", "3-0"},
+		{"ab", "x"},
+	} {
+		if _, err := NewRoomCodeGenerator(tc.alphabet, tc.pattern); err == nil {
+			t.Errorf("NewRoomCodeGenerator(%q, %q) accepted", tc.alphabet, tc.pattern)
+		}
+	}
+}
+
+func TestRandomToken(t *testing.T) {
+	a, err := RandomToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := RandomToken(16)
+	if len(a) != 32 || a == b {
+		t.Errorf("tokens %q and %q", a, b)
+	}
+}